@@ -0,0 +1,197 @@
+package mc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	p2p_crypto "github.com/libp2p/go-libp2p-crypto"
+	p2p_peer "github.com/libp2p/go-libp2p-peer"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+var (
+	BadArmoredKey = errors.New("Bad armored key")
+	BadKeyType    = errors.New("Unknown key type")
+	KeyExists     = errors.New("Key file already exists")
+)
+
+// Armored keys: key files are exported in a portable PEM format, with the
+// identity and fingerprint of the key in the headers.
+// The armored data is the key file verbatim, so encrypted keys remain
+// encrypted and can be imported into any node that knows the passphrase.
+const (
+	NodeKeyType      = "node"
+	PublisherKeyType = "publisher"
+)
+
+var armorLabels = map[string]string{
+	NodeKeyType:      "MEDIACHAIN NODE KEY",
+	PublisherKeyType: "MEDIACHAIN PUBLISHER KEY",
+}
+
+type KeyInfo struct {
+	Type        string `json:"type"`
+	Id          string `json:"id"`
+	Fingerprint string `json:"fingerprint"`
+	Encrypted   bool   `json:"encrypted"`
+}
+
+// KeyFingerprint returns the SHA-256 fingerprint of a public key
+func KeyFingerprint(pubk p2p_crypto.PubKey) (string, error) {
+	bytes, err := pubk.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(bytes)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(hash[:]), nil
+}
+
+func keyInfo(ktype string, data []byte) (empty KeyInfo, err error) {
+	_, pubk, err := unmarshalKey(data, nil)
+	if err != nil {
+		return
+	}
+
+	var id string
+	switch ktype {
+	case NodeKeyType:
+		var pid p2p_peer.ID
+		pid, err = p2p_peer.IDFromPublicKey(pubk)
+		if err != nil {
+			return
+		}
+		id = pid.Pretty()
+
+	case PublisherKeyType:
+		id, err = PublisherID58(pubk)
+		if err != nil {
+			return
+		}
+
+	default:
+		return empty, BadKeyType
+	}
+
+	fpr, err := KeyFingerprint(pubk)
+	if err != nil {
+		return
+	}
+
+	return KeyInfo{Type: ktype, Id: id, Fingerprint: fpr, Encrypted: isEncryptedKey(data)}, nil
+}
+
+// KeyFileInfo returns the identity of the key file in kpath, which doesn't
+// need to be decrypted.
+func KeyFileInfo(kpath string, ktype string) (empty KeyInfo, err error) {
+	data, err := ioutil.ReadFile(kpath)
+	if err != nil {
+		return
+	}
+
+	return keyInfo(ktype, data)
+}
+
+// ArmorKeyFile exports the key file in kpath as an armored key
+func ArmorKeyFile(kpath string, ktype string) ([]byte, error) {
+	data, err := ioutil.ReadFile(kpath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := keyInfo(ktype, data)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := "no"
+	if info.Encrypted {
+		encrypted = "yes"
+	}
+
+	block := &pem.Block{
+		Type: armorLabels[ktype],
+		Headers: map[string]string{
+			"Id":          info.Id,
+			"Fingerprint": info.Fingerprint,
+			"Encrypted":   encrypted,
+		},
+		Bytes: data,
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// ParseArmoredKey parses an armored key, and checks its headers against the
+// key; returns the key identity and the key file data.
+func ParseArmoredKey(armor []byte) (empty KeyInfo, data []byte, err error) {
+	block, _ := pem.Decode(armor)
+	if block == nil {
+		return empty, nil, BadArmoredKey
+	}
+
+	var ktype string
+	for xtype, label := range armorLabels {
+		if block.Type == label {
+			ktype = xtype
+			break
+		}
+	}
+
+	if ktype == "" {
+		return empty, nil, BadKeyType
+	}
+
+	info, err := keyInfo(ktype, block.Bytes)
+	if err != nil {
+		return empty, nil, err
+	}
+
+	if block.Headers["Id"] != info.Id || block.Headers["Fingerprint"] != info.Fingerprint {
+		return empty, nil, BadArmoredKey
+	}
+
+	return info, block.Bytes, nil
+}
+
+// ImportArmoredKey saves an armored key of type ktype to kpath, which must
+// not exist.
+func ImportArmoredKey(kpath string, ktype string, armor []byte) (empty KeyInfo, err error) {
+	info, data, err := ParseArmoredKey(armor)
+	if err != nil {
+		return
+	}
+
+	if info.Type != ktype {
+		return empty, BadKeyType
+	}
+
+	err = os.MkdirAll(path.Dir(kpath), 0700)
+	if err != nil {
+		return
+	}
+
+	out, err := os.OpenFile(kpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	switch {
+	case os.IsExist(err):
+		return empty, KeyExists
+	case err != nil:
+		return
+	}
+
+	_, err = out.Write(data)
+	if err != nil {
+		out.Close()
+		return
+	}
+
+	err = out.Close()
+	if err != nil {
+		return
+	}
+
+	return info, nil
+}
@@ -0,0 +1,65 @@
+package mc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestArmoredKey(t *testing.T) {
+	home, err := ioutil.TempDir("", "armor")
+	checkErrorNow(t, "tempdir", err)
+	defer os.RemoveAll(home)
+
+	pub, err := MakePublisherIdentity(home, []byte("passphrase"))
+	checkErrorNow(t, "generate", err)
+
+	kpath := path.Join(home, "identity.publisher")
+	armor, err := ArmorKeyFile(kpath, PublisherKeyType)
+	checkErrorNow(t, "export", err)
+
+	info, _, err := ParseArmoredKey(armor)
+	checkErrorNow(t, "parse", err)
+	if info.Id != pub.ID58 || info.Type != PublisherKeyType || !info.Encrypted {
+		t.Fatalf("bad armored key info: %+v", info)
+	}
+
+	_, err = ImportArmoredKey(path.Join(home, "import", "identity.node"), NodeKeyType, armor)
+	if err != BadKeyType {
+		t.Fatalf("expected BadKeyType; got %v", err)
+	}
+
+	ipath := path.Join(home, "import", "identity.publisher")
+	_, err = ImportArmoredKey(ipath, PublisherKeyType, armor)
+	checkErrorNow(t, "import", err)
+
+	orig, err := ioutil.ReadFile(kpath)
+	checkErrorNow(t, "read", err)
+	imported, err := ioutil.ReadFile(ipath)
+	checkErrorNow(t, "read", err)
+	if !bytes.Equal(orig, imported) {
+		t.Fatal("imported key file differs from the original")
+	}
+
+	ipub, err := MakePublisherIdentity(path.Join(home, "import"), []byte("passphrase"))
+	checkErrorNow(t, "load imported", err)
+	if ipub.ID58 != pub.ID58 || !ipub.PrivKey.Equals(pub.PrivKey) {
+		t.Fatal("imported key mismatch")
+	}
+
+	_, err = ImportArmoredKey(ipath, PublisherKeyType, armor)
+	if err != KeyExists {
+		t.Fatalf("expected KeyExists; got %v", err)
+	}
+
+	// the headers must match the key
+	other, err := NewPublisherIdentity()
+	checkErrorNow(t, "generate", err)
+	bad := bytes.Replace(armor, []byte(pub.ID58), []byte(other.ID58), 1)
+	_, _, err = ParseArmoredKey(bad)
+	if err != BadArmoredKey {
+		t.Fatalf("expected BadArmoredKey; got %v", err)
+	}
+}
@@ -0,0 +1,567 @@
+package mc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	multihash "github.com/multiformats/go-multihash"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+)
+
+// Minimal CBOR codec for converting datastore objects to and from JSON.
+// The JSON representation follows the IPLD conventions:
+//  merkle links (tag 42) are rendered as {"/": "Qm..."}, or {"/": "b..."}
+//  for CIDv1 links
+//  byte strings are rendered as {"/": {"bytes": "<base64>"}}
+// Encoding produces canonical CBOR: shortest integer and length encodings,
+// definite lengths and map keys sorted by length and then bytewise.
+
+var (
+	BadCBOR         = errors.New("Malformed CBOR object")
+	UnsupportedCBOR = errors.New("CBOR value can't be represented in JSON")
+	BadJSONValue    = errors.New("Unexpected JSON value")
+	BadLink         = errors.New("Bad merkle link")
+)
+
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+
+	cborTagLink  = 42
+	cborBreak    = 0xff
+	cborMaxDepth = 256
+)
+
+// CBORToJSON decodes a CBOR object into a value that can be marshalled to JSON
+func CBORToJSON(data []byte) (interface{}, error) {
+	dec := &cborDecoder{data: data}
+	val, err := dec.decode(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if dec.pos != len(data) {
+		return nil, BadCBOR
+	}
+
+	return val, nil
+}
+
+// CBORLinks returns the merkle links (tag 42) contained in a CBOR object
+func CBORLinks(data []byte) ([]multihash.Multihash, error) {
+	links := make([]multihash.Multihash, 0)
+	dec := &cborDecoder{data: data, links: &links}
+	_, err := dec.decode(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if dec.pos != len(data) {
+		return nil, BadCBOR
+	}
+
+	return links, nil
+}
+
+// JSONToCBOR encodes a JSON value in canonical CBOR.
+// The value should be decoded with json.Decoder.UseNumber, so that integers
+// are preserved.
+func JSONToCBOR(val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := cborEncode(&buf, val, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+type cborDecoder struct {
+	data  []byte
+	pos   int
+	links *[]multihash.Multihash // collects merkle links when non-nil
+}
+
+// head reads the initial byte and argument of a data item
+func (dec *cborDecoder) head() (major byte, arg uint64, indef bool, err error) {
+	if dec.pos >= len(dec.data) {
+		return 0, 0, false, BadCBOR
+	}
+
+	ib := dec.data[dec.pos]
+	dec.pos++
+
+	major = ib >> 5
+	info := ib & 0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), false, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == 31:
+		return major, 0, true, nil
+	default:
+		return 0, 0, false, BadCBOR
+	}
+
+	if len(dec.data)-dec.pos < size {
+		return 0, 0, false, BadCBOR
+	}
+
+	buf := dec.data[dec.pos : dec.pos+size]
+	dec.pos += size
+
+	switch size {
+	case 1:
+		arg = uint64(buf[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(buf))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(buf))
+	case 8:
+		arg = binary.BigEndian.Uint64(buf)
+	}
+
+	return major, arg, false, nil
+}
+
+func (dec *cborDecoder) isBreak() bool {
+	if dec.pos < len(dec.data) && dec.data[dec.pos] == cborBreak {
+		dec.pos++
+		return true
+	}
+	return false
+}
+
+func (dec *cborDecoder) bytes(arg uint64) ([]byte, error) {
+	if arg > uint64(len(dec.data)-dec.pos) {
+		return nil, BadCBOR
+	}
+
+	n := int(arg)
+	buf := dec.data[dec.pos : dec.pos+n]
+	dec.pos += n
+	return buf, nil
+}
+
+// chunks reads a (possibly indefinite length) byte or text string
+func (dec *cborDecoder) chunks(major byte, arg uint64, indef bool) ([]byte, error) {
+	if !indef {
+		return dec.bytes(arg)
+	}
+
+	var res []byte
+	for !dec.isBreak() {
+		xmajor, xarg, xindef, err := dec.head()
+		if err != nil {
+			return nil, err
+		}
+
+		if xmajor != major || xindef {
+			return nil, BadCBOR
+		}
+
+		buf, err := dec.bytes(xarg)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, buf...)
+	}
+
+	return res, nil
+}
+
+func (dec *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, BadCBOR
+	}
+
+	start := dec.pos
+	major, arg, indef, err := dec.head()
+	if err != nil {
+		return nil, err
+	}
+
+	if indef {
+		switch major {
+		case cborBytes, cborText, cborArray, cborMap:
+		default:
+			return nil, BadCBOR
+		}
+	}
+
+	switch major {
+	case cborUint:
+		if arg > math.MaxInt64 {
+			return new(big.Int).SetUint64(arg), nil
+		}
+		return int64(arg), nil
+
+	case cborNegint:
+		if arg > math.MaxInt64 {
+			n := new(big.Int).SetUint64(arg)
+			return n.Neg(n.Add(n, big.NewInt(1))), nil
+		}
+		return -1 - int64(arg), nil
+
+	case cborBytes:
+		buf, err := dec.chunks(major, arg, indef)
+		if err != nil {
+			return nil, err
+		}
+		return jsonBytes(buf), nil
+
+	case cborText:
+		buf, err := dec.chunks(major, arg, indef)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf), nil
+
+	case cborArray:
+		var res []interface{}
+		if indef {
+			res = make([]interface{}, 0)
+			for !dec.isBreak() {
+				val, err := dec.decode(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, val)
+			}
+			return res, nil
+		}
+
+		// each item takes at least a byte; don't trust the length for allocation
+		if arg > uint64(len(dec.data)-dec.pos) {
+			return nil, BadCBOR
+		}
+
+		res = make([]interface{}, int(arg))
+		for x := range res {
+			res[x], err = dec.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+
+	case cborMap:
+		res := make(map[string]interface{})
+		for x := uint64(0); indef || x < arg; x++ {
+			if indef && dec.isBreak() {
+				break
+			}
+
+			key, err := dec.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			skey, ok := key.(string)
+			if !ok {
+				return nil, UnsupportedCBOR
+			}
+
+			val, err := dec.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			res[skey] = val
+		}
+		return res, nil
+
+	case cborTag:
+		val, err := dec.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if arg != cborTagLink {
+			return val, nil
+		}
+
+		link, err := linkFromTag(val)
+		if err != nil {
+			return nil, err
+		}
+
+		if dec.links != nil {
+			*dec.links = append(*dec.links, link.Hash)
+		}
+
+		return map[string]interface{}{"/": link.String()}, nil
+
+	default: // simple values and floats
+		switch {
+		case indef:
+			return nil, BadCBOR
+
+		case dec.data[start] == 0xf9:
+			return jsonFloat(halfToFloat64(uint16(arg)))
+
+		case dec.data[start] == 0xfa:
+			return jsonFloat(float64(math.Float32frombits(uint32(arg))))
+
+		case dec.data[start] == 0xfb:
+			return jsonFloat(math.Float64frombits(arg))
+
+		case dec.data[start] == 0xf4:
+			return false, nil
+
+		case dec.data[start] == 0xf5:
+			return true, nil
+
+		case dec.data[start] == 0xf6, dec.data[start] == 0xf7:
+			return nil, nil
+
+		default:
+			return nil, UnsupportedCBOR
+		}
+	}
+}
+
+func jsonBytes(buf []byte) map[string]interface{} {
+	return map[string]interface{}{
+		"/": map[string]interface{}{"bytes": base64.RawStdEncoding.EncodeToString(buf)},
+	}
+}
+
+func jsonFloat(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, UnsupportedCBOR
+	}
+	return f, nil
+}
+
+// links are tagged byte strings, with a 0 prefix followed by the binary CID
+func linkFromTag(val interface{}) (empty CID, err error) {
+	xval, ok := val.(map[string]interface{})
+	if !ok {
+		return empty, BadLink
+	}
+
+	xbytes, ok := xval["/"].(map[string]interface{})
+	if !ok {
+		return empty, BadLink
+	}
+
+	enc, ok := xbytes["bytes"].(string)
+	if !ok {
+		return empty, BadLink
+	}
+
+	buf, err := base64.RawStdEncoding.DecodeString(enc)
+	if err != nil || len(buf) < 2 || buf[0] != 0 {
+		return empty, BadLink
+	}
+
+	cid, err := DecodeCID(buf[1:])
+	if err != nil {
+		return empty, BadLink
+	}
+
+	return cid, nil
+}
+
+func halfToFloat64(h uint16) float64 {
+	exp := int((h >> 10) & 0x1f)
+	mant := float64(h & 0x3ff)
+
+	var val float64
+	switch exp {
+	case 0:
+		val = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			val = math.Inf(1)
+		} else {
+			val = math.NaN()
+		}
+	default:
+		val = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -val
+	}
+	return val
+}
+
+func cborEncodeHead(buf *bytes.Buffer, major byte, arg uint64) {
+	major <<= 5
+	switch {
+	case arg < 24:
+		buf.WriteByte(major | byte(arg))
+	case arg <= math.MaxUint8:
+		buf.WriteByte(major | 24)
+		buf.WriteByte(byte(arg))
+	case arg <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		binary.Write(buf, binary.BigEndian, uint16(arg))
+	case arg <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		binary.Write(buf, binary.BigEndian, uint32(arg))
+	default:
+		buf.WriteByte(major | 27)
+		binary.Write(buf, binary.BigEndian, arg)
+	}
+}
+
+func cborEncode(buf *bytes.Buffer, val interface{}, depth int) error {
+	if depth > cborMaxDepth {
+		return BadJSONValue
+	}
+
+	switch val := val.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+
+	case bool:
+		if val {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+
+	case json.Number:
+		return cborEncodeNumber(buf, val)
+
+	case float64:
+		return cborEncodeNumber(buf, json.Number(strconv.FormatFloat(val, 'g', -1, 64)))
+
+	case string:
+		cborEncodeHead(buf, cborText, uint64(len(val)))
+		buf.WriteString(val)
+
+	case []interface{}:
+		cborEncodeHead(buf, cborArray, uint64(len(val)))
+		for _, xval := range val {
+			err := cborEncode(buf, xval, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+
+	case map[string]interface{}:
+		if len(val) == 1 {
+			if special, ok := val["/"]; ok {
+				return cborEncodeSpecial(buf, special)
+			}
+		}
+
+		keys := make([]string, 0, len(val))
+		for key, _ := range val {
+			keys = append(keys, key)
+		}
+		sort.Sort(cborKeys(keys))
+
+		cborEncodeHead(buf, cborMap, uint64(len(keys)))
+		for _, key := range keys {
+			cborEncodeHead(buf, cborText, uint64(len(key)))
+			buf.WriteString(key)
+
+			err := cborEncode(buf, val[key], depth+1)
+			if err != nil {
+				return err
+			}
+		}
+
+	default:
+		return BadJSONValue
+	}
+
+	return nil
+}
+
+func cborEncodeNumber(buf *bytes.Buffer, num json.Number) error {
+	if i, err := strconv.ParseInt(string(num), 10, 64); err == nil {
+		if i >= 0 {
+			cborEncodeHead(buf, cborUint, uint64(i))
+		} else {
+			cborEncodeHead(buf, cborNegint, uint64(-1-i))
+		}
+		return nil
+	}
+
+	if u, err := strconv.ParseUint(string(num), 10, 64); err == nil {
+		cborEncodeHead(buf, cborUint, u)
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(string(num), 64)
+	if err != nil {
+		return err
+	}
+
+	buf.WriteByte(0xfb)
+	return binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+}
+
+// {"/": "Qm..."} is a merkle link; {"/": {"bytes": "..."}} is a byte string
+func cborEncodeSpecial(buf *bytes.Buffer, val interface{}) error {
+	switch val := val.(type) {
+	case string:
+		cid, err := ParseCID(val)
+		if err != nil {
+			return BadLink
+		}
+
+		link := cid.Bytes()
+		cborEncodeHead(buf, cborTag, cborTagLink)
+		cborEncodeHead(buf, cborBytes, uint64(len(link)+1))
+		buf.WriteByte(0)
+		buf.Write(link)
+		return nil
+
+	case map[string]interface{}:
+		enc, ok := val["bytes"].(string)
+		if !ok || len(val) != 1 {
+			return BadJSONValue
+		}
+
+		data, err := base64.RawStdEncoding.DecodeString(enc)
+		if err != nil {
+			return err
+		}
+
+		cborEncodeHead(buf, cborBytes, uint64(len(data)))
+		buf.Write(data)
+		return nil
+
+	default:
+		return BadJSONValue
+	}
+}
+
+// canonical CBOR map key order: shorter keys first, then bytewise
+type cborKeys []string
+
+func (keys cborKeys) Len() int      { return len(keys) }
+func (keys cborKeys) Swap(i, j int) { keys[i], keys[j] = keys[j], keys[i] }
+func (keys cborKeys) Less(i, j int) bool {
+	if len(keys[i]) != len(keys[j]) {
+		return len(keys[i]) < len(keys[j])
+	}
+	return keys[i] < keys[j]
+}
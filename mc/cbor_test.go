@@ -0,0 +1,168 @@
+package mc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+// canonical encodings, from RFC 7049 Appendix A
+var cborvectors = []struct {
+	json string
+	cbor string
+}{
+	{`0`, "00"},
+	{`23`, "17"},
+	{`24`, "1818"},
+	{`1000`, "1903e8"},
+	{`1000000`, "1a000f4240"},
+	{`1000000000000`, "1b000000e8d4a51000"},
+	{`18446744073709551615`, "1bffffffffffffffff"},
+	{`-1`, "20"},
+	{`-1000`, "3903e7"},
+	{`1.1`, "fb3ff199999999999a"},
+	{`false`, "f4"},
+	{`true`, "f5"},
+	{`null`, "f6"},
+	{`""`, "60"},
+	{`"IETF"`, "6449455446"},
+	{`"ü"`, "62c3bc"},
+	{`[]`, "80"},
+	{`[1,[2,3],[4,5]]`, "8301820203820405"},
+	{`{}`, "a0"},
+	{`{"a":1,"b":[2,3]}`, "a26161016162820203"},
+	{`{"bb":1,"a":2}`, "a261610262626201"},
+	{`{"/":{"bytes":"AQIDBA"}}`, "4401020304"},
+	{`{"/":"QmRN6wdp1S2A5EtjW9A3M1vKSBuQQGcgvuhoMUoEz4iiT5"}`,
+		"d82a58230012202cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+	{`{"/":"bafyreibm6jg3ux5qumhcn2b3flc3tyu6dmlb4xa7u5bf44yegnrjhc4yeq"}`,
+		"d82a582500017112202cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+}
+
+// non-canonical or JSON incompatible encodings
+var cbordecode = []struct {
+	cbor string
+	json string
+}{
+	{"f90000", `0`},
+	{"f90014", `0.0000011920928955078125`},
+	{"f93c00", `1`},
+	{"f97bff", `65504`},
+	{"fa47c35000", `100000`},
+	{"3bffffffffffffffff", `-18446744073709551616`},
+	{"c11a514b67b0", `1363896240`},
+	{"5f42010243030405ff", `{"/":{"bytes":"AQIDBAU"}}`},
+	{"7f657374726561646d696e67ff", `"streaming"`},
+	{"9fff", `[]`},
+	{"9f018202039f0405ffff", `[1,[2,3],[4,5]]`},
+	{"bf61610161629f0203ffff", `{"a":1,"b":[2,3]}`},
+}
+
+var cborbad = []string{
+	"",
+	"18",
+	"1c",
+	"62c3",
+	"8301",
+	"a161",
+	"0000",
+	"f97c00",
+	"f814",
+	"f97e00",
+	"a10102",
+	"5f6161ff",
+	"d82a4401020304",
+	"9bffffffffffffffff",
+}
+
+func TestCBOREncode(t *testing.T) {
+	for _, v := range cborvectors {
+		dec := json.NewDecoder(bytes.NewReader([]byte(v.json)))
+		dec.UseNumber()
+
+		var val interface{}
+		err := dec.Decode(&val)
+		checkErrorNow(t, v.json, err)
+
+		data, err := JSONToCBOR(val)
+		checkErrorNow(t, v.json, err)
+
+		xhex := hex.EncodeToString(data)
+		if xhex != v.cbor {
+			t.Errorf("%s: expected %s; got %s", v.json, v.cbor, xhex)
+		}
+	}
+}
+
+func TestCBORDecode(t *testing.T) {
+	for _, v := range cborvectors {
+		checkDecode(t, v.cbor, v.json)
+	}
+
+	for _, v := range cbordecode {
+		checkDecode(t, v.cbor, v.json)
+	}
+
+	for _, v := range cborbad {
+		data, err := hex.DecodeString(v)
+		checkErrorNow(t, v, err)
+
+		_, err = CBORToJSON(data)
+		if err == nil {
+			t.Errorf("%s: expected error", v)
+		}
+	}
+}
+
+func checkDecode(t *testing.T, cbor string, xjson string) {
+	data, err := hex.DecodeString(cbor)
+	checkErrorNow(t, cbor, err)
+
+	val, err := CBORToJSON(data)
+	checkErrorNow(t, cbor, err)
+
+	res, err := json.Marshal(val)
+	checkErrorNow(t, cbor, err)
+
+	// normalize map key order in the expected value
+	dec := json.NewDecoder(bytes.NewReader([]byte(xjson)))
+	dec.UseNumber()
+
+	var xval interface{}
+	err = dec.Decode(&xval)
+	checkErrorNow(t, xjson, err)
+
+	xres, err := json.Marshal(xval)
+	checkErrorNow(t, xjson, err)
+
+	if !bytes.Equal(res, xres) {
+		t.Errorf("%s: expected %s; got %s", cbor, string(xres), string(res))
+	}
+}
+
+func checkErrorNow(t *testing.T, where string, err error) {
+	if err != nil {
+		t.Fatalf("%s: %s", where, err.Error())
+	}
+}
+
+func TestCBORLinks(t *testing.T) {
+	link := "d82a58230012202cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	// {"a": link, "b": [1, link]}
+	data, err := hex.DecodeString("a26161" + link + "61628201" + link)
+	checkErrorNow(t, "links", err)
+
+	links, err := CBORLinks(data)
+	checkErrorNow(t, "links", err)
+
+	if len(links) != 2 {
+		t.Fatalf("links: expected 2 links; got %d", len(links))
+	}
+
+	for _, link := range links {
+		if link.B58String() != "QmRN6wdp1S2A5EtjW9A3M1vKSBuQQGcgvuhoMUoEz4iiT5" {
+			t.Errorf("links: unexpected link %s", link.B58String())
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package mc
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	multihash "github.com/multiformats/go-multihash"
+	"strings"
+)
+
+// Content identifiers.
+// Objects are identified by the multihash of their content; sha2-256 is the
+// default hash function, but the datastore supports any function in
+// HashFunctions.
+// Keys can also be expressed as CIDv1 (version, codec, multihash), which is
+// how current IPFS tooling addresses objects; the canonical string form
+// is multibase base32 ('b' prefix).
+// Legacy keys (b58 multihashes) are CIDv0, which carry no codec.
+
+var (
+	UnsupportedHash = errors.New("Unsupported hash function")
+	BadCID          = errors.New("Malformed CID")
+)
+
+// supported hash functions, by multihash name
+var HashFunctions = map[string]uint64{
+	"sha2-256":    multihash.SHA2_256,
+	"blake2b-256": multihash.BLAKE2B_MIN + 31,
+	"blake2b-512": multihash.BLAKE2B_MAX,
+}
+
+const DefaultHashFunction = "sha2-256"
+
+// IPLD codecs
+const (
+	CodecRaw     = 0x55
+	CodecDagPB   = 0x70
+	CodecDagCBOR = 0x71
+)
+
+// HashWith hashes data with a supported multihash function
+func HashWith(data []byte, code uint64) (multihash.Multihash, error) {
+	if !supportedHash(code) {
+		return nil, UnsupportedHash
+	}
+
+	return multihash.Sum(data, code, -1)
+}
+
+// HashCode returns the hash function code of a multihash
+func HashCode(mh multihash.Multihash) (uint64, error) {
+	dmh, err := multihash.Decode(mh)
+	if err != nil {
+		return 0, err
+	}
+
+	return dmh.Code, nil
+}
+
+// VerifyHash checks that mh is the hash of data
+func VerifyHash(mh multihash.Multihash, data []byte) bool {
+	code, err := HashCode(mh)
+	if err != nil {
+		return false
+	}
+
+	hash, err := HashWith(data, code)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(mh, hash)
+}
+
+func supportedHash(code uint64) bool {
+	for _, xcode := range HashFunctions {
+		if code == xcode {
+			return true
+		}
+	}
+	return false
+}
+
+type CID struct {
+	Version uint64
+	Codec   uint64
+	Hash    multihash.Multihash
+}
+
+func NewCIDv1(codec uint64, mh multihash.Multihash) CID {
+	return CID{1, codec, mh}
+}
+
+// Bytes returns the binary form of the CID; for CIDv0 this is the multihash
+func (cid CID) Bytes() []byte {
+	if cid.Version == 0 {
+		return []byte(cid.Hash)
+	}
+
+	buf := make([]byte, 2*binary.MaxVarintLen64+len(cid.Hash))
+	n := binary.PutUvarint(buf, cid.Version)
+	n += binary.PutUvarint(buf[n:], cid.Codec)
+	n += copy(buf[n:], cid.Hash)
+	return buf[:n]
+}
+
+func (cid CID) String() string {
+	if cid.Version == 0 {
+		return cid.Hash.B58String()
+	}
+
+	enc := base32cid.EncodeToString(cid.Bytes())
+	return "b" + strings.TrimRight(enc, "=")
+}
+
+// DecodeCID parses the binary form of a CID
+func DecodeCID(buf []byte) (empty CID, err error) {
+	if len(buf) > 0 && buf[0] == 1 {
+		version, n := binary.Uvarint(buf)
+		codec, m := binary.Uvarint(buf[n:])
+		if m <= 0 {
+			return empty, BadCID
+		}
+
+		mh, err := multihash.Cast(buf[n+m:])
+		if err != nil {
+			return empty, err
+		}
+
+		return CID{version, codec, mh}, nil
+	}
+
+	mh, err := multihash.Cast(buf)
+	if err != nil {
+		return empty, err
+	}
+
+	return CID{Hash: mh}, nil
+}
+
+// ParseCID parses the string form of a CID; accepts base32 CIDv1 and b58
+// multihashes (CIDv0)
+func ParseCID(str string) (empty CID, err error) {
+	if strings.HasPrefix(str, "b") {
+		cid, err := parseCIDv1(str[1:])
+		if err == nil {
+			return cid, nil
+		}
+	}
+
+	mh, err := multihash.FromB58String(str)
+	if err != nil {
+		return empty, err
+	}
+
+	return CID{Hash: mh}, nil
+}
+
+func parseCIDv1(str string) (empty CID, err error) {
+	if pad := len(str) % 8; pad > 0 {
+		str += strings.Repeat("=", 8-pad)
+	}
+
+	buf, err := base32cid.DecodeString(str)
+	if err != nil {
+		return empty, err
+	}
+
+	cid, err := DecodeCID(buf)
+	if err != nil {
+		return empty, err
+	}
+
+	if cid.Version != 1 {
+		return empty, BadCID
+	}
+
+	return cid, nil
+}
+
+// ParseKey parses an object key, as a b58 multihash or a CID
+func ParseKey(str string) (multihash.Multihash, error) {
+	cid, err := ParseCID(str)
+	if err != nil {
+		return nil, err
+	}
+
+	return cid.Hash, nil
+}
+
+// CanonicalKey normalizes an object key to its b58 multihash form;
+// malformed keys are returned unchanged.
+func CanonicalKey(str string) string {
+	cid, err := ParseCID(str)
+	if err != nil || cid.Version == 0 {
+		return str
+	}
+
+	return cid.Hash.B58String()
+}
+
+var base32cid = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567")
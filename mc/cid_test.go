@@ -0,0 +1,68 @@
+package mc
+
+import (
+	"testing"
+)
+
+func TestCID(t *testing.T) {
+	const key58 = "QmRN6wdp1S2A5EtjW9A3M1vKSBuQQGcgvuhoMUoEz4iiT5"
+	const cid32 = "bafyreibm6jg3ux5qumhcn2b3flc3tyu6dmlb4xa7u5bf44yegnrjhc4yeq"
+
+	mh := Hash([]byte("hello"))
+	if mh.B58String() != key58 {
+		t.Fatalf("hash: expected %s; got %s", key58, mh.B58String())
+	}
+
+	cid := NewCIDv1(CodecDagCBOR, mh)
+	if cid.String() != cid32 {
+		t.Errorf("cid: expected %s; got %s", cid32, cid.String())
+	}
+
+	for _, key := range []string{key58, cid32} {
+		xmh, err := ParseKey(key)
+		checkErrorNow(t, key, err)
+
+		if xmh.B58String() != key58 {
+			t.Errorf("%s: expected %s; got %s", key, key58, xmh.B58String())
+		}
+
+		if CanonicalKey(key) != key58 {
+			t.Errorf("%s: bad canonical key %s", key, CanonicalKey(key))
+		}
+	}
+
+	xcid, err := ParseCID(cid32)
+	checkErrorNow(t, cid32, err)
+
+	if xcid.Version != 1 || xcid.Codec != CodecDagCBOR {
+		t.Errorf("%s: bad version or codec: %d %d", cid32, xcid.Version, xcid.Codec)
+	}
+
+	for _, key := range []string{"", "b", "bafy", "Qm"} {
+		_, err = ParseKey(key)
+		if err == nil {
+			t.Errorf("%s: expected error", key)
+		}
+	}
+}
+
+func TestVerifyHash(t *testing.T) {
+	data := []byte("hello")
+	for name, code := range HashFunctions {
+		mh, err := HashWith(data, code)
+		checkErrorNow(t, name, err)
+
+		if !VerifyHash(mh, data) {
+			t.Errorf("%s: hash verification failed", name)
+		}
+
+		if VerifyHash(mh, []byte("world")) {
+			t.Errorf("%s: hash verification succeeded for bad data", name)
+		}
+	}
+
+	_, err := HashWith(data, 0)
+	if err != UnsupportedHash {
+		t.Errorf("identity: expected UnsupportedHash")
+	}
+}
@@ -1,6 +1,7 @@
 package mc
 
 import (
+	"errors"
 	b58 "github.com/jbenet/go-base58"
 	p2p_crypto "github.com/libp2p/go-libp2p-crypto"
 	p2p_peer "github.com/libp2p/go-libp2p-peer"
@@ -8,12 +9,15 @@ import (
 	"log"
 	"os"
 	"path"
+	"regexp"
+	"strings"
 )
 
 // Node identities: PeerIdentity and PublisherIdentity
 // the structs are different because the semantics of id differ
 // PeerIdentities use the raw public key hash as dictated by libp2p
-//  they use RSA keys for interop with js, which is lagging in libp2p-crypto
+//  they use RSA keys by default for interop with js, which is lagging in
+//  libp2p-crypto; new nodes can use Ed25519 keys instead.
 // PublisherIdentities use the base58 encoded public key as identifier
 //  they use ECC (Ed25519) keys and sign statements with them
 type PeerIdentity struct {
@@ -24,6 +28,7 @@ type PeerIdentity struct {
 type PublisherIdentity struct {
 	ID58    string
 	PrivKey p2p_crypto.PrivKey
+	Signer  Signer
 }
 
 func (id PeerIdentity) Pretty() string {
@@ -34,23 +39,77 @@ func (id PublisherIdentity) Pretty() string {
 	return id.ID58
 }
 
+// Identities loaded from encrypted key files without a passphrase are
+// locked: their id is known, but they have no private key.
+func (id PeerIdentity) Locked() bool {
+	return id.PrivKey == nil
+}
+
+// Publisher identities with an external signer are not locked, as they can
+// sign statements without a private key.
+func (id PublisherIdentity) Locked() bool {
+	return id.PrivKey == nil && id.Signer == nil
+}
+
+// Sign signs data with the external signer of the identity if there is one,
+// or else with its private key.
+func (id PublisherIdentity) Sign(data []byte) ([]byte, error) {
+	switch {
+	case id.Signer != nil:
+		return id.Signer.Sign(data)
+	case id.PrivKey != nil:
+		return id.PrivKey.Sign(data)
+	default:
+		return nil, KeyLocked
+	}
+}
+
 // Peer Identities
-func MakePeerIdentity(home string) (empty PeerIdentity, err error) {
+// The passphrase encrypts newly generated keys and decrypts encrypted
+// keys; it can be nil for plain key files.
+func MakePeerIdentity(home string, pass []byte) (PeerIdentity, error) {
+	return MakePeerIdentityKey(home, DefaultPeerKey, pass)
+}
+
+// Peer key types: the key type only matters when generating a new key;
+// existing keys are loaded regardless of type, and peers with different key
+// types interoperate, as peer ids are hashes of the public key.
+// ECDSA keys are not supported by our version of libp2p-crypto.
+const (
+	RSAPeerKey     = "rsa"
+	Ed25519PeerKey = "ed25519"
+	DefaultPeerKey = RSAPeerKey
+)
+
+// MakePeerIdentityKey is like MakePeerIdentity, but generates a new key
+// of type ktype if there is no key.
+func MakePeerIdentityKey(home string, ktype string, pass []byte) (empty PeerIdentity, err error) {
 	kpath := path.Join(home, "identity.node")
 	_, err = os.Stat(kpath)
 	if os.IsNotExist(err) {
-		return generatePeerIdentity(kpath)
+		return generatePeerIdentity(kpath, ktype, pass)
 	}
 	if err != nil {
 		return
 	}
-	return loadPeerIdentity(kpath)
+	return loadPeerIdentity(kpath, pass)
 }
 
-func generatePeerIdentity(kpath string) (empty PeerIdentity, err error) {
-	log.Printf("Generating new node identity")
-	// RSA keys for interop with js
-	privk, pubk, err := generateRSAKeyPair()
+func generatePeerIdentity(kpath string, ktype string, pass []byte) (empty PeerIdentity, err error) {
+	log.Printf("Generating new node identity (%s)", ktype)
+
+	var privk p2p_crypto.PrivKey
+	var pubk p2p_crypto.PubKey
+	switch ktype {
+	case RSAPeerKey:
+		// RSA keys for interop with js
+		privk, pubk, err = generateRSAKeyPair()
+	case Ed25519PeerKey:
+		privk, pubk, err = generateECCKeyPair()
+	default:
+		err = BadKeyType
+	}
+
 	if err != nil {
 		return
 	}
@@ -61,7 +120,7 @@ func generatePeerIdentity(kpath string) (empty PeerIdentity, err error) {
 	}
 
 	log.Printf("Saving key to %s", kpath)
-	err = saveKey(privk, kpath)
+	err = saveKey(privk, kpath, pass)
 	if err != nil {
 		return
 	}
@@ -70,36 +129,42 @@ func generatePeerIdentity(kpath string) (empty PeerIdentity, err error) {
 	return PeerIdentity{ID: id, PrivKey: privk}, nil
 }
 
-func loadPeerIdentity(kpath string) (empty PeerIdentity, err error) {
+// LoadPeerIdentity loads the node identity in home; unlike MakePeerIdentity,
+// it doesn't generate a new key if there is none.
+func LoadPeerIdentity(home string, pass []byte) (PeerIdentity, error) {
+	return loadPeerIdentity(path.Join(home, "identity.node"), pass)
+}
+
+func loadPeerIdentity(kpath string, pass []byte) (empty PeerIdentity, err error) {
 	log.Printf("Loading node identity from %s", kpath)
-	privk, err := loadKey(kpath)
+	privk, pubk, err := loadKey(kpath, pass)
 	if err != nil {
 		return
 	}
 
-	id, err := p2p_peer.IDFromPrivateKey(privk)
+	id, err := p2p_peer.IDFromPublicKey(pubk)
 	if err != nil {
 		return
 	}
 
-	log.Printf("Peer ID: %s", id.Pretty())
+	log.Printf("Peer ID: %s%s", id.Pretty(), lockedString(privk))
 	return PeerIdentity{id, privk}, nil
 }
 
 // Publisher Identities
-func MakePublisherIdentity(home string) (empty PublisherIdentity, err error) {
+func MakePublisherIdentity(home string, pass []byte) (empty PublisherIdentity, err error) {
 	kpath := path.Join(home, "identity.publisher") // .pub would be unfortunate
 	_, err = os.Stat(kpath)
 	if os.IsNotExist(err) {
-		return generatePublisherIdentity(kpath)
+		return generatePublisherIdentity(kpath, pass)
 	}
 	if err != nil {
 		return
 	}
-	return loadPublisherIdentity(kpath)
+	return loadPublisherIdentity(kpath, pass)
 }
 
-func generatePublisherIdentity(kpath string) (empty PublisherIdentity, err error) {
+func generatePublisherIdentity(kpath string, pass []byte) (empty PublisherIdentity, err error) {
 	log.Printf("Generating new publisher identity")
 
 	privk, pubk, err := generateECCKeyPair()
@@ -113,19 +178,107 @@ func generatePublisherIdentity(kpath string) (empty PublisherIdentity, err error
 	}
 
 	log.Printf("Saving key to %s", kpath)
-	err = saveKey(privk, kpath)
+	err = saveKey(privk, kpath, pass)
 	if err != nil {
 		return
 	}
 
 	log.Printf("Publisher ID: %s", id58)
-	return PublisherIdentity{id58, privk}, nil
+	return PublisherIdentity{ID58: id58, PrivKey: privk}, nil
 
 }
 
-func loadPublisherIdentity(kpath string) (empty PublisherIdentity, err error) {
+// LoadPublisherIdentity loads the publisher identity in home; unlike
+// MakePublisherIdentity, it doesn't generate a new key if there is none.
+func LoadPublisherIdentity(home string, pass []byte) (PublisherIdentity, error) {
+	return loadPublisherIdentity(path.Join(home, "identity.publisher"), pass)
+}
+
+func loadPublisherIdentity(kpath string, pass []byte) (empty PublisherIdentity, err error) {
 	log.Printf("Loading publisher identity from %s", kpath)
-	privk, err := loadKey(kpath)
+	privk, pubk, err := loadKey(kpath, pass)
+	if err != nil {
+		return
+	}
+
+	id58, err := PublisherID58(pubk)
+	if err != nil {
+		return
+	}
+
+	log.Printf("Publisher ID: %s%s", id58, lockedString(privk))
+	return PublisherIdentity{ID58: id58, PrivKey: privk}, nil
+}
+
+// Named publisher identities, for nodes publishing under multiple keys.
+// They are stored in a directory (publishers in the node home), with a
+// <name>.publisher key file for each identity.
+var (
+	BadPublisherName = errors.New("Illegal publisher name")
+	PublisherExists  = errors.New("Publisher identity already exists")
+)
+
+var pubnamerx = regexp.MustCompile("^[a-zA-Z0-9-]+$")
+
+const publisherKeySuffix = ".publisher"
+
+func LoadPublisherIdentities(dir string, pass []byte) (map[string]PublisherIdentity, error) {
+	pubs := make(map[string]PublisherIdentity)
+
+	files, err := ioutil.ReadDir(dir)
+	switch {
+	case os.IsNotExist(err):
+		return pubs, nil
+	case err != nil:
+		return nil, err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if !file.Mode().IsRegular() || !strings.HasSuffix(name, publisherKeySuffix) {
+			continue
+		}
+
+		pubid, err := loadPublisherIdentity(path.Join(dir, name), pass)
+		if err != nil {
+			return nil, err
+		}
+
+		pubs[strings.TrimSuffix(name, publisherKeySuffix)] = pubid
+	}
+
+	return pubs, nil
+}
+
+// MakeNamedPublisherIdentity generates a new named publisher identity
+func MakeNamedPublisherIdentity(dir string, name string, pass []byte) (empty PublisherIdentity, err error) {
+	kpath, err := namedPublisherPath(dir, name)
+	if err != nil {
+		return
+	}
+
+	return generatePublisherIdentity(kpath, pass)
+}
+
+// LoadNamedPublisherIdentity loads the named publisher identity in dir
+func LoadNamedPublisherIdentity(dir string, name string, pass []byte) (empty PublisherIdentity, err error) {
+	kpath, err := NamedPublisherKeyPath(dir, name)
+	if err != nil {
+		return
+	}
+
+	return loadPublisherIdentity(kpath, pass)
+}
+
+// ImportPublisherIdentity imports a private key, in the format of (plain)
+// publisher key files, as a named publisher identity
+func ImportPublisherIdentity(dir string, name string, key []byte, pass []byte) (empty PublisherIdentity, err error) {
+	kpath, err := namedPublisherPath(dir, name)
+	if err != nil {
+		return
+	}
+
+	privk, err := p2p_crypto.UnmarshalPrivateKey(key)
 	if err != nil {
 		return
 	}
@@ -135,8 +288,117 @@ func loadPublisherIdentity(kpath string) (empty PublisherIdentity, err error) {
 		return
 	}
 
+	log.Printf("Saving key to %s", kpath)
+	err = saveKey(privk, kpath, pass)
+	if err != nil {
+		return
+	}
+
 	log.Printf("Publisher ID: %s", id58)
-	return PublisherIdentity{id58, privk}, nil
+	return PublisherIdentity{ID58: id58, PrivKey: privk}, nil
+}
+
+// NamedPublisherKeyPath returns the path of the key file of a named
+// publisher identity in dir.
+func NamedPublisherKeyPath(dir string, name string) (string, error) {
+	if !pubnamerx.MatchString(name) {
+		return "", BadPublisherName
+	}
+
+	return path.Join(dir, name+publisherKeySuffix), nil
+}
+
+func namedPublisherPath(dir string, name string) (string, error) {
+	kpath, err := NamedPublisherKeyPath(dir, name)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = os.Stat(kpath)
+	switch {
+	case err == nil:
+		return "", PublisherExists
+	case !os.IsNotExist(err):
+		return "", err
+	}
+
+	return kpath, nil
+}
+
+// Publisher key rotation: the key of a publisher identity is replaced by
+// a new key, and the old key is retired to a directory (retired in the node
+// home) as <id>.publisher.
+// The succession from the old to the new key is published by the node in a
+// succession statement signed by both keys.
+
+// NewPublisherIdentity generates a new publisher identity, without saving
+// its key; the key is saved when the identity is rotated in.
+func NewPublisherIdentity() (empty PublisherIdentity, err error) {
+	privk, pubk, err := generateECCKeyPair()
+	if err != nil {
+		return
+	}
+
+	id58, err := PublisherID58(pubk)
+	if err != nil {
+		return
+	}
+
+	return PublisherIdentity{ID58: id58, PrivKey: privk}, nil
+}
+
+// Publisher key rotation is staged: the new key is saved aside until the
+// succession is recorded, and then replaces the key in kpath; a rotation
+// that fails after the succession is recorded can be completed from the
+// staged key.
+const rotationSuffix = ".next"
+
+// StagePublisherRotation saves the key of next aside, for rotating the
+// publisher key in kpath.
+func StagePublisherRotation(kpath string, next PublisherIdentity, pass []byte) error {
+	log.Printf("Staging publisher key rotation to %s", next.ID58)
+	return saveKey(next.PrivKey, kpath+rotationSuffix, pass)
+}
+
+// PendingPublisherRotation loads the staged key of a rotation of the
+// publisher key in kpath; the error satisfies os.IsNotExist if there is no
+// pending rotation.
+func PendingPublisherRotation(kpath string, pass []byte) (empty PublisherIdentity, err error) {
+	_, err = os.Stat(kpath + rotationSuffix)
+	if err != nil {
+		return
+	}
+
+	return loadPublisherIdentity(kpath+rotationSuffix, pass)
+}
+
+// AbortPublisherRotation discards the staged key of a rotation
+func AbortPublisherRotation(kpath string) error {
+	return os.Remove(kpath + rotationSuffix)
+}
+
+// CommitPublisherRotation replaces the publisher key in kpath, which must be
+// the key of prev, with the staged key; the key of prev is retired in dir.
+func CommitPublisherRotation(kpath string, dir string, prev PublisherIdentity, pass []byte) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	rpath := path.Join(dir, prev.ID58+publisherKeySuffix)
+	log.Printf("Retiring publisher key to %s", rpath)
+	err = saveKey(prev.PrivKey, rpath, pass)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Saving key to %s", kpath)
+	return os.Rename(kpath+rotationSuffix, kpath)
 }
 
 func PublisherID58(pubk p2p_crypto.PubKey) (string, error) {
@@ -155,17 +417,61 @@ func PublisherKey(id58 string) (p2p_crypto.PubKey, error) {
 }
 
 // Key management
-func loadKey(kpath string) (p2p_crypto.PrivKey, error) {
+// loadKey returns a nil private key for encrypted keys without a passphrase
+func loadKey(kpath string, pass []byte) (p2p_crypto.PrivKey, p2p_crypto.PubKey, error) {
 	bytes, err := ioutil.ReadFile(kpath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return p2p_crypto.UnmarshalPrivateKey(bytes)
+	return unmarshalKey(bytes, pass)
 }
 
-func saveKey(privk p2p_crypto.PrivKey, kpath string) error {
-	bytes, err := privk.Bytes()
+func unmarshalKey(bytes []byte, pass []byte) (p2p_crypto.PrivKey, p2p_crypto.PubKey, error) {
+	if !isEncryptedKey(bytes) {
+		privk, err := p2p_crypto.UnmarshalPrivateKey(bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return privk, privk.GetPublic(), nil
+	}
+
+	kf, err := parseKeyFile(bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pubk, err := p2p_crypto.UnmarshalPublicKey(kf.pubk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pass == nil {
+		return nil, pubk, nil
+	}
+
+	privk, err := decryptKey(bytes, pass)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !privk.GetPublic().Equals(pubk) {
+		return nil, nil, BadKeyFile
+	}
+
+	return privk, pubk, nil
+}
+
+// saveKey encrypts the key if there is a passphrase
+func saveKey(privk p2p_crypto.PrivKey, kpath string, pass []byte) error {
+	var bytes []byte
+	var err error
+	if pass != nil {
+		bytes, err = encryptKey(privk, pass)
+	} else {
+		bytes, err = privk.Bytes()
+	}
+
 	if err != nil {
 		return err
 	}
@@ -173,6 +479,13 @@ func saveKey(privk p2p_crypto.PrivKey, kpath string) error {
 	return ioutil.WriteFile(kpath, bytes, 0600)
 }
 
+func lockedString(privk p2p_crypto.PrivKey) string {
+	if privk == nil {
+		return " (locked)"
+	}
+	return ""
+}
+
 func generateRSAKeyPair() (p2p_crypto.PrivKey, p2p_crypto.PubKey, error) {
 	return p2p_crypto.GenerateKeyPair(p2p_crypto.RSA, 2048)
 }
@@ -0,0 +1,137 @@
+package mc
+
+import (
+	"context"
+	"fmt"
+	p2p_host "github.com/libp2p/go-libp2p-host"
+	p2p_net "github.com/libp2p/go-libp2p-net"
+	p2p_pstore "github.com/libp2p/go-libp2p-peerstore"
+	pb "github.com/mediachain/concat/proto"
+	multiaddr "github.com/multiformats/go-multiaddr"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestPeerKeyTypes(t *testing.T) {
+	home, err := ioutil.TempDir("", "identity")
+	checkErrorNow(t, "tempdir", err)
+	defer os.RemoveAll(home)
+
+	for _, ktype := range []string{RSAPeerKey, Ed25519PeerKey} {
+		dir := path.Join(home, ktype)
+		err = os.MkdirAll(dir, 0755)
+		checkErrorNow(t, "mkdir", err)
+
+		id, err := MakePeerIdentityKey(dir, ktype, nil)
+		checkErrorNow(t, ktype, err)
+		if !id.ID.MatchesPrivateKey(id.PrivKey) {
+			t.Fatalf("%s: peer id doesn't match the key", ktype)
+		}
+
+		// existing keys are loaded regardless of the requested key type
+		xid, err := MakePeerIdentityKey(dir, RSAPeerKey, nil)
+		checkErrorNow(t, ktype, err)
+		if xid.ID != id.ID {
+			t.Fatalf("%s: expected %s; got %s", ktype, id.Pretty(), xid.Pretty())
+		}
+
+		// peer info in directory messages and handles
+		addr, err := ParseAddress("/ip4/127.0.0.1/tcp/9001")
+		checkErrorNow(t, ktype, err)
+		pinfo := p2p_pstore.PeerInfo{ID: id.ID, Addrs: []multiaddr.Multiaddr{addr}}
+
+		var pbpi pb.PeerInfo
+		PBFromPeerInfo(&pbpi, pinfo)
+		xpinfo, err := PBToPeerInfo(&pbpi)
+		checkErrorNow(t, ktype, err)
+		if xpinfo.ID != id.ID || len(xpinfo.Addrs) != 1 || !xpinfo.Addrs[0].Equal(addr) {
+			t.Fatalf("%s: peer info round trip failed", ktype)
+		}
+
+		xpinfo, err = ParseHandle(FormatHandle(pinfo))
+		checkErrorNow(t, ktype, err)
+		if xpinfo.ID != id.ID {
+			t.Fatalf("%s: handle round trip failed", ktype)
+		}
+	}
+
+	_, err = MakePeerIdentityKey(path.Join(home, "bogus"), "bogus", nil)
+	if err != BadKeyType {
+		t.Fatalf("expected BadKeyType; got %v", err)
+	}
+}
+
+// TestMixedKeyNetwork connects RSA and Ed25519 nodes and exchanges messages
+// between them in both directions.
+func TestMixedKeyNetwork(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping network test in short mode")
+	}
+
+	home, err := ioutil.TempDir("", "identity")
+	checkErrorNow(t, "tempdir", err)
+	defer os.RemoveAll(home)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	laddr, err := ParseAddress("/ip4/127.0.0.1/tcp/0")
+	checkErrorNow(t, "address", err)
+
+	ktypes := []string{RSAPeerKey, Ed25519PeerKey, RSAPeerKey, Ed25519PeerKey}
+	hosts := make([]p2p_host.Host, len(ktypes))
+	for x, ktype := range ktypes {
+		dir := path.Join(home, fmt.Sprintf("node%d", x))
+		err = os.MkdirAll(dir, 0755)
+		checkErrorNow(t, "mkdir", err)
+
+		id, err := MakePeerIdentityKey(dir, ktype, nil)
+		checkErrorNow(t, ktype, err)
+
+		host, err := NewHost(ctx, id, laddr)
+		checkErrorNow(t, ktype, err)
+		defer host.Close()
+
+		host.SetStreamHandler("/mediachain/test/echo", func(s p2p_net.Stream) {
+			defer s.Close()
+			io.Copy(s, s)
+		})
+
+		hosts[x] = host
+	}
+
+	for x, src := range hosts {
+		for y, dest := range hosts {
+			if x == y {
+				continue
+			}
+
+			err = src.Connect(ctx, p2p_pstore.PeerInfo{ID: dest.ID(), Addrs: dest.Addrs()})
+			checkErrorNow(t, "connect", err)
+
+			s, err := src.NewStream(ctx, dest.ID(), "/mediachain/test/echo")
+			checkErrorNow(t, "stream", err)
+
+			if s.Conn().RemotePeer() != dest.ID() || !dest.ID().MatchesPublicKey(s.Conn().RemotePublicKey()) {
+				t.Fatalf("%s -> %s: remote peer doesn't match its key", ktypes[x], ktypes[y])
+			}
+
+			msg := []byte(ktypes[x] + " -> " + ktypes[y])
+			_, err = s.Write(msg)
+			checkErrorNow(t, "write", err)
+
+			buf := make([]byte, len(msg))
+			_, err = io.ReadFull(s, buf)
+			checkErrorNow(t, "read", err)
+			s.Close()
+
+			if string(buf) != string(msg) {
+				t.Fatalf("%s: expected echo; got %s", msg, buf)
+			}
+		}
+	}
+}
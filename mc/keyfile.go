@@ -0,0 +1,201 @@
+package mc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	p2p_crypto "github.com/libp2p/go-libp2p-crypto"
+	scrypt "golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	KeyLocked     = errors.New("Key is encrypted; passphrase required")
+	BadPassphrase = errors.New("Bad passphrase")
+	BadKeyFile    = errors.New("Bad key file")
+)
+
+// Encrypted key files: the private key is encrypted with AES-256-GCM, using
+// a key derived from the passphrase with scrypt.
+// The public key is kept in the clear, so that the identity of a locked node
+// is known before it is unlocked.
+// The layout is: magic | scrypt logN, r, p (1 byte each) | salt (16 bytes) |
+// nonce (12 bytes) | public key length (2 bytes, big endian) | public key |
+// ciphertext; everything before the ciphertext is authenticated as
+// additional data.
+// Key files without the magic are plain marshalled private keys.
+var keyFileMagic = []byte("mckey\x01")
+
+const (
+	keyFileLogN    = 15
+	keyFileR       = 8
+	keyFileP       = 1
+	keyFileSaltLen = 16
+	keyFileNonce   = 12
+)
+
+type keyFile struct {
+	logN, r, p byte
+	salt       []byte
+	nonce      []byte
+	pubk       []byte
+	header     []byte
+	ciphertext []byte
+}
+
+func isEncryptedKey(data []byte) bool {
+	return bytes.HasPrefix(data, keyFileMagic)
+}
+
+func encryptKey(privk p2p_crypto.PrivKey, pass []byte) ([]byte, error) {
+	kbytes, err := privk.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	pbytes, err := privk.GetPublic().Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pbytes) > 0xffff {
+		return nil, BadKeyFile
+	}
+
+	salt := make([]byte, keyFileSaltLen)
+	nonce := make([]byte, keyFileNonce)
+	for _, buf := range [][]byte{salt, nonce} {
+		_, err = io.ReadFull(rand.Reader, buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	aead, err := keyFileCipher(pass, salt, keyFileLogN, keyFileR, keyFileP)
+	if err != nil {
+		return nil, err
+	}
+
+	var hdr bytes.Buffer
+	hdr.Write(keyFileMagic)
+	hdr.Write([]byte{keyFileLogN, keyFileR, keyFileP})
+	hdr.Write(salt)
+	hdr.Write(nonce)
+	binary.Write(&hdr, binary.BigEndian, uint16(len(pbytes)))
+	hdr.Write(pbytes)
+
+	header := hdr.Bytes()
+	out := make([]byte, len(header), len(header)+len(kbytes)+aead.Overhead())
+	copy(out, header)
+	return aead.Seal(out, nonce, kbytes, header), nil
+}
+
+func decryptKey(data []byte, pass []byte) (p2p_crypto.PrivKey, error) {
+	kf, err := parseKeyFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := keyFileCipher(pass, kf.salt, kf.logN, kf.r, kf.p)
+	if err != nil {
+		return nil, err
+	}
+
+	kbytes, err := aead.Open(nil, kf.nonce, kf.ciphertext, kf.header)
+	if err != nil {
+		return nil, BadPassphrase
+	}
+
+	return p2p_crypto.UnmarshalPrivateKey(kbytes)
+}
+
+func parseKeyFile(data []byte) (*keyFile, error) {
+	if !isEncryptedKey(data) {
+		return nil, BadKeyFile
+	}
+
+	rest := data[len(keyFileMagic):]
+	if len(rest) < 3+keyFileSaltLen+keyFileNonce+2 {
+		return nil, BadKeyFile
+	}
+
+	kf := new(keyFile)
+	kf.logN, kf.r, kf.p = rest[0], rest[1], rest[2]
+	rest = rest[3:]
+	kf.salt, rest = rest[:keyFileSaltLen], rest[keyFileSaltLen:]
+	kf.nonce, rest = rest[:keyFileNonce], rest[keyFileNonce:]
+
+	plen := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < plen {
+		return nil, BadKeyFile
+	}
+
+	kf.pubk, kf.ciphertext = rest[:plen], rest[plen:]
+	kf.header = data[:len(data)-len(kf.ciphertext)]
+	return kf, nil
+}
+
+func keyFileCipher(pass []byte, salt []byte, logN, r, p byte) (cipher.AEAD, error) {
+	if logN == 0 || logN > 30 {
+		return nil, BadKeyFile
+	}
+
+	key, err := scrypt.Key(pass, salt, 1<<logN, int(r), int(p), 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// IsEncryptedKeyFile checks whether the key file at kpath is encrypted
+func IsEncryptedKeyFile(kpath string) (bool, error) {
+	data, err := ioutil.ReadFile(kpath)
+	if err != nil {
+		return false, err
+	}
+
+	return isEncryptedKey(data), nil
+}
+
+// EncryptKeyFile encrypts a plain key file in place with a passphrase;
+// returns false if the key file is already encrypted.
+func EncryptKeyFile(kpath string, pass []byte) (bool, error) {
+	data, err := ioutil.ReadFile(kpath)
+	if err != nil {
+		return false, err
+	}
+
+	if isEncryptedKey(data) {
+		return false, nil
+	}
+
+	privk, err := p2p_crypto.UnmarshalPrivateKey(data)
+	if err != nil {
+		return false, err
+	}
+
+	tmp := kpath + ".new"
+	err = saveKey(privk, tmp, pass)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.Rename(tmp, kpath)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,79 @@
+package mc
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestKeyFileEncryption(t *testing.T) {
+	home, err := ioutil.TempDir("", "keyfile")
+	checkErrorNow(t, "tempdir", err)
+	defer os.RemoveAll(home)
+
+	pass := []byte("correct horse battery staple")
+	pub, err := MakePublisherIdentity(home, pass)
+	checkErrorNow(t, "generate", err)
+
+	kpath := path.Join(home, "identity.publisher")
+	data, err := ioutil.ReadFile(kpath)
+	checkErrorNow(t, "read", err)
+	if !isEncryptedKey(data) {
+		t.Fatal("key file is not encrypted")
+	}
+
+	// without a passphrase the identity is locked, but its id is known
+	locked, err := MakePublisherIdentity(home, nil)
+	checkErrorNow(t, "load locked", err)
+	if !locked.Locked() || locked.ID58 != pub.ID58 {
+		t.Fatalf("expected locked identity %s; got %s", pub.ID58, locked.ID58)
+	}
+
+	_, err = MakePublisherIdentity(home, []byte("wrong"))
+	if err != BadPassphrase {
+		t.Fatalf("expected BadPassphrase; got %v", err)
+	}
+
+	unlocked, err := MakePublisherIdentity(home, pass)
+	checkErrorNow(t, "unlock", err)
+	if unlocked.Locked() || !unlocked.PrivKey.Equals(pub.PrivKey) {
+		t.Fatal("unlocked key mismatch")
+	}
+
+	// tampering with the clear header is detected
+	data[len(keyFileMagic)+3] ^= 1
+	_, err = decryptKey(data, pass)
+	if err != BadPassphrase {
+		t.Fatalf("expected BadPassphrase for tampered key; got %v", err)
+	}
+}
+
+func TestEncryptKeyFile(t *testing.T) {
+	home, err := ioutil.TempDir("", "keyfile")
+	checkErrorNow(t, "tempdir", err)
+	defer os.RemoveAll(home)
+
+	pub, err := MakePublisherIdentity(home, nil)
+	checkErrorNow(t, "generate", err)
+
+	kpath := path.Join(home, "identity.publisher")
+	pass := []byte("passphrase")
+	ok, err := EncryptKeyFile(kpath, pass)
+	checkErrorNow(t, "encrypt", err)
+	if !ok {
+		t.Fatal("plain key file was not encrypted")
+	}
+
+	ok, err = EncryptKeyFile(kpath, pass)
+	checkErrorNow(t, "encrypt", err)
+	if ok {
+		t.Fatal("encrypted key file was encrypted again")
+	}
+
+	unlocked, err := MakePublisherIdentity(home, pass)
+	checkErrorNow(t, "unlock", err)
+	if unlocked.ID58 != pub.ID58 || !unlocked.PrivKey.Equals(pub.PrivKey) {
+		t.Fatal("unlocked key mismatch")
+	}
+}
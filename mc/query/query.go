@@ -115,3 +115,53 @@ type QueryOrderSpec struct {
 	sel string
 	dir string
 }
+
+// WithPublisherChain rewrites the publisher criteria of a query to match
+// all the publishers in the succession chain of the criteria publisher, as
+// returned by chain; publisher = A becomes publisher = A OR publisher = B ...
+// and publisher != A becomes publisher != A AND publisher != B ...
+func (q *Query) WithPublisherChain(chain func(string) []string) *Query {
+	if q.criteria == nil {
+		return q
+	}
+
+	crit := rewritePublisherCriteria(q.criteria, chain)
+	return &Query{q.Op, q.namespace, q.selector, crit, q.order, q.limit}
+}
+
+func rewritePublisherCriteria(c QueryCriteria, chain func(string) []string) QueryCriteria {
+	switch c := c.(type) {
+	case *ValueCriteria:
+		if c.sel != "publisher" {
+			return c
+		}
+
+		pubs := chain(c.val)
+		if len(pubs) < 2 {
+			return c
+		}
+
+		op := "OR"
+		if c.op == "!=" {
+			op = "AND"
+		}
+
+		var crit QueryCriteria = &ValueCriteria{op: c.op, sel: c.sel, val: pubs[0]}
+		for _, pub := range pubs[1:] {
+			right := &ValueCriteria{op: c.op, sel: c.sel, val: pub}
+			crit = &CompoundCriteria{op: op, left: crit, right: right}
+		}
+		return crit
+
+	case *CompoundCriteria:
+		left := rewritePublisherCriteria(c.left, chain)
+		right := rewritePublisherCriteria(c.right, chain)
+		return &CompoundCriteria{op: c.op, left: left, right: right}
+
+	case *NegatedCriteria:
+		return &NegatedCriteria{rewritePublisherCriteria(c.e, chain)}
+
+	default:
+		return c
+	}
+}
@@ -598,6 +598,84 @@ func parseEval(qs string, stmts []*pb.Statement) ([]interface{}, error) {
 	return res, err
 }
 
+func TestQueryPublisherChain(t *testing.T) {
+	a := &pb.Statement{
+		Id:        "a",
+		Publisher: "A",
+		Namespace: "foo.a",
+		Body:      &pb.StatementBody{&pb.StatementBody_Simple{&pb.SimpleStatement{Object: "QmAAA"}}},
+		Timestamp: 100}
+
+	b := &pb.Statement{
+		Id:        "b",
+		Publisher: "B",
+		Namespace: "foo.a",
+		Body:      &pb.StatementBody{&pb.StatementBody_Simple{&pb.SimpleStatement{Object: "QmBBB"}}},
+		Timestamp: 200}
+
+	c := &pb.Statement{
+		Id:        "c",
+		Publisher: "C",
+		Namespace: "foo.a",
+		Body:      &pb.StatementBody{&pb.StatementBody_Simple{&pb.SimpleStatement{Object: "QmCCC"}}},
+		Timestamp: 300}
+
+	stmts := []*pb.Statement{a, b, c}
+
+	// A was succeeded by B
+	chain := func(pub string) []string {
+		switch pub {
+		case "A", "B":
+			return []string{"A", "B"}
+		default:
+			return []string{pub}
+		}
+	}
+
+	qs := "SELECT * FROM foo.a WHERE publisher = B"
+	q, err := ParseQuery(qs)
+	checkErrorNow(t, qs, err)
+
+	res, err := EvalQuery(q.WithPublisherChain(chain), stmts)
+	checkErrorNow(t, qs, err)
+
+	if checkResultLen(t, qs, res, 2) {
+		checkContains(t, qs, res, a)
+		checkContains(t, qs, res, b)
+	}
+
+	qs = "SELECT * FROM foo.a WHERE publisher != A"
+	q, err = ParseQuery(qs)
+	checkErrorNow(t, qs, err)
+
+	res, err = EvalQuery(q.WithPublisherChain(chain), stmts)
+	checkErrorNow(t, qs, err)
+
+	if checkResultLen(t, qs, res, 1) {
+		checkContains(t, qs, res, c)
+	}
+
+	qs = "SELECT * FROM foo.a WHERE NOT (publisher = A OR publisher = C)"
+	q, err = ParseQuery(qs)
+	checkErrorNow(t, qs, err)
+
+	res, err = EvalQuery(q.WithPublisherChain(chain), stmts)
+	checkErrorNow(t, qs, err)
+
+	checkResultLen(t, qs, res, 0)
+
+	qs = "SELECT * FROM foo.a WHERE publisher = C AND timestamp > 100"
+	q, err = ParseQuery(qs)
+	checkErrorNow(t, qs, err)
+
+	res, err = EvalQuery(q.WithPublisherChain(chain), stmts)
+	checkErrorNow(t, qs, err)
+
+	if checkResultLen(t, qs, res, 1) {
+		checkContains(t, qs, res, c)
+	}
+}
+
 func TestQueryCompile(t *testing.T) {
 	for _, qs := range simpleq {
 		q, err := ParseQuery(qs)
@@ -0,0 +1,551 @@
+package mc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schemas for validating data objects.
+// The schema language is a subset of JSON Schema, applied to the JSON
+// representation of CBOR objects (see CBORToJSON). Supported keywords are
+// type, enum, const, properties, required, additionalProperties, items,
+// minItems, maxItems, minLength, maxLength, pattern, minimum and maximum.
+// Annotation keywords (eg title, description, $schema) are ignored; schemas
+// with any other keyword are rejected, as they would not be enforced.
+// In addition to the JSON types, the type keyword accepts "link" for
+// merkle links ({"/": "Qm..."}).
+
+var (
+	BadSchema = errors.New("Malformed schema")
+)
+
+type Schema struct {
+	types      []string
+	enum       []interface{}
+	properties map[string]*Schema
+	required   []string
+	additional *Schema // nil: any additional property is allowed
+	noadd      bool    // additionalProperties: false
+	items      *Schema
+	minItems   int
+	maxItems   int
+	minLength  int
+	maxLength  int
+	pattern    *regexp.Regexp
+	minimum    *float64
+	maximum    *float64
+}
+
+// SchemaError is a validation failure, at the JSON pointer Path of the value
+type SchemaError struct {
+	Path   string
+	Reason string
+}
+
+func (e SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", pathOrRoot(e.Path), e.Reason)
+}
+
+var schemaTypes = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"object":  true,
+	"array":   true,
+	"number":  true,
+	"integer": true,
+	"string":  true,
+	"link":    true,
+}
+
+// annotation keywords, which don't affect validation
+var schemaAnnotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"id":          true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+	"readOnly":    true,
+	"writeOnly":   true,
+	"deprecated":  true,
+}
+
+const schemaMaxDepth = 64
+
+// ParseSchema parses a JSON encoded schema
+func ParseSchema(data []byte) (*Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var val interface{}
+	err := dec.Decode(&val)
+	if err != nil {
+		return nil, err
+	}
+
+	if dec.More() {
+		return nil, BadSchema
+	}
+
+	return compileSchema(val, "", 0)
+}
+
+func compileSchema(val interface{}, path string, depth int) (*Schema, error) {
+	if depth > schemaMaxDepth {
+		return nil, schemaCompileError(path, "schema is too deep")
+	}
+
+	def, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, schemaCompileError(path, "schema must be an object")
+	}
+
+	s := &Schema{maxItems: -1, maxLength: -1}
+	var err error
+
+	for kw, arg := range def {
+		xpath := path + "/" + kw
+
+		switch kw {
+		case "type":
+			s.types, err = compileSchemaTypes(arg, xpath)
+
+		case "enum":
+			vals, ok := arg.([]interface{})
+			if !ok {
+				return nil, schemaCompileError(xpath, "expected an array")
+			}
+			s.enum = vals
+
+		case "const":
+			s.enum = []interface{}{arg}
+
+		case "properties":
+			props, ok := arg.(map[string]interface{})
+			if !ok {
+				return nil, schemaCompileError(xpath, "expected an object")
+			}
+			s.properties = make(map[string]*Schema)
+			for prop, pdef := range props {
+				s.properties[prop], err = compileSchema(pdef, xpath+"/"+escapePointer(prop), depth+1)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+		case "required":
+			lst, ok := arg.([]interface{})
+			if !ok {
+				return nil, schemaCompileError(xpath, "expected an array of strings")
+			}
+			for _, prop := range lst {
+				str, ok := prop.(string)
+				if !ok {
+					return nil, schemaCompileError(xpath, "expected an array of strings")
+				}
+				s.required = append(s.required, str)
+			}
+
+		case "additionalProperties":
+			switch arg := arg.(type) {
+			case bool:
+				s.noadd = !arg
+			default:
+				s.additional, err = compileSchema(arg, xpath, depth+1)
+			}
+
+		case "items":
+			s.items, err = compileSchema(arg, xpath, depth+1)
+
+		case "minItems":
+			s.minItems, err = compileSchemaCount(arg, xpath)
+
+		case "maxItems":
+			s.maxItems, err = compileSchemaCount(arg, xpath)
+
+		case "minLength":
+			s.minLength, err = compileSchemaCount(arg, xpath)
+
+		case "maxLength":
+			s.maxLength, err = compileSchemaCount(arg, xpath)
+
+		case "pattern":
+			str, ok := arg.(string)
+			if !ok {
+				return nil, schemaCompileError(xpath, "expected a string")
+			}
+			s.pattern, err = regexp.Compile(str)
+			if err != nil {
+				return nil, schemaCompileError(xpath, err.Error())
+			}
+
+		case "minimum":
+			s.minimum, err = compileSchemaNumber(arg, xpath)
+
+		case "maximum":
+			s.maximum, err = compileSchemaNumber(arg, xpath)
+
+		default:
+			if !schemaAnnotations[kw] {
+				return nil, schemaCompileError(xpath, fmt.Sprintf("unsupported keyword %s", kw))
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func compileSchemaTypes(arg interface{}, path string) ([]string, error) {
+	var types []string
+	switch arg := arg.(type) {
+	case string:
+		types = []string{arg}
+	case []interface{}:
+		for _, t := range arg {
+			str, ok := t.(string)
+			if !ok {
+				return nil, schemaCompileError(path, "expected a type name")
+			}
+			types = append(types, str)
+		}
+	default:
+		return nil, schemaCompileError(path, "expected a type name or an array of type names")
+	}
+
+	for _, t := range types {
+		if !schemaTypes[t] {
+			return nil, schemaCompileError(path, fmt.Sprintf("unknown type %s", t))
+		}
+	}
+
+	return types, nil
+}
+
+func compileSchemaCount(arg interface{}, path string) (int, error) {
+	num, ok := arg.(json.Number)
+	if !ok {
+		return 0, schemaCompileError(path, "expected a non-negative integer")
+	}
+
+	n, err := strconv.Atoi(string(num))
+	if err != nil || n < 0 {
+		return 0, schemaCompileError(path, "expected a non-negative integer")
+	}
+
+	return n, nil
+}
+
+func compileSchemaNumber(arg interface{}, path string) (*float64, error) {
+	num, ok := arg.(json.Number)
+	if !ok {
+		return nil, schemaCompileError(path, "expected a number")
+	}
+
+	f, err := num.Float64()
+	if err != nil {
+		return nil, schemaCompileError(path, "expected a number")
+	}
+
+	return &f, nil
+}
+
+func schemaCompileError(path string, reason string) error {
+	return fmt.Errorf("%s: %s: %s", BadSchema.Error(), pathOrRoot(path), reason)
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// Validate checks a JSON value against the schema; the error is a
+// SchemaError for the first violation found.
+func (s *Schema) Validate(val interface{}) error {
+	return s.validate(val, "")
+}
+
+func (s *Schema) validate(val interface{}, path string) error {
+	vtype := schemaTypeOf(val)
+	if vtype == "" {
+		return SchemaError{path, "unexpected value"}
+	}
+
+	if len(s.types) > 0 && !s.matchType(val, vtype) {
+		return SchemaError{path, fmt.Sprintf("expected %s; got %s", strings.Join(s.types, " or "), vtype)}
+	}
+
+	if s.enum != nil && !s.matchEnum(val) {
+		return SchemaError{path, "value not in enumeration"}
+	}
+
+	switch vtype {
+	case "object":
+		return s.validateObject(val.(map[string]interface{}), path)
+
+	case "array":
+		return s.validateArray(val.([]interface{}), path)
+
+	case "string":
+		return s.validateString(val.(string), path)
+
+	case "number":
+		return s.validateNumber(val, path)
+
+	default:
+		return nil
+	}
+}
+
+func (s *Schema) matchType(val interface{}, vtype string) bool {
+	for _, t := range s.types {
+		switch {
+		case t == vtype:
+			return true
+		case t == "integer" && vtype == "number" && isIntegral(val):
+			return true
+		case t == "link" && vtype == "object" && isLink(val):
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) matchEnum(val interface{}) bool {
+	for _, xval := range s.enum {
+		if jsonEqual(val, xval) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) validateObject(obj map[string]interface{}, path string) error {
+	for _, prop := range s.required {
+		_, ok := obj[prop]
+		if !ok {
+			return SchemaError{path, fmt.Sprintf("missing required property %s", prop)}
+		}
+	}
+
+	// validate in key order, so that the reported violation is deterministic
+	props := make([]string, 0, len(obj))
+	for prop := range obj {
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+
+	for _, prop := range props {
+		xpath := path + "/" + escapePointer(prop)
+		ps, ok := s.properties[prop]
+		switch {
+		case ok:
+			err := ps.validate(obj[prop], xpath)
+			if err != nil {
+				return err
+			}
+
+		case s.noadd:
+			return SchemaError{path, fmt.Sprintf("unexpected property %s", prop)}
+
+		case s.additional != nil:
+			err := s.additional.validate(obj[prop], xpath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateArray(lst []interface{}, path string) error {
+	if len(lst) < s.minItems {
+		return SchemaError{path, fmt.Sprintf("expected at least %d items", s.minItems)}
+	}
+
+	if s.maxItems >= 0 && len(lst) > s.maxItems {
+		return SchemaError{path, fmt.Sprintf("expected at most %d items", s.maxItems)}
+	}
+
+	if s.items != nil {
+		for x, item := range lst {
+			err := s.items.validate(item, fmt.Sprintf("%s/%d", path, x))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateString(str string, path string) error {
+	n := utf8.RuneCountInString(str)
+	if n < s.minLength {
+		return SchemaError{path, fmt.Sprintf("expected at least %d characters", s.minLength)}
+	}
+
+	if s.maxLength >= 0 && n > s.maxLength {
+		return SchemaError{path, fmt.Sprintf("expected at most %d characters", s.maxLength)}
+	}
+
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		return SchemaError{path, fmt.Sprintf("does not match pattern %s", s.pattern.String())}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateNumber(val interface{}, path string) error {
+	if s.minimum == nil && s.maximum == nil {
+		return nil
+	}
+
+	f, ok := toFloat(val)
+	if !ok {
+		return SchemaError{path, "unexpected number"}
+	}
+
+	if s.minimum != nil && f < *s.minimum {
+		return SchemaError{path, fmt.Sprintf("expected a number >= %v", *s.minimum)}
+	}
+
+	if s.maximum != nil && f > *s.maximum {
+		return SchemaError{path, fmt.Sprintf("expected a number <= %v", *s.maximum)}
+	}
+
+	return nil
+}
+
+func schemaTypeOf(val interface{}) string {
+	switch val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case int, int64, uint64, float64, *big.Int, json.Number:
+		return "number"
+	default:
+		return ""
+	}
+}
+
+func isIntegral(val interface{}) bool {
+	switch val := val.(type) {
+	case int, int64, uint64, *big.Int:
+		return true
+	case float64:
+		return val == math.Trunc(val) && !math.IsInf(val, 0)
+	case json.Number:
+		_, err := val.Int64()
+		if err == nil {
+			return true
+		}
+		f, err := val.Float64()
+		return err == nil && f == math.Trunc(f)
+	default:
+		return false
+	}
+}
+
+func isLink(val interface{}) bool {
+	obj, ok := val.(map[string]interface{})
+	if !ok || len(obj) != 1 {
+		return false
+	}
+
+	str, ok := obj["/"].(string)
+	if !ok {
+		return false
+	}
+
+	_, err := ParseCID(str)
+	return err == nil
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	switch val := val.(type) {
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case float64:
+		return val, true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(val).Float64()
+		return f, true
+	case json.Number:
+		f, err := val.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// jsonEqual compares JSON values; numbers are compared by value
+func jsonEqual(a, b interface{}) bool {
+	if schemaTypeOf(a) == "number" && schemaTypeOf(b) == "number" {
+		fa, oka := toFloat(a)
+		fb, okb := toFloat(b)
+		return oka && okb && fa == fb
+	}
+
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, va := range a {
+			vb, ok := b[k]
+			if !ok || !jsonEqual(va, vb) {
+				return false
+			}
+		}
+		return true
+
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for x := range a {
+			if !jsonEqual(a[x], b[x]) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		return schemaTypeOf(a) == schemaTypeOf(b) && a == b
+	}
+}
+
+func escapePointer(prop string) string {
+	return strings.Replace(strings.Replace(prop, "~", "~0", -1), "/", "~1", -1)
+}
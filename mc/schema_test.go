@@ -0,0 +1,102 @@
+package mc
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+var testSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "test",
+  "type": "object",
+  "required": ["name", "tags"],
+  "additionalProperties": false,
+  "properties": {
+    "name": {"type": "string", "minLength": 1, "maxLength": 8, "description": "the name"},
+    "id": {"type": "string", "pattern": "^[a-z]+-[0-9]+$"},
+    "year": {"type": "integer", "minimum": 1900, "maximum": 2100},
+    "score": {"type": ["number", "null"]},
+    "kind": {"enum": ["image", "video"]},
+    "tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+    "source": {"type": "link"}
+  }
+}`
+
+// objects and the expected validation result
+var schemaObjects = []struct {
+	json string
+	err  string
+}{
+	{`{"name": "foo", "tags": []}`, ""},
+	{`{"name": "foo", "tags": ["a"], "year": 2000, "score": null, "kind": "image"}`, ""},
+	{`{"name": "foo", "tags": [], "score": 1.5}`, ""},
+	{`{"name": "foo"}`, "/: missing required property tags"},
+	{`{"name": "", "tags": []}`, "/name: expected at least 1 characters"},
+	{`{"name": "foo", "tags": [1]}`, "/tags/0: expected string; got number"},
+	{`{"name": "foo", "tags": ["a", "b", "c"]}`, "/tags: expected at most 2 items"},
+	{`{"name": "foo", "tags": [], "year": 1.5}`, "/year: expected integer; got number"},
+	{`{"name": "foo", "tags": [], "year": 1800}`, "/year: expected a number >= 1900"},
+	{`{"name": "foo", "tags": [], "id": "foo"}`, "/id: does not match pattern ^[a-z]+-[0-9]+$"},
+	{`{"name": "foo", "tags": [], "kind": "text"}`, "/kind: value not in enumeration"},
+	{`{"name": "foo", "tags": [], "bar": 1}`, "/: unexpected property bar"},
+	{`{"name": "foo", "tags": [], "source": {"/": "QmRN6wdp1S2A5EtjW9A3M1vKSBuQQGcgvuhoMUoEz4iiT5"}}`, ""},
+	{`{"name": "foo", "tags": [], "source": "foo"}`, "/source: expected link; got string"},
+	{`[]`, "/: expected object; got array"},
+}
+
+// objects are validated in their CBOR representation, as stored in the datastore
+func TestSchemaValidate(t *testing.T) {
+	schema, err := ParseSchema([]byte(testSchema))
+	checkErrorNow(t, "schema", err)
+
+	for _, v := range schemaObjects {
+		dec := json.NewDecoder(bytes.NewReader([]byte(v.json)))
+		dec.UseNumber()
+
+		var val interface{}
+		err := dec.Decode(&val)
+		checkErrorNow(t, v.json, err)
+
+		data, err := JSONToCBOR(val)
+		checkErrorNow(t, v.json, err)
+
+		val, err = CBORToJSON(data)
+		checkErrorNow(t, v.json, err)
+
+		err = schema.Validate(val)
+		switch {
+		case v.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", v.json, err.Error())
+		case v.err != "" && err == nil:
+			t.Errorf("%s: expected error %s", v.json, v.err)
+		case v.err != "" && err.Error() != v.err:
+			t.Errorf("%s: expected error %s; got %s", v.json, v.err, err.Error())
+		}
+	}
+}
+
+func TestSchemaParse(t *testing.T) {
+	bad := []string{
+		`[]`,
+		`{"type": "foo"}`,
+		`{"type": 1}`,
+		`{"properties": {"a": 1}}`,
+		`{"required": "a"}`,
+		`{"maxItems": -1}`,
+		`{"pattern": "("}`,
+		`{"oneOf": [{"type": "string"}]}`,
+		`{"$ref": "#/definitions/foo"}`,
+		`{"properties": {"a": {"type": "string", "format": "date"}}}`,
+		`{"items": {"exclusiveMinimum": 0}}`,
+		`{"minProperties": 1}`,
+		`{} {}`,
+	}
+
+	for _, s := range bad {
+		_, err := ParseSchema([]byte(s))
+		if err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package mc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	p2p_crypto "github.com/libp2p/go-libp2p-crypto"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+var (
+	UnknownSignerKey   = errors.New("Unknown signer key")
+	BadSignerMessage   = errors.New("Bad signer message")
+	BadSignerSignature = errors.New("Signer returned a bad signature")
+)
+
+// Signer signs statements on behalf of a publisher identity.
+// In-process private keys are Signers; SocketSigner delegates signing to an
+// external signing daemon, so that the key doesn't have to live in the node.
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+}
+
+// Signer protocol: the client connects to the daemon socket and sends a
+// single request, consisting of the base58 publisher id and the data to sign,
+// each prefixed by its length as a uvarint.
+// The daemon responds with a status byte, followed by the signature on
+// success or an error message on failure, prefixed by its length as a uvarint.
+// The connection is closed after the response.
+const (
+	SignerOK    = 0
+	SignerError = 1
+)
+
+const SignerTimeout = 30 * time.Second
+
+// SocketSigner signs with the key of Publisher held by the signing daemon
+// listening on the Unix socket at Path.
+type SocketSigner struct {
+	Path      string
+	Publisher string
+}
+
+func (s *SocketSigner) Sign(data []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", s.Path, SignerTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(SignerTimeout))
+
+	w := bufio.NewWriter(conn)
+	err = writeSignerFrame(w, []byte(s.Publisher))
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeSignerFrame(w, data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	status, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := readSignerFrame(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case SignerOK:
+		return s.verify(data, res)
+	case SignerError:
+		return nil, SignerResponseError(res)
+	default:
+		return nil, BadSignerMessage
+	}
+}
+
+// verify checks the signature returned by the daemon against the publisher
+// key, so that a misconfigured or compromised daemon can't get statements
+// with bad signatures stored and pushed to other nodes.
+func (s *SocketSigner) verify(data []byte, sig []byte) ([]byte, error) {
+	pubk, err := PublisherKey(s.Publisher)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := pubk.Verify(data, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, BadSignerSignature
+	}
+
+	return sig, nil
+}
+
+type SignerResponseError string
+
+func (e SignerResponseError) Error() string {
+	return "Signer error: " + string(e)
+}
+
+// ServeSigner runs a signing daemon on the listener, signing with keys
+// indexed by base58 publisher id; returns when the listener is closed.
+func ServeSigner(ln net.Listener, keys map[string]p2p_crypto.PrivKey) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go serveSignerConn(conn, keys)
+	}
+}
+
+func serveSignerConn(conn net.Conn, keys map[string]p2p_crypto.PrivKey) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(SignerTimeout))
+
+	r := bufio.NewReader(conn)
+	pub, err := readSignerFrame(r)
+	if err != nil {
+		log.Printf("signer: error reading request: %s", err.Error())
+		return
+	}
+
+	data, err := readSignerFrame(r)
+	if err != nil {
+		log.Printf("signer: error reading request: %s", err.Error())
+		return
+	}
+
+	var sig []byte
+	privk, ok := keys[string(pub)]
+	if ok {
+		sig, err = privk.Sign(data)
+	} else {
+		err = UnknownSignerKey
+	}
+
+	w := bufio.NewWriter(conn)
+	if err != nil {
+		w.WriteByte(SignerError)
+		writeSignerFrame(w, []byte(err.Error()))
+	} else {
+		w.WriteByte(SignerOK)
+		writeSignerFrame(w, sig)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		log.Printf("signer: error writing response: %s", err.Error())
+	}
+}
+
+func writeSignerFrame(w io.Writer, data []byte) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(data)))
+	_, err := w.Write(buf[:n])
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func readSignerFrame(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > MaxMessageSize {
+		return nil, BadSignerMessage
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
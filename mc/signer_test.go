@@ -0,0 +1,74 @@
+package mc
+
+import (
+	p2p_crypto "github.com/libp2p/go-libp2p-crypto"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSocketSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	checkErrorNow(t, "tempdir", err)
+	defer os.RemoveAll(dir)
+
+	pub, err := NewPublisherIdentity()
+	checkErrorNow(t, "generate", err)
+
+	sock := path.Join(dir, "signer.sock")
+	ln, err := net.Listen("unix", sock)
+	checkErrorNow(t, "listen", err)
+	defer ln.Close()
+
+	go ServeSigner(ln, map[string]p2p_crypto.PrivKey{pub.ID58: pub.PrivKey})
+
+	// the node side of the identity has no private key
+	ext := PublisherIdentity{ID58: pub.ID58, Signer: &SocketSigner{Path: sock, Publisher: pub.ID58}}
+	if ext.Locked() {
+		t.Fatal("identity with external signer is locked")
+	}
+
+	data := []byte("statement bytes")
+	sig, err := ext.Sign(data)
+	checkErrorNow(t, "sign", err)
+
+	pubk, err := PublisherKey(pub.ID58)
+	checkErrorNow(t, "publisher key", err)
+
+	ok, err := pubk.Verify(data, sig)
+	checkErrorNow(t, "verify", err)
+	if !ok {
+		t.Fatal("external signature does not verify")
+	}
+
+	other, err := NewPublisherIdentity()
+	checkErrorNow(t, "generate", err)
+
+	bad := &SocketSigner{Path: sock, Publisher: other.ID58}
+	_, err = bad.Sign(data)
+	if err != SignerResponseError(UnknownSignerKey.Error()) {
+		t.Fatalf("expected unknown key error; got %v", err)
+	}
+
+	// a daemon signing with the wrong key
+	sock2 := path.Join(dir, "signer2.sock")
+	ln2, err := net.Listen("unix", sock2)
+	checkErrorNow(t, "listen", err)
+	defer ln2.Close()
+
+	go ServeSigner(ln2, map[string]p2p_crypto.PrivKey{other.ID58: pub.PrivKey})
+
+	wrong := &SocketSigner{Path: sock2, Publisher: other.ID58}
+	_, err = wrong.Sign(data)
+	if err != BadSignerSignature {
+		t.Fatalf("expected BadSignerSignature; got %v", err)
+	}
+
+	locked := PublisherIdentity{ID58: pub.ID58}
+	_, err = locked.Sign(data)
+	if err != KeyLocked {
+		t.Fatalf("expected KeyLocked; got %v", err)
+	}
+}
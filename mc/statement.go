@@ -0,0 +1,174 @@
+package mc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	ggproto "github.com/gogo/protobuf/proto"
+	pb "github.com/mediachain/concat/proto"
+)
+
+var (
+	BadSigVersion        = errors.New("Unknown statement signature version")
+	UnknownStatementBody = errors.New("Unknown statement body")
+)
+
+// Statement signature versions: the sigVersion of a statement determines
+// the payload signed by the publisher.
+// Version 0 is the protobuf encoding of the statement without its signature,
+// which depends on deterministic marshalling across implementations.
+// Version 1 is a canonical encoding with explicit field order:
+//
+//	payload   := string("mediachain.statement") statement
+//	statement := uint32(sigVersion) string(id) string(publisher)
+//	             string(namespace) int64(timestamp) body
+//	body      := uint32(0)                                     -- no body
+//	           | uint32(1) simple                              -- simple
+//	           | uint32(2) uint32(n) simple*n                  -- compound
+//	           | uint32(3) uint32(n) (statement bytes(sig))*n  -- envelope
+//	           | uint32(4)                                     -- archive
+//	           | uint32(5) string(successor) bytes(sig)        -- succession
+//	simple    := string(object) strings(refs) strings(tags) strings(deps)
+//	strings   := uint32(n) string*n
+//
+// Integers are big endian; strings and bytes are prefixed by their length as
+// a uint32. Envelope statements are encoded with their own sigVersion and
+// signature, regardless of their signature version.
+const (
+	SigVersionProto     = 0
+	SigVersionCanonical = 1
+)
+
+const sigPayloadDomain = "mediachain.statement"
+
+// StatementSigPayload returns the payload signed by the publisher of a
+// statement, according to its signature version.
+func StatementSigPayload(stmt *pb.Statement) ([]byte, error) {
+	switch stmt.SigVersion {
+	case SigVersionProto:
+		sig := stmt.Signature
+		stmt.Signature = nil
+		bytes, err := ggproto.Marshal(stmt)
+		stmt.Signature = sig
+		return bytes, err
+
+	case SigVersionCanonical:
+		var enc sigEncoder
+		enc.putString(sigPayloadDomain)
+		err := enc.putStatement(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return enc.Bytes(), nil
+
+	default:
+		return nil, BadSigVersion
+	}
+}
+
+type sigEncoder struct {
+	bytes.Buffer
+}
+
+func (enc *sigEncoder) putUint32(x uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], x)
+	enc.Write(buf[:])
+}
+
+func (enc *sigEncoder) putInt64(x int64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(x))
+	enc.Write(buf[:])
+}
+
+func (enc *sigEncoder) putBytes(data []byte) {
+	enc.putUint32(uint32(len(data)))
+	enc.Write(data)
+}
+
+func (enc *sigEncoder) putString(str string) {
+	enc.putUint32(uint32(len(str)))
+	enc.WriteString(str)
+}
+
+func (enc *sigEncoder) putStrings(strs []string) {
+	enc.putUint32(uint32(len(strs)))
+	for _, str := range strs {
+		enc.putString(str)
+	}
+}
+
+func (enc *sigEncoder) putStatement(stmt *pb.Statement) error {
+	enc.putUint32(stmt.SigVersion)
+	enc.putString(stmt.Id)
+	enc.putString(stmt.Publisher)
+	enc.putString(stmt.Namespace)
+	enc.putInt64(stmt.Timestamp)
+
+	if stmt.Body == nil {
+		enc.putUint32(0)
+		return nil
+	}
+
+	switch body := stmt.Body.Body.(type) {
+	case nil:
+		enc.putUint32(0)
+
+	case *pb.StatementBody_Simple:
+		enc.putUint32(1)
+		enc.putSimple(body.Simple)
+
+	case *pb.StatementBody_Compound:
+		enc.putUint32(2)
+		simples := body.Compound.GetBody()
+		enc.putUint32(uint32(len(simples)))
+		for _, simple := range simples {
+			enc.putSimple(simple)
+		}
+
+	case *pb.StatementBody_Envelope:
+		enc.putUint32(3)
+		stmts := body.Envelope.GetBody()
+		enc.putUint32(uint32(len(stmts)))
+		for _, xstmt := range stmts {
+			if xstmt == nil {
+				xstmt = &pb.Statement{}
+			}
+
+			err := enc.putStatement(xstmt)
+			if err != nil {
+				return err
+			}
+			enc.putBytes(xstmt.Signature)
+		}
+
+	case *pb.StatementBody_Archive:
+		enc.putUint32(4)
+
+	case *pb.StatementBody_Succession:
+		succ := body.Succession
+		if succ == nil {
+			succ = &pb.SuccessionStatement{}
+		}
+		enc.putUint32(5)
+		enc.putString(succ.Successor)
+		enc.putBytes(succ.Signature)
+
+	default:
+		return UnknownStatementBody
+	}
+
+	return nil
+}
+
+func (enc *sigEncoder) putSimple(simple *pb.SimpleStatement) {
+	if simple == nil {
+		simple = &pb.SimpleStatement{}
+	}
+
+	enc.putString(simple.Object)
+	enc.putStrings(simple.Refs)
+	enc.putStrings(simple.Tags)
+	enc.putStrings(simple.Deps)
+}
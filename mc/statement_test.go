@@ -0,0 +1,145 @@
+package mc
+
+import (
+	"encoding/hex"
+	pb "github.com/mediachain/concat/proto"
+	"testing"
+)
+
+func simpleBody(simple *pb.SimpleStatement) *pb.StatementBody {
+	return &pb.StatementBody{&pb.StatementBody_Simple{simple}}
+}
+
+func sigVectorStatement() *pb.Statement {
+	return &pb.Statement{
+		Id:         "4XTTM:1:1",
+		Publisher:  "4XTTM",
+		Namespace:  "foo",
+		Timestamp:  1,
+		Body:       simpleBody(&pb.SimpleStatement{Object: "QmAAA", Refs: []string{"a"}, Deps: []string{"QmBBB"}}),
+		Signature:  []byte{1, 2},
+		SigVersion: SigVersionCanonical,
+	}
+}
+
+// Signature payload test vectors, for checking clients in other languages
+var sigPayloadVectors = []struct {
+	name    string
+	stmt    func() *pb.Statement
+	payload string
+}{
+	{"simple", sigVectorStatement,
+		"000000146d65646961636861696e2e73746174656d656e740000000100000009345854544d3a313a3100000005345854544d00000003666f6f00000000000000010000000100000005516d414141000000010000000161000000000000000100000005516d424242"},
+	{"compound", func() *pb.Statement {
+		return &pb.Statement{
+			Id:        "4XTTM:2:2",
+			Publisher: "4XTTM",
+			Namespace: "foo",
+			Timestamp: 2,
+			Body: &pb.StatementBody{&pb.StatementBody_Compound{&pb.CompoundStatement{
+				Body: []*pb.SimpleStatement{{Object: "QmAAA", Tags: []string{"t"}}, {Object: "QmBBB"}}}}},
+			SigVersion: SigVersionCanonical,
+		}
+	},
+		"000000146d65646961636861696e2e73746174656d656e740000000100000009345854544d3a323a3200000005345854544d00000003666f6f0000000000000002000000020000000200000005516d414141000000000000000100000001740000000000000005516d424242000000000000000000000000"},
+	{"envelope", func() *pb.Statement {
+		return &pb.Statement{
+			Id:         "4XTTM:3:3",
+			Publisher:  "4XTTM",
+			Namespace:  "bar",
+			Timestamp:  3,
+			Body:       &pb.StatementBody{&pb.StatementBody_Envelope{&pb.EnvelopeStatement{Body: []*pb.Statement{sigVectorStatement()}}}},
+			SigVersion: SigVersionCanonical,
+		}
+	},
+		"000000146d65646961636861696e2e73746174656d656e740000000100000009345854544d3a333a3300000005345854544d00000003626172000000000000000300000003000000010000000100000009345854544d3a313a3100000005345854544d00000003666f6f00000000000000010000000100000005516d414141000000010000000161000000000000000100000005516d424242000000020102"},
+	{"archive", func() *pb.Statement {
+		return &pb.Statement{
+			Id:         "4XTTM:5:5",
+			Publisher:  "4XTTM",
+			Namespace:  "foo",
+			Timestamp:  5,
+			Body:       &pb.StatementBody{&pb.StatementBody_Archive{&pb.ArchiveStatement{}}},
+			SigVersion: SigVersionCanonical,
+		}
+	},
+		"000000146d65646961636861696e2e73746174656d656e740000000100000009345854544d3a353a3500000005345854544d00000003666f6f000000000000000500000004"},
+	{"succession", func() *pb.Statement {
+		return &pb.Statement{
+			Id:         "4XTTM:4:4",
+			Publisher:  "4XTTM",
+			Namespace:  "mediachain.succession",
+			Timestamp:  -1,
+			Body:       &pb.StatementBody{&pb.StatementBody_Succession{&pb.SuccessionStatement{Successor: "4XTTN"}}},
+			SigVersion: SigVersionCanonical,
+		}
+	},
+		"000000146d65646961636861696e2e73746174656d656e740000000100000009345854544d3a343a3400000005345854544d000000156d65646961636861696e2e73756363657373696f6effffffffffffffff0000000500000005345854544e00000000"},
+	{"proto", func() *pb.Statement {
+		stmt := sigVectorStatement()
+		stmt.SigVersion = SigVersionProto
+		return stmt
+	},
+		"0a09345854544d3a313a311205345854544d1a03666f6f22130a110a05516d4141411201612205516d4242422801"},
+}
+
+func TestStatementSigPayload(t *testing.T) {
+	for _, vec := range sigPayloadVectors {
+		stmt := vec.stmt()
+		payload, err := StatementSigPayload(stmt)
+		checkErrorNow(t, vec.name, err)
+
+		if hex.EncodeToString(payload) != vec.payload {
+			t.Errorf("%s: expected %s; got %x", vec.name, vec.payload, payload)
+		}
+	}
+
+	stmt := sigVectorStatement()
+	stmt.SigVersion = 2
+	_, err := StatementSigPayload(stmt)
+	if err != BadSigVersion {
+		t.Fatalf("expected BadSigVersion; got %v", err)
+	}
+}
+
+func TestStatementSignature(t *testing.T) {
+	pub, err := NewPublisherIdentity()
+	checkErrorNow(t, "generate", err)
+
+	pubk, err := PublisherKey(pub.ID58)
+	checkErrorNow(t, "publisher key", err)
+
+	for _, version := range []uint32{SigVersionProto, SigVersionCanonical} {
+		stmt := sigVectorStatement()
+		stmt.Publisher = pub.ID58
+		stmt.Signature = nil
+		stmt.SigVersion = version
+
+		payload, err := StatementSigPayload(stmt)
+		checkErrorNow(t, "payload", err)
+
+		stmt.Signature, err = pub.Sign(payload)
+		checkErrorNow(t, "sign", err)
+
+		// the signature doesn't affect the payload
+		xpayload, err := StatementSigPayload(stmt)
+		checkErrorNow(t, "payload", err)
+
+		ok, err := pubk.Verify(xpayload, stmt.Signature)
+		checkErrorNow(t, "verify", err)
+		if !ok {
+			t.Fatalf("version %d: signature doesn't verify", version)
+		}
+
+		// the signature version is signed too
+		stmt.SigVersion = 1 - version
+		xpayload, err = StatementSigPayload(stmt)
+		checkErrorNow(t, "payload", err)
+
+		ok, err = pubk.Verify(xpayload, stmt.Signature)
+		checkErrorNow(t, "verify", err)
+		if ok {
+			t.Fatalf("version %d: signature verifies in version %d", version, stmt.SigVersion)
+		}
+	}
+}
@@ -170,6 +170,7 @@ func (dir *Directory) listPeers() []string {
 func main() {
 	port := flag.Int("l", 9000, "Listen port")
 	hdir := flag.String("d", "~/.mediachain/mcdir", "Directory home")
+	peerKey := flag.String("peer-key", mc.DefaultPeerKey, "Key type for a new directory key: rsa or ed25519")
 	flag.Parse()
 
 	if len(flag.Args()) != 0 {
@@ -188,11 +189,15 @@ func main() {
 		log.Fatal(err)
 	}
 
-	id, err := mc.MakePeerIdentity(home)
+	id, err := mc.MakePeerIdentityKey(home, *peerKey, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if id.Locked() {
+		log.Fatal(mc.KeyLocked)
+	}
+
 	addr, err := mc.ParseAddress(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", *port))
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	ggio "github.com/gogo/protobuf/io"
+	p2p_peer "github.com/libp2p/go-libp2p-peer"
+	p2p_pstore "github.com/libp2p/go-libp2p-peerstore"
+	mc "github.com/mediachain/concat/mc"
+	pb "github.com/mediachain/concat/proto"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// TestDirectoryEd25519 registers an Ed25519 node with the directory and
+// looks it up from an RSA node.
+func TestDirectoryEd25519(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping network test in short mode")
+	}
+
+	home, err := ioutil.TempDir("", "mcdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	laddr, err := mc.ParseAddress("/ip4/127.0.0.1/tcp/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ktypes := []string{mc.Ed25519PeerKey, mc.Ed25519PeerKey, mc.RSAPeerKey}
+	ids := make([]mc.PeerIdentity, len(ktypes))
+	for x, ktype := range ktypes {
+		dir := path.Join(home, fmt.Sprintf("node%d", x))
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids[x], err = mc.MakePeerIdentityKey(dir, ktype, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dhost, err := mc.NewHost(ctx, ids[0], laddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dhost.Close()
+
+	dir := &Directory{PeerIdentity: ids[0], host: dhost, peers: make(map[p2p_peer.ID]p2p_pstore.PeerInfo)}
+	dhost.SetStreamHandler("/mediachain/dir/register", dir.registerHandler)
+	dhost.SetStreamHandler("/mediachain/dir/lookup", dir.lookupHandler)
+	dinfo := p2p_pstore.PeerInfo{ID: dhost.ID(), Addrs: dhost.Addrs()}
+
+	// register the Ed25519 node
+	host, err := mc.NewHost(ctx, ids[1], laddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+
+	err = host.Connect(ctx, dinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := host.NewStream(ctx, dhost.ID(), "/mediachain/dir/register")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	pinfo := p2p_pstore.PeerInfo{ID: host.ID(), Addrs: host.Addrs()}
+	var pbpi pb.PeerInfo
+	mc.PBFromPeerInfo(&pbpi, pinfo)
+	err = ggio.NewDelimitedWriter(s).WriteMsg(&pb.RegisterPeer{Info: &pbpi})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// look it up from the RSA node
+	client, err := mc.NewHost(ctx, ids[2], laddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	err = client.Connect(ctx, dinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ls, err := client.NewStream(ctx, dhost.ID(), "/mediachain/dir/lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	r := ggio.NewDelimitedReader(ls, mc.MaxMessageSize)
+	w := ggio.NewDelimitedWriter(ls)
+
+	var req pb.LookupPeerRequest
+	var res pb.LookupPeerResponse
+	req.Id = host.ID().Pretty()
+
+	// the registration is processed asynchronously; retry until it's in
+	for res.Peer == nil {
+		select {
+		case <-ctx.Done():
+			t.Fatal("lookup timed out")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		res.Reset()
+		err = w.WriteMsg(&req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.ReadMsg(&res)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	xpinfo, err := mc.PBToPeerInfo(res.Peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if xpinfo.ID != host.ID() || !xpinfo.ID.MatchesPublicKey(ids[1].PrivKey.GetPublic()) {
+		t.Fatalf("lookup: expected %s; got %s", host.ID().Pretty(), xpinfo.ID.Pretty())
+	}
+
+	if len(xpinfo.Addrs) != len(pinfo.Addrs) {
+		t.Fatalf("lookup: expected %d addresses; got %d", len(pinfo.Addrs), len(xpinfo.Addrs))
+	}
+
+	for x, addr := range pinfo.Addrs {
+		if !xpinfo.Addrs[x].Equal(addr) {
+			t.Fatalf("lookup: expected address %s; got %s", addr, xpinfo.Addrs[x])
+		}
+	}
+}
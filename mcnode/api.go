@@ -2,9 +2,11 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	ggio "github.com/gogo/protobuf/io"
 	mux "github.com/gorilla/mux"
 	p2p_peer "github.com/libp2p/go-libp2p-peer"
 	mc "github.com/mediachain/concat/mc"
@@ -15,6 +17,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -30,18 +33,73 @@ func apiNetError(w http.ResponseWriter, err error) {
 	switch err {
 	case UnknownPeer:
 		apiError(w, http.StatusNotFound, err)
+	case QuotaExceeded:
+		apiError(w, http.StatusInsufficientStorage, err)
 	default:
 		apiError(w, http.StatusInternalServerError, err)
 	}
 }
 
+// apiPublisherError rejects a request for a publisher identity that can't
+// be used; locked identities are reported as such.
+func apiPublisherError(w http.ResponseWriter, err error) {
+	switch err {
+	case NodeLocked:
+		apiError(w, http.StatusLocked, err)
+	default:
+		apiError(w, http.StatusBadRequest, err)
+	}
+}
+
+// apiValidationError rejects a publish request, with the validation report
+// as ndjson
+func apiValidationError(w http.ResponseWriter, report []ValidationReport) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusBadRequest)
+
+	enc := json.NewEncoder(w)
+	for _, entry := range report {
+		err := enc.Encode(entry)
+		if err != nil {
+			log.Printf("Error writing response body: %s", err.Error())
+			return
+		}
+	}
+}
+
+// apiHashOption returns the hash function selected with ?hash=name
+func apiHashOption(r *http.Request) (uint64, error) {
+	hash := r.URL.Query().Get("hash")
+	if hash == "" {
+		hash = mc.DefaultHashFunction
+	}
+
+	code, ok := mc.HashFunctions[hash]
+	if !ok {
+		return 0, mc.UnsupportedHash
+	}
+
+	return code, nil
+}
+
+// apiDepthOption parses the ?depth=n option for merkle link traversal;
+// a negative depth follows links without limit.
+func apiDepthOption(r *http.Request, def int) (int, error) {
+	opt := r.URL.Query().Get("depth")
+	if opt == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(opt)
+}
+
 // Local node REST API implementation
 
 // GET /id
 // Returns the node info, which includes the peer and publisher ids, and the
 // configured node information.
 func (node *Node) httpId(w http.ResponseWriter, r *http.Request) {
-	ninfo := NodeInfo{node.PeerIdentity.Pretty(), node.publisher.Pretty(), node.info}
+	ninfo := NodeInfo{node.peerIdentity().Pretty(), node.defaultPublisher().Pretty(), node.info}
 
 	err := json.NewEncoder(w).Encode(ninfo)
 	if err != nil {
@@ -172,7 +230,7 @@ func (node *Node) httpDirList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// filter self from result set
-	mypid := node.PeerIdentity.Pretty()
+	mypid := node.peerIdentity().Pretty()
 	for _, peer := range peers {
 		if peer != mypid {
 			fmt.Fprintln(w, peer)
@@ -181,6 +239,7 @@ func (node *Node) httpDirList(w http.ResponseWriter, r *http.Request) {
 }
 
 var nsrx *regexp.Regexp
+var nsselrx *regexp.Regexp
 
 func init() {
 	rx, err := regexp.Compile("^[a-zA-Z0-9-]+([.][a-zA-Z0-9-]+)*$")
@@ -188,12 +247,27 @@ func init() {
 		log.Fatal(err)
 	}
 	nsrx = rx
+
+	rx, err = regexp.Compile("^([*]|[a-zA-Z0-9-]+([.][a-zA-Z0-9-]+)*([.][*])?)$")
+	if err != nil {
+		log.Fatal(err)
+	}
+	nsselrx = rx
 }
 
 // POST /publish/{namespace}
 // DATA: A stream of json-encoded pb.SimpleStatements
 // Publishes a batch of statements to the specified namespace.
 // Returns the statement ids as a newline delimited stream.
+// Statements can carry a dedup key (as "key"), which makes publishing
+// idempotent: a statement whose key was already published in the namespace
+// is not published again, and the existing statement id is returned.
+// With ?dedup=true statements without a key are keyed by their body.
+// If the namespace has a schema, the statement objects are validated and
+// the batch is rejected with an ndjson validation report if any object
+// fails validation.
+// With ?stream=true the statements are published incrementally, see
+// httpPublishStream.
 func (node *Node) httpPublish(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	ns := vars["namespace"]
@@ -204,42 +278,24 @@ func (node *Node) httpPublish(w http.ResponseWriter, r *http.Request) {
 	}
 
 	dec := json.NewDecoder(r.Body)
-	stmts := make([]interface{}, 0, 1024)
-
-loop:
-	for {
-		sbody := new(pb.SimpleStatement)
-		err := dec.Decode(sbody)
-		switch {
-		case err == io.EOF:
-			break loop
-		case err != nil:
-			apiError(w, http.StatusBadRequest, err)
-			return
-		default:
-			stmts = append(stmts, sbody)
+	next := func() (interface{}, string, error) {
+		rec := new(PublishStatement)
+		err := dec.Decode(rec)
+		if err != nil {
+			return nil, "", err
 		}
+		return &rec.SimpleStatement, rec.Key, nil
 	}
 
-	if len(stmts) == 0 {
-		return
-	}
-
-	sids, err := node.doPublishBatch(ns, stmts)
-	if err != nil {
-		apiError(w, http.StatusInternalServerError, err)
-		return
-	}
-
-	for _, sid := range sids {
-		fmt.Fprintln(w, sid)
-	}
+	node.httpPublishRecords(w, r, ns, next)
 }
 
 // POST /publish/{namespace}/{combine}
 // DATA: A stream of json-encoded pb.SimpleStatements using CompoundStatement grouping
 // Publishes a batch of statements to the specified namespace.
 // Returns the statement ids as a newline delimited stream.
+// With ?dedup=true compound statements are deduplicated by their body.
+// Accepts ?stream=true as /publish.
 func (node *Node) httpPublishCompound(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
@@ -257,231 +313,724 @@ func (node *Node) httpPublishCompound(w http.ResponseWriter, r *http.Request) {
 	}
 
 	dec := json.NewDecoder(r.Body)
-	stmts := make([]interface{}, 0, 1000/clen)
-	body := make([]*pb.SimpleStatement, 0, clen)
-
-loop:
-	for {
+	next := func() (interface{}, string, error) {
+		body := make([]*pb.SimpleStatement, 0, clen)
 		for x := 0; x < clen; x++ {
 			sbody := new(pb.SimpleStatement)
-			err = dec.Decode(sbody)
+			err := dec.Decode(sbody)
 			switch {
 			case err == io.EOF:
-				break loop
+				if len(body) > 0 {
+					return &pb.CompoundStatement{body}, "", nil
+				}
+				return nil, "", err
 			case err != nil:
-				apiError(w, http.StatusBadRequest, err)
-				return
+				return nil, "", err
 			default:
 				body = append(body, sbody)
 			}
 		}
 
-		stmt := &pb.CompoundStatement{body}
-		stmts = append(stmts, stmt)
-		body = make([]*pb.SimpleStatement, 0, clen)
+		return &pb.CompoundStatement{body}, "", nil
 	}
 
-	if len(body) > 0 {
-		stmt := &pb.CompoundStatement{body}
-		stmts = append(stmts, stmt)
-	}
+	node.httpPublishRecords(w, r, ns, next)
+}
 
-	if len(stmts) == 0 {
-		return
-	}
+// publishReader returns the next statement body and dedup key in a publish
+// request, or io.EOF at the end of the request.
+type publishReader func() (interface{}, string, error)
 
-	sids, err := node.doPublishBatch(ns, stmts)
+func (node *Node) httpPublishRecords(w http.ResponseWriter, r *http.Request, ns string, next publishReader) {
+	pub, err := node.getPublisher(r.URL.Query().Get("publisher"))
 	if err != nil {
-		apiError(w, http.StatusInternalServerError, err)
+		apiPublisherError(w, err)
 		return
 	}
 
-	for _, sid := range sids {
-		fmt.Fprintln(w, sid)
+	dedup := r.URL.Query().Get("dedup") == "true"
+
+	if r.URL.Query().Get("stream") == "true" {
+		node.httpPublishStream(w, pub, ns, next, dedup)
+		return
 	}
-}
 
-// GET /stmt/{statementId}
-// Retrieves a statement by id
-func (node *Node) httpStatement(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["statementId"]
+	stmts := make([]interface{}, 0, 1024)
+	keys := make([]string, 0, 1024)
 
-	stmt, err := node.db.Get(id)
-	if err != nil {
-		switch err {
-		case UnknownStatement:
-			apiError(w, http.StatusNotFound, err)
+loop:
+	for {
+		body, key, err := next()
+		switch {
+		case err == io.EOF:
+			break loop
+		case err != nil:
+			apiError(w, http.StatusBadRequest, err)
 			return
-
 		default:
-			apiError(w, http.StatusInternalServerError, err)
-			return
+			stmts = append(stmts, body)
+			keys = append(keys, key)
 		}
 	}
 
-	err = json.NewEncoder(w).Encode(stmt)
-	if err != nil {
-		log.Printf("Error writing response body: %s", err.Error())
+	if len(stmts) == 0 {
+		return
 	}
-}
 
-// POST /query
-// DATA: MCQL SELECT query
-// Queries the statement database and return the result set in ndjson
-func (node *Node) httpQuery(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
+	dkeys, err := publishDedupKeys(ns, stmts, keys, dedup)
 	if err != nil {
-		log.Printf("http/query: Error reading request body: %s", err.Error())
+		apiError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	q, err := mcq.ParseQuery(string(body))
+	report, err := node.validateStatements(ns, stmts)
 	if err != nil {
-		apiError(w, http.StatusBadRequest, err)
+		apiError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if q.Op != mcq.OpSelect {
-		apiError(w, http.StatusBadRequest, BadQuery)
+	if len(report) > 0 {
+		apiValidationError(w, report)
 		return
 	}
 
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
-
-	ch, err := node.db.QueryStream(ctx, q)
+	sids, err := node.doPublishBatch(pub, ns, stmts, dkeys)
 	if err != nil {
 		apiError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	for _, sid := range sids {
+		fmt.Fprintln(w, sid)
+	}
+}
+
+// httpPublishStream publishes statements in chunks of PublishChunk, each
+// committed in its own transaction, without buffering the whole request.
+// The response is an ndjson stream of PublishStreamResults, written as
+// each chunk commits: the statement id for each published statement, or
+// the error for each statement rejected by schema validation (which does
+// not prevent the rest of the chunk from being published).
+// The stream ends with a PublishSummary; if the request fails midway, the
+// summary carries the error and no statements after the last reported
+// result have been published.
+func (node *Node) httpPublishStream(w http.ResponseWriter, pub mc.PublisherIdentity, ns string, next publishReader, dedup bool) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
 	enc := json.NewEncoder(w)
-	for obj := range ch {
-		err = enc.Encode(obj)
+	flusher, _ := w.(http.Flusher)
+
+	var summary PublishSummary
+	stmts := make([]interface{}, 0, PublishChunk)
+	keys := make([]string, 0, PublishChunk)
+
+	writeResults := func(res []PublishStreamResult) error {
+		for _, val := range res {
+			err := enc.Encode(val)
+			if err != nil {
+				return err
+			}
+
+			if val.Error != "" {
+				summary.Errors++
+			} else {
+				summary.Statements++
+			}
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		return nil
+	}
+
+	publish := func() error {
+		res, err := node.doPublishChunk(pub, ns, stmts, keys, dedup, summary.Statements+summary.Errors)
 		if err != nil {
-			log.Printf("Error encoding query result: %s", err.Error())
-			return
+			return err
 		}
+
+		stmts = stmts[:0]
+		keys = keys[:0]
+		return writeResults(res)
 	}
-}
 
-// POST /query/{peerId}
-// DATA: MCQL SELECT query
-// Queries a remote peer and returns the result set in ndjson
-func (node *Node) httpRemoteQuery(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	peerId := vars["peerId"]
+	var err error
+loop:
+	for {
+		var body interface{}
+		var key string
+		body, key, err = next()
+		switch {
+		case err == io.EOF:
+			err = nil
+			break loop
+		case err != nil:
+			break loop
+		}
 
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("http/query: Error reading request body: %s", err.Error())
-		return
+		stmts = append(stmts, body)
+		keys = append(keys, key)
+
+		if len(stmts) >= PublishChunk {
+			err = publish()
+			if err != nil {
+				break loop
+			}
+		}
 	}
 
-	q := string(body)
+	if err == nil && len(stmts) > 0 {
+		err = publish()
+	}
 
-	qq, err := mcq.ParseQuery(q)
 	if err != nil {
-		apiError(w, http.StatusBadRequest, err)
-		return
+		summary.Error = err.Error()
 	}
 
-	if qq.Op != mcq.OpSelect {
-		apiError(w, http.StatusBadRequest, BadQuery)
+	err = enc.Encode(PublishStreamSummary{summary})
+	if err != nil {
+		log.Printf("Error writing response body: %s", err.Error())
+	}
+}
+
+// POST /publish/{namespace}/objects
+// DATA: A stream of json-encoded PublishObjects
+// Stores the objects in the datastore and publishes a statement for each
+// object in the specified namespace, in a single request.
+// With ?idSelector=.path the well-known identifier of each object is
+// selected from the object and added to the statement refs, prefixed
+// with ?prefix=str; ?hash=name selects the hash function as in /data/put.
+// Dedup keys are supported as in /publish.
+// Returns the statement and object ids as ndjson PublishResults, or a
+// validation report if any of the objects is rejected.
+func (node *Node) httpPublishObjects(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ns := vars["namespace"]
+
+	if !nsrx.Match([]byte(ns)) {
+		apiError(w, http.StatusBadRequest, BadNamespace)
 		return
 	}
 
-	pid, err := p2p_peer.IDB58Decode(peerId)
+	code, err := apiHashOption(r)
 	if err != nil {
 		apiError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
-
-	ch, err := node.doRemoteQuery(ctx, pid, q)
+	pub, err := node.getPublisher(r.URL.Query().Get("publisher"))
 	if err != nil {
-		apiNetError(w, err)
+		apiPublisherError(w, err)
 		return
 	}
 
-	enc := json.NewEncoder(w)
-	for obj := range ch {
-		err = enc.Encode(obj)
+	var sel []string
+	if opt := r.URL.Query().Get("idSelector"); opt != "" {
+		sel, err = parseIdSelector(opt)
 		if err != nil {
-			log.Printf("Error encoding query result: %s", err.Error())
+			apiError(w, http.StatusBadRequest, err)
 			return
 		}
 	}
-}
-
-// POST /merge/{peerId}
-// DATA: MCQL SELECT query
-// Queries a remote peer and merges the resulting statements into the local
-// db; returns the number of statements and objects merged
-func (node *Node) httpMerge(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	peerId := vars["peerId"]
 
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("http/merge: Error reading request body: %s", err.Error())
-		return
-	}
+	prefix := r.URL.Query().Get("prefix")
+	dedup := r.URL.Query().Get("dedup") == "true"
 
-	q := string(body)
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	objs := make([]*PublishObject, 0, 1024)
 
-	qq, err := mcq.ParseQuery(q)
-	if err != nil {
-		apiError(w, http.StatusBadRequest, err)
-		return
+loop:
+	for {
+		obj := new(PublishObject)
+		err := dec.Decode(obj)
+		switch {
+		case err == io.EOF:
+			break loop
+		case err != nil:
+			apiError(w, http.StatusBadRequest, err)
+			return
+		default:
+			objs = append(objs, obj)
+		}
 	}
 
-	if !qq.IsSimpleSelect("*") {
-		apiError(w, http.StatusBadRequest, BadQuery)
+	if len(objs) == 0 {
 		return
 	}
 
-	pid, err := p2p_peer.IDB58Decode(peerId)
-	if err != nil {
-		apiError(w, http.StatusBadRequest, err)
+	res, report, err := node.doPublishObjects(pub, ns, objs, code, sel, prefix, dedup)
+	switch {
+	case err == QuotaExceeded:
+		apiError(w, http.StatusInsufficientStorage, err)
+		return
+	case err != nil:
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	case len(report) > 0:
+		apiValidationError(w, report)
 		return
 	}
 
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
-
-	count, ocount, err := node.doMerge(ctx, pid, q)
-	if err != nil {
-		apiNetError(w, err)
-		if count > 0 {
-			fmt.Fprintf(w, "Partial merge: %d statements merged\n", count)
-		}
-		if ocount > 0 {
-			fmt.Fprintf(w, "Partial merge: %d objects merged\n", ocount)
+	enc := json.NewEncoder(w)
+	for _, val := range res {
+		err = enc.Encode(val)
+		if err != nil {
+			log.Printf("Error writing response body: %s", err.Error())
+			return
 		}
-
-		return
 	}
-
-	fmt.Fprintln(w, count)
-	fmt.Fprintln(w, ocount)
 }
 
-// POST /push/{peerId}
-// DATA: MCQL SELECT query
-// Pushes statements matching the query to peerId for merge; must be
-// authorized for push by the peer
-// returns the number of statements and objects merged
-func (node *Node) httpPush(w http.ResponseWriter, r *http.Request) {
+// POST /publish/{namespace}/envelope
+// DATA: MCQL SELECT query, or with ?format=ids a newline delimited list of
+// statement ids
+// Re-publishes statements from the local db in the specified namespace,
+// wrapped in envelope statements signed by the node's publisher; the
+// original statements are preserved with their signatures, and remain the
+// source of the envelopes in queries.
+// With ?combine=n envelopes contain up to n statements from the same source.
+// Returns the envelope statement ids as a newline delimited stream.
+func (node *Node) httpPublishEnvelope(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	peerId := vars["peerId"]
+	ns := vars["namespace"]
 
-	body, err := ioutil.ReadAll(r.Body)
+	if !nsrx.Match([]byte(ns)) {
+		apiError(w, http.StatusBadRequest, BadNamespace)
+		return
+	}
+
+	pub, err := node.getPublisher(r.URL.Query().Get("publisher"))
 	if err != nil {
-		log.Printf("http/push: Error reading request body: %s", err.Error())
+		apiPublisherError(w, err)
+		return
+	}
+
+	clen := 1
+	if opt := r.URL.Query().Get("combine"); opt != "" {
+		n, err := strconv.Atoi(opt)
+		if err != nil || n < 1 {
+			apiError(w, http.StatusBadRequest, BadCombine)
+			return
+		}
+		clen = n
+	}
+
+	var stmts []*pb.Statement
+
+	switch r.URL.Query().Get("format") {
+	case "":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("http/publish: Error reading request body: %s", err.Error())
+			return
+		}
+
+		q, err := mcq.ParseQuery(string(body))
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if !q.IsSimpleSelect("*") {
+			apiError(w, http.StatusBadRequest, BadQuery)
+			return
+		}
+
+		res, err := node.db.Query(q)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		stmts = make([]*pb.Statement, 0, len(res))
+		for _, val := range res {
+			stmt, ok := val.(*pb.Statement)
+			if !ok {
+				apiError(w, http.StatusInternalServerError, BadResult)
+				return
+			}
+			stmts = append(stmts, stmt)
+		}
+
+	case "ids":
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			id := strings.TrimSpace(scanner.Text())
+			if id == "" {
+				continue
+			}
+
+			stmt, err := node.db.Get(id)
+			switch {
+			case err == UnknownStatement:
+				apiError(w, http.StatusNotFound, fmt.Errorf("%s: %s", err.Error(), id))
+				return
+			case err != nil:
+				apiError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			stmts = append(stmts, stmt)
+		}
+
+		err := scanner.Err()
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+
+	default:
+		apiError(w, http.StatusBadRequest, BadFormat)
+		return
+	}
+
+	if len(stmts) == 0 {
+		return
+	}
+
+	sids, err := node.doPublishEnvelopes(pub, ns, stmts, clen)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, sid := range sids {
+		fmt.Fprintln(w, sid)
+	}
+}
+
+// GET /publisher
+// Returns the node's publisher identities, as a json map of names to ids
+func (node *Node) httpPublishers(w http.ResponseWriter, r *http.Request) {
+	pubs := node.listPublishers()
+
+	err := json.NewEncoder(w).Encode(pubs)
+	if err != nil {
+		log.Printf("Error writing response body: %s", err.Error())
+	}
+}
+
+// GET  /publisher/{name}
+// POST /publisher/{name}
+// Retrieves/creates a named publisher identity; returns the publisher id.
+// POST with an empty body generates a new key; otherwise the body is a
+// private key, in the format of publisher key files, which is imported.
+func (node *Node) httpPublisher(w http.ResponseWriter, r *http.Request) {
+	apiConfigMethod(w, r, node.httpPublisherGet, node.httpPublisherSet)
+}
+
+func (node *Node) httpPublisherGet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	if name == "" {
+		name = DefaultPublisher
+	}
+
+	id58, ok := node.listPublishers()[name]
+	if !ok {
+		apiError(w, http.StatusNotFound, UnknownPublisher)
+		return
+	}
+
+	fmt.Fprintln(w, id58)
+}
+
+func (node *Node) httpPublisherSet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	key, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/publisher: Error reading request body: %s", err.Error())
+		return
+	}
+
+	pub, err := node.addPublisher(name, key)
+	switch {
+	case err == mc.PublisherExists || err == SignerKeys:
+		apiError(w, http.StatusConflict, err)
+		return
+	case err == NodeLocked:
+		apiError(w, http.StatusLocked, err)
+		return
+	case err == mc.BadPublisherName:
+		apiError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	fmt.Fprintln(w, pub.ID58)
+}
+
+// POST /publisher/{name}/rotate
+// Rotates the key of a publisher identity: a new key replaces the current
+// key, which is retired, and a succession statement signed by both keys is
+// published in the succession namespace.
+// Returns the new publisher id and the id of the succession statement.
+func (node *Node) httpPublisherRotate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	pub, sid, err := node.doRotatePublisher(name)
+	switch {
+	case err == UnknownPublisher:
+		apiError(w, http.StatusNotFound, err)
+		return
+	case err == ExternalPublisher:
+		apiError(w, http.StatusConflict, err)
+		return
+	case err == NodeLocked:
+		apiError(w, http.StatusLocked, err)
+		return
+	case err != nil:
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := map[string]string{"publisher": pub.ID58, "statement": sid}
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		log.Printf("Error writing response body: %s", err.Error())
+	}
+}
+
+// GET /succession/conflicts
+// Returns the conflicting publisher key successions as ndjson; each entry
+// has a key with multiple successors or predecessors, and the ids of the
+// conflicting succession statements. Conflicting successions are not
+// followed by queries.
+func (node *Node) httpSuccessionConflicts(w http.ResponseWriter, r *http.Request) {
+	ps, err := node.loadSuccession()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	for _, conflict := range ps.Conflicts() {
+		err = enc.Encode(conflict)
+		if err != nil {
+			log.Printf("Error writing response body: %s", err.Error())
+			return
+		}
+	}
+}
+
+// GET /stmt/{statementId}
+// Retrieves a statement by id
+func (node *Node) httpStatement(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["statementId"]
+
+	stmt, err := node.db.Get(id)
+	if err != nil {
+		switch err {
+		case UnknownStatement:
+			apiError(w, http.StatusNotFound, err)
+			return
+
+		default:
+			apiError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	err = json.NewEncoder(w).Encode(stmt)
+	if err != nil {
+		log.Printf("Error writing response body: %s", err.Error())
+	}
+}
+
+// POST /query
+// DATA: MCQL SELECT query
+// Queries the statement database and return the result set in ndjson
+// With ?succession=true publisher criteria match all the keys in the
+// succession chain of the publisher.
+func (node *Node) httpQuery(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/query: Error reading request body: %s", err.Error())
+		return
+	}
+
+	q, err := mcq.ParseQuery(string(body))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if q.Op != mcq.OpSelect {
+		apiError(w, http.StatusBadRequest, BadQuery)
+		return
+	}
+
+	if r.URL.Query().Get("succession") == "true" {
+		ps, err := node.loadSuccession()
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		q = q.WithPublisherChain(ps.Chain)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	ch, err := node.db.QueryStream(ctx, q)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	for obj := range ch {
+		err = enc.Encode(obj)
+		if err != nil {
+			log.Printf("Error encoding query result: %s", err.Error())
+			return
+		}
+	}
+}
+
+// POST /query/{peerId}
+// DATA: MCQL SELECT query
+// Queries a remote peer and returns the result set in ndjson
+func (node *Node) httpRemoteQuery(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	peerId := vars["peerId"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/query: Error reading request body: %s", err.Error())
+		return
+	}
+
+	q := string(body)
+
+	qq, err := mcq.ParseQuery(q)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if qq.Op != mcq.OpSelect {
+		apiError(w, http.StatusBadRequest, BadQuery)
+		return
+	}
+
+	pid, err := p2p_peer.IDB58Decode(peerId)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	ch, err := node.doRemoteQuery(ctx, pid, q)
+	if err != nil {
+		apiNetError(w, err)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	for obj := range ch {
+		err = enc.Encode(obj)
+		if err != nil {
+			log.Printf("Error encoding query result: %s", err.Error())
+			return
+		}
+	}
+}
+
+// POST /merge/{peerId}
+// DATA: MCQL SELECT query
+// Queries a remote peer and merges the resulting statements into the local
+// db; returns the number of statements and objects merged
+// With ?depth=n the objects linked from merged objects are also merged,
+// up to n levels deep; a negative depth merges all linked objects.
+// With merge validation, the objects rejected by their namespace schema
+// follow, one schema violation per line; their statements are not merged.
+func (node *Node) httpMerge(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	peerId := vars["peerId"]
+
+	depth, err := apiDepthOption(r, 0)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/merge: Error reading request body: %s", err.Error())
+		return
+	}
+
+	q := string(body)
+
+	qq, err := mcq.ParseQuery(q)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !qq.IsSimpleSelect("*") {
+		apiError(w, http.StatusBadRequest, BadQuery)
+		return
+	}
+
+	pid, err := p2p_peer.IDB58Decode(peerId)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	count, ocount, rejects, err := node.doMerge(ctx, pid, q, depth)
+	if err != nil {
+		apiNetError(w, err)
+		if count > 0 {
+			fmt.Fprintf(w, "Partial merge: %d statements merged\n", count)
+		}
+		if ocount > 0 {
+			fmt.Fprintf(w, "Partial merge: %d objects merged\n", ocount)
+		}
+		for _, reject := range rejects {
+			fmt.Fprintln(w, reject.Error())
+		}
+
+		return
+	}
+
+	fmt.Fprintln(w, count)
+	fmt.Fprintln(w, ocount)
+	for _, reject := range rejects {
+		fmt.Fprintln(w, reject.Error())
+	}
+}
+
+// POST /push/{peerId}
+// DATA: MCQL SELECT query
+// Pushes statements matching the query to peerId for merge; must be
+// authorized for push by the peer
+// returns the number of statements and objects merged
+func (node *Node) httpPush(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	peerId := vars["peerId"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/push: Error reading request body: %s", err.Error())
 		return
 	}
 
@@ -523,60 +1072,270 @@ func (node *Node) httpPush(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Fprintln(w, count)
-	fmt.Fprintln(w, ocount)
+	fmt.Fprintln(w, count)
+	fmt.Fprintln(w, ocount)
+}
+
+// POST /delete
+// DATA: MCQL DELTE query
+// Deletes statements from the statement db
+// With ?cascade=true, the objects and deps of the deleted statements that are
+// no longer referenced by any statement are deleted from the datastore.
+// Returns the number of statements deleted, followed by the number of objects
+// deleted for cascading deletes
+func (node *Node) httpDelete(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/query: Error reading request body: %s", err.Error())
+		return
+	}
+
+	q, err := mcq.ParseQuery(string(body))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if q.Op != mcq.OpDelete {
+		apiError(w, http.StatusBadRequest, BadQuery)
+		return
+	}
+
+	if r.URL.Query().Get("cascade") == "true" {
+		count, ocount, err := node.db.DeleteCascade(q, node.ds)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err)
+			if count > 0 {
+				fmt.Fprintf(w, "Partial delete: %d statements deleted\n", count)
+			}
+			if ocount > 0 {
+				fmt.Fprintf(w, "Partial delete: %d objects deleted\n", ocount)
+			}
+			return
+		}
+
+		fmt.Fprintln(w, count)
+		fmt.Fprintln(w, ocount)
+		return
+	}
+
+	count, err := node.db.Delete(q)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		if count > 0 {
+			fmt.Fprintf(w, "Partial delete: %d statements deleted\n", count)
+		}
+		return
+	}
+
+	fmt.Fprintln(w, count)
+}
+
+// datastore interface
+type DataObject struct {
+	Key  string `json:"key,omitempty"`
+	Data []byte `json:"data"`
+}
+
+// data object decoded from CBOR
+type JSONDataObject struct {
+	Key  string      `json:"key,omitempty"`
+	Data interface{} `json:"data"`
+}
+
+// Get /data/get/{objectId}
+// Retrieves a data object from the datastore
+// By default, the object is returned as a json-encoded data object with the
+// raw CBOR bytes; with ?format=json the object is decoded and returned as JSON,
+// with merkle links rendered as {"/": "Qm..."}.
+func (node *Node) httpGetData(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key58 := vars["objectId"]
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json":
+	default:
+		apiError(w, http.StatusBadRequest, BadFormat)
+		return
+	}
+
+	key, err := mc.ParseKey(key58)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := node.ds.Get(Key(key))
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if data == nil {
+		apiError(w, http.StatusNotFound, UnknownObject)
+		return
+	}
+
+	node.touchObject(Key(key))
+
+	if format == "json" {
+		val, err := mc.CBORToJSON(data)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(val)
+		if err != nil {
+			log.Printf("Error writing response body: %s", err.Error())
+		}
+		return
+	}
+
+	dao := DataObject{Data: data}
+	err = json.NewEncoder(w).Encode(dao)
+	if err != nil {
+		log.Printf("Error writing response body: %s", err.Error())
+	}
 }
 
-// POST /delete
-// DATA: MCQL DELTE query
-// Deletes statements from the statement db
-// Returns the number of statements deleted
-func (node *Node) httpDelete(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("http/query: Error reading request body: %s", err.Error())
+// POST /data/get
+// DATA: A stream of object ids, one per line
+// Retrieves a batch of objects from the datastore; missing objects are skipped.
+// By default, the objects are returned as a stream of json-encoded data objects
+// (with their key), with a final {"error": ...} line if the request fails
+// midway; with ?format=raw they are returned as a stream of length-delimited
+// pb.DataResults, terminated by a StreamEnd or a StreamError.
+func (node *Node) httpGetDataBatch(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "ndjson", "raw":
+	default:
+		apiError(w, http.StatusBadRequest, BadFormat)
 		return
 	}
 
-	q, err := mcq.ParseQuery(string(body))
+	// The key list is read in full before the response is written, as the
+	// request body can't be read once the response has started; the objects
+	// are retrieved and written in chunks, so that they don't have to fit
+	// in memory.
+	var keys []Key
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		key58 := strings.TrimSpace(scanner.Text())
+		if key58 == "" {
+			continue
+		}
+
+		key, err := mc.ParseKey(key58)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		keys = append(keys, Key(key))
+	}
+
+	err := scanner.Err()
 	if err != nil {
 		apiError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	if q.Op != mcq.OpDelete {
-		apiError(w, http.StatusBadRequest, BadQuery)
-		return
+	var writeData func(key Key, data []byte) error
+	var writeEnd func(err error) error
+	if format == "raw" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		pbw := ggio.NewDelimitedWriter(w)
+		writeData = func(key Key, data []byte) error {
+			obj := &pb.DataObject{multihash.Multihash(key).B58String(), data}
+			return pbw.WriteMsg(&pb.DataResult{&pb.DataResult_Data{obj}})
+		}
+		writeEnd = func(err error) error {
+			if err != nil {
+				return pbw.WriteMsg(&pb.DataResult{&pb.DataResult_Error{&pb.StreamError{err.Error()}}})
+			}
+			return pbw.WriteMsg(&pb.DataResult{&pb.DataResult_End{&pb.StreamEnd{}}})
+		}
+	} else {
+		enc := json.NewEncoder(w)
+		writeData = func(key Key, data []byte) error {
+			return enc.Encode(DataObject{multihash.Multihash(key).B58String(), data})
+		}
+		writeEnd = func(err error) error {
+			if err != nil {
+				return enc.Encode(StreamError{err.Error()})
+			}
+			return nil
+		}
 	}
 
-	count, err := node.db.Delete(q)
-	if err != nil {
-		apiError(w, http.StatusInternalServerError, err)
-		if count > 0 {
-			fmt.Fprintf(w, "Partial delete: %d statements deleted\n", count)
+	const batch = 1024
+	for len(keys) > 0 {
+		chunk := keys
+		if len(chunk) > batch {
+			chunk = chunk[:batch]
 		}
-		return
-	}
+		keys = keys[len(chunk):]
 
-	fmt.Fprintln(w, count)
-}
+		res, err := node.ds.GetBatch(chunk)
+		if err != nil {
+			log.Printf("Error retrieving data batch: %s", err.Error())
+			writeEnd(err)
+			return
+		}
 
-// datastore interface
-type DataObject struct {
-	Data []byte `json:"data"`
+		for x, data := range res {
+			if data == nil {
+				continue
+			}
+
+			node.touchObject(chunk[x])
+			err = writeData(chunk[x], data)
+			if err != nil {
+				log.Printf("Error writing response body: %s", err.Error())
+				return
+			}
+		}
+	}
+
+	err = writeEnd(nil)
+	if err != nil {
+		log.Printf("Error writing response body: %s", err.Error())
+	}
 }
 
-// Get /data/get/{objectId}
-// Retrieves a data object from the datastore
-func (node *Node) httpGetData(w http.ResponseWriter, r *http.Request) {
+// GET /data/resolve/{objectId}
+// Resolves the graph of objects reachable from objectId through merkle links.
+// The objects are returned as a stream of json-encoded data objects (with
+// their key), in breadth first order starting with the root object.
+// With ?depth=n links are only followed up to n levels; the default is to
+// resolve the entire graph. With ?format=json objects are decoded to JSON.
+// Linked objects that are missing from the datastore are skipped, as are
+// objects that can't be decoded with ?format=json.
+func (node *Node) httpResolveData(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key58 := vars["objectId"]
-	key, err := multihash.FromB58String(key58)
+	key, err := mc.ParseKey(key58)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	depth, err := apiDepthOption(r, -1)
 	if err != nil {
 		apiError(w, http.StatusBadRequest, err)
 		return
 	}
 
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json":
+	default:
+		apiError(w, http.StatusBadRequest, BadFormat)
+		return
+	}
+
 	data, err := node.ds.Get(Key(key))
 	if err != nil {
 		apiError(w, http.StatusInternalServerError, err)
@@ -588,10 +1347,88 @@ func (node *Node) httpGetData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dao := DataObject{data}
-	err = json.NewEncoder(w).Encode(dao)
-	if err != nil {
-		log.Printf("Error writing response body: %s", err.Error())
+	node.touchObject(Key(key))
+
+	if format == "json" {
+		_, err = mc.CBORToJSON(data)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	writeData := func(key58 string, data []byte) error {
+		if format == "json" {
+			val, err := mc.CBORToJSON(data)
+			if err != nil {
+				// linked objects that are not CBOR can't be rendered; skip them
+				return nil
+			}
+			return enc.Encode(JSONDataObject{key58, val})
+		}
+		return enc.Encode(DataObject{key58, data})
+	}
+
+	seen := map[string]bool{key58: true}
+	keys58 := []string{key58}
+	level := [][]byte{data}
+
+	const batch = 1024
+	for len(level) > 0 {
+		links := make([]Key, 0)
+		for x, data := range level {
+			err = writeData(keys58[x], data)
+			if err != nil {
+				log.Printf("Error writing response body: %s", err.Error())
+				return
+			}
+
+			if depth == 0 {
+				continue
+			}
+
+			xlinks, err := mc.CBORLinks(data)
+			if err != nil {
+				continue
+			}
+
+			for _, link := range xlinks {
+				link58 := link.B58String()
+				if !seen[link58] {
+					seen[link58] = true
+					links = append(links, Key(link))
+				}
+			}
+		}
+
+		if depth > 0 {
+			depth--
+		}
+
+		keys58 = keys58[:0]
+		level = level[:0]
+		for len(links) > 0 {
+			xkeys := links
+			if len(xkeys) > batch {
+				xkeys = xkeys[:batch]
+			}
+			links = links[len(xkeys):]
+
+			res, err := node.ds.GetBatch(xkeys)
+			if err != nil {
+				log.Printf("Error resolving objects: %s", err.Error())
+				return
+			}
+
+			for x, data := range res {
+				if data != nil {
+					node.touchObject(xkeys[x])
+					keys58 = append(keys58, multihash.Multihash(xkeys[x]).B58String())
+					level = append(level, data)
+				}
+			}
+		}
 	}
 }
 
@@ -599,14 +1436,54 @@ func (node *Node) httpGetData(w http.ResponseWriter, r *http.Request) {
 // DATA: A stream of json-encoded data objects
 // Puts a batch of objects to the datastore
 // returns a stream of object ids (B58 encoded content multihashes)
+// With ?format=json the body is a stream of JSON values, which are encoded
+// as canonical CBOR before being stored; merkle links can be written as
+// {"/": "Qm..."}.
+// With ?hash=name objects are hashed with a function other than sha2-256
+// (blake2b-256 or blake2b-512).
+// With ?cid=true the ids are returned as base32 CIDv1 with the dag-cbor codec.
 func (node *Node) httpPutData(w http.ResponseWriter, r *http.Request) {
+	code, err := apiHashOption(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cid := r.URL.Query().Get("cid") == "true"
+
+	var decodeData func(dec *json.Decoder) ([]byte, error)
+
+	switch r.URL.Query().Get("format") {
+	case "":
+		var dao DataObject
+		decodeData = func(dec *json.Decoder) ([]byte, error) {
+			dao.Data = nil
+			err := dec.Decode(&dao)
+			return dao.Data, err
+		}
+
+	case "json":
+		decodeData = func(dec *json.Decoder) ([]byte, error) {
+			var val interface{}
+			err := dec.Decode(&val)
+			if err != nil {
+				return nil, err
+			}
+			return mc.JSONToCBOR(val)
+		}
+
+	default:
+		apiError(w, http.StatusBadRequest, BadFormat)
+		return
+	}
+
 	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
 	batch := make([][]byte, 0, 1024)
 
-	var dao DataObject
 loop:
 	for {
-		err := dec.Decode(&dao)
+		data, err := decodeData(dec)
 		switch {
 		case err == io.EOF:
 			break loop
@@ -614,8 +1491,7 @@ loop:
 			apiError(w, http.StatusBadRequest, err)
 			return
 		default:
-			batch = append(batch, dao.Data)
-			dao.Data = nil
+			batch = append(batch, data)
 		}
 	}
 
@@ -628,23 +1504,51 @@ loop:
 	// a single error result or a stream of hashes.
 	// Writes are idempotent, so there is no deleterious effect from partial writes
 	// (other than a subset of the objects written in the datastore)
+	// The objects are marked as used before they are written, so that the
+	// client has a grace period to publish statements referencing objects
+	// that were already in the datastore without a reference.
 	keys := make([]Key, len(batch))
+	keys58 := make([]string, len(batch))
 	for x, data := range batch {
-		key, err := node.ds.Put(data)
+		key, err := mc.HashWith(data, code)
 		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		keys[x] = Key(key)
+		keys58[x] = key.B58String()
+	}
+
+	err = node.db.UseObjects(keys58)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	for x, data := range batch {
+		err := node.ds.PutKey(keys[x], data)
+		switch {
+		case err == QuotaExceeded:
+			apiError(w, http.StatusInsufficientStorage, err)
+			return
+		case err != nil:
 			apiError(w, http.StatusInternalServerError, err)
 			return
 		}
-		keys[x] = key
 	}
 
 	for _, key := range keys {
-		fmt.Fprintln(w, multihash.Multihash(key).B58String())
+		if cid {
+			fmt.Fprintln(w, mc.NewCIDv1(mc.CodecDagCBOR, multihash.Multihash(key)).String())
+		} else {
+			fmt.Fprintln(w, multihash.Multihash(key).B58String())
+		}
 	}
 }
 
 // POST /data/merge/{peerId}
 // Merges raw data objects from peerId
+// With ?depth=n linked objects are merged as in /merge.
 func (node *Node) httpMergeData(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	peerId := vars["peerId"]
@@ -655,18 +1559,24 @@ func (node *Node) httpMergeData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	keys := make(map[string]Key)
+	depth, err := apiDepthOption(r, 0)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	keys := makeMergeKeys()
 
 	scanner := bufio.NewScanner(r.Body)
 	for scanner.Scan() {
-		err = node.mergeObjectKey(scanner.Text(), keys)
+		err = node.mergeObjectKey(scanner.Text(), keys.keys)
 		if err != nil {
 			apiError(w, http.StatusBadRequest, err)
 			return
 		}
 	}
 
-	count, err := node.doRawMerge(r.Context(), pid, keys)
+	count, err := node.doRawMerge(r.Context(), pid, keys, depth)
 	if err != nil {
 		apiNetError(w, err)
 		if count > 0 {
@@ -680,20 +1590,78 @@ func (node *Node) httpMergeData(w http.ResponseWriter, r *http.Request) {
 
 // POST /data/gc
 // garbage collect orphan data objects that are not referenced by any statement
+// With ?namespace=ns only objects whose last reference was dropped by deleting
+// statements in ns (or a ns.* selector) are collected; unlike a full GC, this
+// can run while the node is online.
+// With ?dryrun=true the objects that would be deleted are listed with their
+// size, followed by the object count and total size.
+// With ?progress=true the number of statements scanned for the live set, and
+// then the number of keys scanned and deleted, is reported as the GC
+// progresses; errors after the first progress line are reported with a
+// final Error line.
+// Returns the number of objects deleted
 func (node *Node) httpGCData(w http.ResponseWriter, r *http.Request) {
+	var opts GCOptions
+
+	q := r.URL.Query()
+	opts.dryrun = q.Get("dryrun") == "true"
+	opts.namespace = q.Get("namespace")
+
+	if opts.namespace != "" && !nsselrx.Match([]byte(opts.namespace)) {
+		apiError(w, http.StatusBadRequest, BadNamespace)
+		return
+	}
+
+	flush := func() {
+		f, ok := w.(http.Flusher)
+		if ok {
+			f.Flush()
+		}
+	}
+
+	// once the response has started, errors are reported in the body
+	var started bool
+
+	if opts.dryrun {
+		opts.garbage = func(key Key, size int) {
+			started = true
+			fmt.Fprintf(w, "%s %d\n", multihash.Multihash(key).B58String(), size)
+		}
+	}
+
+	if q.Get("progress") == "true" {
+		opts.progress = func(scanned, deleted int) {
+			started = true
+			fmt.Fprintf(w, "Progress: %d keys scanned, %d deleted\n", scanned, deleted)
+			flush()
+		}
+		opts.liveProgress = func(scanned, live int) {
+			started = true
+			fmt.Fprintf(w, "Progress: %d statements scanned, %d live keys\n", scanned, live)
+			flush()
+		}
+	}
+
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	count, err := node.doGC(ctx)
+	count, size, err := node.doGC(ctx, opts)
 	if err != nil {
-		apiError(w, http.StatusInternalServerError, err)
-		if count > 0 {
+		if started {
+			fmt.Fprintf(w, "Error: %s\n", err.Error())
+		} else {
+			apiError(w, http.StatusInternalServerError, err)
+		}
+		if count > 0 && !opts.dryrun {
 			fmt.Fprintf(w, "Partial GC: %d objects deleted\n", count)
 		}
 		return
 	}
 
 	fmt.Fprintln(w, count)
+	if opts.dryrun {
+		fmt.Fprintln(w, size)
+	}
 }
 
 // POST /data/compact
@@ -717,19 +1685,156 @@ func (node *Node) httpSyncData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Fprintln(w, "OK")
+	fmt.Fprintln(w, "OK")
+}
+
+// GET /data/stats
+// Scans the datastore and returns object statistics in json: object count,
+// total and compressed (on disk) bytes, a power of 2 object size histogram,
+// and the number of references to the objects from statements.
+func (node *Node) httpDataStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := node.ds.Stats(r.Context())
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	stats.Referenced, stats.Refs, err = node.db.ObjectRefs()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		log.Printf("Error writing response body: %s", err.Error())
+	}
+}
+
+// GET /data/cache
+// Returns the objects cached from merges and the objects evicted from the
+// cache, by namespace.
+func (node *Node) httpDataCache(w http.ResponseWriter, r *http.Request) {
+	stats, err := node.db.CacheStats()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		log.Printf("Error writing response body: %s", err.Error())
+	}
+}
+
+func (node *Node) httpDataKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := node.ds.IterKeys(r.Context())
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	for key := range keys {
+		fmt.Fprintln(w, multihash.Multihash(key).B58String())
+	}
+}
+
+// POST /backup
+// DATA: backup directory
+// Takes an online snapshot of the node state (statement db, datastore and
+// configuration) in the backup directory, which must not exist or be empty.
+// The node identity keys are included with ?keys=true
+func (node *Node) httpBackup(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/backup: Error reading request body: %s", err.Error())
+		return
+	}
+
+	dir := strings.TrimSpace(string(body))
+	if !path.IsAbs(dir) {
+		apiError(w, http.StatusBadRequest, BackupPath)
+		return
+	}
+
+	keys := r.URL.Query().Get("keys") == "true"
+
+	err = node.doBackup(dir, keys)
+	switch err {
+	case nil:
+		fmt.Fprintln(w, "OK")
+	case BackupExists:
+		apiError(w, http.StatusBadRequest, err)
+	default:
+		apiError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// POST /export
+// DATA: MCQL SELECT query
+// Exports the statements matching the query, together with their metadata,
+// in a self-contained archive suitable for /import
+func (node *Node) httpExport(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/export: Error reading request body: %s", err.Error())
+		return
+	}
+
+	q, err := mcq.ParseQuery(string(body))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !q.IsSimpleSelect("*") {
+		apiError(w, http.StatusBadRequest, BadQuery)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	count, ocount, err := node.doExport(ctx, q, w)
+	if err != nil {
+		log.Printf("http/export: Error exporting archive: %s", err.Error())
+		return
+	}
+
+	log.Printf("http/export: exported %d statements and %d objects", count, ocount)
 }
 
-func (node *Node) httpDataKeys(w http.ResponseWriter, r *http.Request) {
-	keys, err := node.ds.IterKeys(r.Context())
+// POST /import
+// DATA: archive produced by /export
+// Imports the statements and objects in the archive into the local db,
+// verifying them as in a merge; returns the number of statements and objects
+// merged. Objects already in the datastore are not merged again.
+func (node *Node) httpImport(w http.ResponseWriter, r *http.Request) {
+	count, ocount, err := node.doImport(r.Body)
 	if err != nil {
-		apiError(w, http.StatusInternalServerError, err)
+		switch err {
+		case BadArchive, TruncatedArchive, BadStatement, BadData, UnexpectedData, MissingData:
+			apiError(w, http.StatusBadRequest, err)
+		case QuotaExceeded:
+			apiError(w, http.StatusInsufficientStorage, err)
+		default:
+			apiError(w, http.StatusInternalServerError, err)
+		}
+
+		if count > 0 {
+			fmt.Fprintf(w, "Partial import: %d statements merged\n", count)
+		}
+		if ocount > 0 {
+			fmt.Fprintf(w, "Partial import: %d objects merged\n", ocount)
+		}
+
 		return
 	}
 
-	for key := range keys {
-		fmt.Fprintln(w, multihash.Multihash(key).B58String())
-	}
+	fmt.Fprintln(w, count)
+	fmt.Fprintln(w, ocount)
 }
 
 // GET /status
@@ -761,7 +1866,11 @@ func (node *Node) httpStatusSet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err != nil {
+	switch {
+	case err == NodeLocked:
+		apiError(w, http.StatusLocked, err)
+		return
+	case err != nil:
 		apiError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -861,6 +1970,324 @@ func (node *Node) httpConfigNATSet(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "OK")
 }
 
+// GET  /config/compression
+// POST /config/compression
+// retrieve/set the datastore compression type (none, snappy, zlib, lz4, zstd);
+// takes effect when the node is restarted
+func (node *Node) httpConfigCompression(w http.ResponseWriter, r *http.Request) {
+	apiConfigMethod(w, r, node.httpConfigCompressionGet, node.httpConfigCompressionSet)
+}
+
+func (node *Node) httpConfigCompressionGet(w http.ResponseWriter, r *http.Request) {
+	if node.compress == "" {
+		fmt.Fprintln(w, "default")
+		return
+	}
+
+	fmt.Fprintln(w, node.compress)
+}
+
+func (node *Node) httpConfigCompressionSet(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/config/compression: Error reading request body: %s", err.Error())
+		return
+	}
+
+	opt := strings.TrimSpace(string(body))
+	err = checkCompression(opt)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	node.compress = opt
+
+	err = node.saveConfig()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
+
+// GET  /config/quota
+// POST /config/quota
+// retrieve/set the datastore quota, in bytes; 0 disables the quota.
+func (node *Node) httpConfigQuota(w http.ResponseWriter, r *http.Request) {
+	apiConfigMethod(w, r, node.httpConfigQuotaGet, node.httpConfigQuotaSet)
+}
+
+func (node *Node) httpConfigQuotaGet(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, node.quota)
+}
+
+func (node *Node) httpConfigQuotaSet(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/config/quota: Error reading request body: %s", err.Error())
+		return
+	}
+
+	quota, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if quota < 0 {
+		apiError(w, http.StatusBadRequest, BadQuota)
+		return
+	}
+
+	node.quota = quota
+	node.ds.SetQuota(quota)
+
+	err = node.saveConfig()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
+
+// GET  /config/eviction
+// POST /config/eviction
+// retrieve/set the eviction policy for merged objects: none or lru
+func (node *Node) httpConfigEviction(w http.ResponseWriter, r *http.Request) {
+	apiConfigMethod(w, r, node.httpConfigEvictionGet, node.httpConfigEvictionSet)
+}
+
+func (node *Node) httpConfigEvictionGet(w http.ResponseWriter, r *http.Request) {
+	if node.evict == "" {
+		fmt.Fprintln(w, EvictNone)
+		return
+	}
+
+	fmt.Fprintln(w, node.evict)
+}
+
+func (node *Node) httpConfigEvictionSet(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/config/eviction: Error reading request body: %s", err.Error())
+		return
+	}
+
+	opt := strings.TrimSpace(string(body))
+	err = checkEviction(opt)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	node.evict = opt
+
+	err = node.saveConfig()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
+
+// GET /config/schema
+// retrieves all namespace schemas in json
+func (node *Node) httpConfigSchemas(w http.ResponseWriter, r *http.Request) {
+	schemas := node.schemas.toJSON()
+
+	err := json.NewEncoder(w).Encode(schemas)
+	if err != nil {
+		log.Printf("Error writing response body: %s", err.Error())
+	}
+}
+
+// GET  /config/schema/{namespace}
+// POST /config/schema/{namespace}
+// retrieve/set the schema for validating objects published in namespace;
+// an empty body removes the schema.
+func (node *Node) httpConfigSchema(w http.ResponseWriter, r *http.Request) {
+	apiConfigMethod(w, r, node.httpConfigSchemaGet, node.httpConfigSchemaSet)
+}
+
+func (node *Node) httpConfigSchemaGet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ns := vars["namespace"]
+
+	src := node.schemas.getSchemaSource(ns)
+	if src == nil {
+		apiError(w, http.StatusNotFound, NoSchema)
+		return
+	}
+
+	w.Write(src)
+	fmt.Fprintln(w)
+}
+
+func (node *Node) httpConfigSchemaSet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ns := vars["namespace"]
+
+	if !nsrx.Match([]byte(ns)) {
+		apiError(w, http.StatusBadRequest, BadNamespace)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/config/schema: Error reading request body: %s", err.Error())
+		return
+	}
+
+	src := bytes.TrimSpace(body)
+	if len(src) == 0 {
+		node.schemas.clearSchema(ns)
+	} else {
+		schema, err := mc.ParseSchema(src)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		node.schemas.setSchema(ns, json.RawMessage(src), schema)
+	}
+
+	err = node.saveConfig()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
+
+// GET  /config/validation
+// POST /config/validation
+// retrieve/set the schema validation mode: publish or merge
+func (node *Node) httpConfigValidation(w http.ResponseWriter, r *http.Request) {
+	apiConfigMethod(w, r, node.httpConfigValidationGet, node.httpConfigValidationSet)
+}
+
+func (node *Node) httpConfigValidationGet(w http.ResponseWriter, r *http.Request) {
+	if node.validate == "" {
+		fmt.Fprintln(w, ValidatePublish)
+		return
+	}
+
+	fmt.Fprintln(w, node.validate)
+}
+
+func (node *Node) httpConfigValidationSet(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/config/validation: Error reading request body: %s", err.Error())
+		return
+	}
+
+	opt := strings.TrimSpace(string(body))
+	err = checkValidation(opt)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	node.validate = opt
+
+	err = node.saveConfig()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
+
+// GET  /config/instance
+// POST /config/instance
+// retrieve/set the instance name, for nodes sharing a publisher key;
+// the instance name is part of the ids of statements published by the node,
+// which keeps them unique among the nodes. An empty body clears the name.
+func (node *Node) httpConfigInstance(w http.ResponseWriter, r *http.Request) {
+	apiConfigMethod(w, r, node.httpConfigInstanceGet, node.httpConfigInstanceSet)
+}
+
+func (node *Node) httpConfigInstanceGet(w http.ResponseWriter, r *http.Request) {
+	if node.instance != "" {
+		fmt.Fprintln(w, node.instance)
+	}
+}
+
+func (node *Node) httpConfigInstanceSet(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/config/instance: Error reading request body: %s", err.Error())
+		return
+	}
+
+	instance := strings.TrimSpace(string(body))
+	if instance != "" {
+		err = checkInstance(instance)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	node.instance = instance
+
+	err = node.saveConfig()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
+
+// GET  /config/signature
+// POST /config/signature
+// retrieve/set the signature mode for published statements: proto or canonical
+func (node *Node) httpConfigSignature(w http.ResponseWriter, r *http.Request) {
+	apiConfigMethod(w, r, node.httpConfigSignatureGet, node.httpConfigSignatureSet)
+}
+
+func (node *Node) httpConfigSignatureGet(w http.ResponseWriter, r *http.Request) {
+	if node.sigmode == "" {
+		fmt.Fprintln(w, SigModeProto)
+		return
+	}
+
+	fmt.Fprintln(w, node.sigmode)
+}
+
+func (node *Node) httpConfigSignatureSet(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/config/signature: Error reading request body: %s", err.Error())
+		return
+	}
+
+	opt := strings.TrimSpace(string(body))
+	err = checkSigMode(opt)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	node.sigmode = opt
+
+	err = node.saveConfig()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
+
 // GET  /config/info
 // POST /config/info
 // retrieve/set node information
@@ -958,6 +2385,52 @@ func (node *Node) httpAuthPeerSet(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "OK")
 }
 
+// GET  /unlock
+// POST /unlock
+// DATA: key passphrase
+// Retrieves the lock state of the node (locked, unlocked); POST unlocks the
+// node keys with the passphrase.
+func (node *Node) httpUnlock(w http.ResponseWriter, r *http.Request) {
+	apiConfigMethod(w, r, node.httpUnlockGet, node.httpUnlockSet)
+}
+
+func (node *Node) httpUnlockGet(w http.ResponseWriter, r *http.Request) {
+	if node.isLocked() {
+		fmt.Fprintln(w, "locked")
+	} else {
+		fmt.Fprintln(w, "unlocked")
+	}
+}
+
+func (node *Node) httpUnlockSet(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("http/unlock: Error reading request body: %s", err.Error())
+		return
+	}
+
+	pass := bytes.TrimRight(body, "\r\n")
+	if len(pass) == 0 {
+		apiError(w, http.StatusBadRequest, NoPassphrase)
+		return
+	}
+
+	err = node.doUnlock(pass)
+	switch {
+	case err == mc.BadPassphrase:
+		apiError(w, http.StatusForbidden, err)
+		return
+	case err == NoEncryptedKeys:
+		apiError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
+
 // POST /shutdown
 // shutdown the node
 func (node *Node) httpShutdown(w http.ResponseWriter, r *http.Request) {
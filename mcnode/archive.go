@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	ggio "github.com/gogo/protobuf/io"
+	p2p_crypto "github.com/libp2p/go-libp2p-crypto"
+	mc "github.com/mediachain/concat/mc"
+	mcq "github.com/mediachain/concat/mc/query"
+	pb "github.com/mediachain/concat/proto"
+	"io"
+)
+
+var (
+	BadArchive       = errors.New("Bad archive; unexpected entry")
+	TruncatedArchive = errors.New("Truncated archive; missing end marker")
+)
+
+// Archives are streams of length-delimited ArchiveEntry messages.
+// Statements are written in chunks, each followed by the data objects
+// referenced by the chunk, and the archive is terminated by an end marker.
+// Objects may be repeated in multiple chunks, but every chunk is
+// self-contained so that it can be merged as soon as its data is imported.
+const archiveChunk = 1024
+
+// doExport writes an archive of the statements matching the query together
+// with their metadata to w; returns the number of statements and objects written.
+func (node *Node) doExport(ctx context.Context, q *mcq.Query, w io.Writer) (count int, ocount int, err error) {
+	ch, err := node.db.QueryStream(ctx, q)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	aw := ggio.NewDelimitedWriter(w)
+	var entry pb.ArchiveEntry
+
+	writeStatement := func(stmt *pb.Statement) error {
+		entry.Entry = &pb.ArchiveEntry_Stmt{stmt}
+		return aw.WriteMsg(&entry)
+	}
+
+	writeData := func(key string, data []byte) error {
+		entry.Entry = &pb.ArchiveEntry_Data{&pb.DataObject{key, data}}
+		return aw.WriteMsg(&entry)
+	}
+
+	writeEnd := func() error {
+		entry.Entry = &pb.ArchiveEntry_End{&pb.StreamEnd{}}
+		return aw.WriteMsg(&entry)
+	}
+
+	writeError := func(err error) {
+		entry.Entry = &pb.ArchiveEntry_Error{&pb.StreamError{err.Error()}}
+		aw.WriteMsg(&entry)
+	}
+
+	stmts := make([]*pb.Statement, 0, archiveChunk)
+	keys := make(map[string]Key)
+
+	writeChunk := func() error {
+		for _, stmt := range stmts {
+			err := writeStatement(stmt)
+			if err != nil {
+				return err
+			}
+		}
+
+		for key58, key := range keys {
+			data, err := node.ds.Get(key)
+			if err != nil {
+				return err
+			}
+
+			// the archive must be self-contained
+			if data == nil {
+				return MissingData
+			}
+
+			err = writeData(key58, data)
+			if err != nil {
+				return err
+			}
+			ocount++
+		}
+
+		count += len(stmts)
+		stmts = stmts[:0]
+		keys = make(map[string]Key)
+		return nil
+	}
+
+loop:
+	for val := range ch {
+		switch val := val.(type) {
+		case *pb.Statement:
+			err = node.mergeStatementKeys(val, keys)
+			if err != nil {
+				break loop
+			}
+
+			stmts = append(stmts, val)
+
+			if len(stmts) >= archiveChunk || len(keys) >= archiveChunk {
+				err = writeChunk()
+				if err != nil {
+					break loop
+				}
+			}
+
+		case StreamError:
+			err = val
+			break loop
+
+		default:
+			err = BadResult
+			break loop
+		}
+	}
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	if err == nil && len(stmts) > 0 {
+		err = writeChunk()
+	}
+
+	if err != nil {
+		writeError(err)
+		return count, ocount, err
+	}
+
+	err = writeEnd()
+	return count, ocount, err
+}
+
+// doImport merges the statements and objects of an archive produced by
+// doExport, verifying statement signatures and object hashes exactly as
+// in a network merge; returns the number of statements and objects merged.
+func (node *Node) doImport(r io.Reader) (count int, ocount int, err error) {
+	// publisher key cache
+	pkcache := make(map[string]p2p_crypto.PubKey)
+
+	ar := ggio.NewDelimitedReader(r, mc.MaxMessageSize)
+	var entry pb.ArchiveEntry
+
+	stmts := make([]*pb.Statement, 0, archiveChunk)
+	keys := make(map[string]Key)
+	data := false
+
+	mergeChunk := func() error {
+		missing, err := node.missingDataKeys(keys)
+		if err != nil {
+			return err
+		}
+
+		if len(missing) > 0 {
+			return MissingData
+		}
+
+		if len(stmts) > 0 {
+			xcount, err := node.db.MergeBatch(stmts)
+			count += xcount
+			if err != nil {
+				return err
+			}
+		}
+
+		stmts = make([]*pb.Statement, 0, archiveChunk)
+		keys = make(map[string]Key)
+		data = false
+		return nil
+	}
+
+	for {
+		err = ar.ReadMsg(&entry)
+		switch {
+		case err == io.EOF:
+			return count, ocount, TruncatedArchive
+		case err != nil:
+			return count, ocount, err
+		}
+
+		switch val := entry.Entry.(type) {
+		case *pb.ArchiveEntry_Stmt:
+			// statement after data: the previous chunk is complete
+			if data {
+				err = mergeChunk()
+				if err != nil {
+					return count, ocount, err
+				}
+			}
+
+			err = node.verifyMergeStatement(val.Stmt, pkcache)
+			if err != nil {
+				return count, ocount, err
+			}
+
+			err = node.mergeStatementKeys(val.Stmt, keys)
+			if err != nil {
+				return count, ocount, err
+			}
+
+			stmts = append(stmts, val.Stmt)
+
+		case *pb.ArchiveEntry_Data:
+			// the chunk's objects are marked as used before checking for
+			// existing objects, so that they are not swept before the
+			// chunk is merged
+			if !data {
+				err = node.useDataKeys(keys)
+				if err != nil {
+					return count, ocount, err
+				}
+				data = true
+			}
+
+			// objects already in the datastore are not imported again
+			have, err := node.haveDataObject(val.Data, keys)
+			if err != nil {
+				return count, ocount, err
+			}
+
+			if !have {
+				err = node.mergeDataObject(val.Data, keys)
+				if err != nil {
+					return count, ocount, err
+				}
+				ocount++
+			}
+
+		case *pb.ArchiveEntry_End:
+			err = mergeChunk()
+			return count, ocount, err
+
+		case *pb.ArchiveEntry_Error:
+			return count, ocount, StreamError{val.Error.Error}
+
+		default:
+			return count, ocount, BadArchive
+		}
+
+		entry.Reset()
+	}
+}
+
+// haveDataObject checks whether an expected data object is already in the
+// datastore, in which case it is no longer expected.
+func (node *Node) haveDataObject(obj *pb.DataObject, keys map[string]Key) (bool, error) {
+	key, ok := keys[obj.Key]
+	if !ok {
+		return false, UnexpectedData
+	}
+
+	have, err := node.ds.Has(key)
+	if err != nil || !have {
+		return false, err
+	}
+
+	delete(keys, obj.Key)
+	return true, nil
+}
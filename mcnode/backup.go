@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+)
+
+var (
+	BadBackup     = errors.New("Backup not supported")
+	BackupExists  = errors.New("Backup directory is not empty")
+	BackupPath    = errors.New("Backup directory must be an absolute path")
+	BackupMissing = errors.New("Not a node backup; missing statement db or datastore")
+	RestoreExists = errors.New("Node home already contains node state")
+)
+
+// Node state files copied verbatim in backups
+var backupConfigFiles = []string{"config.json"}
+var backupKeyFiles = []string{"identity.node", "identity.publisher"}
+var backupKeyDirs = []string{"publishers", "retired"}
+
+// doBackup takes an online snapshot of the node state in dir.
+// The backup directory has the same layout as the node home, so that
+// restoring is a matter of copying it back.
+// The statement db is backed up first, followed by the datastore checkpoint;
+// as data objects are written before the statements that reference them,
+// every statement in the snapshot has its metadata in the checkpoint.
+func (node *Node) doBackup(dir string, keys bool) error {
+	empty, err := isEmptyDir(dir)
+	if err != nil {
+		return err
+	}
+
+	if !empty {
+		return BackupExists
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Backing up node to %s", dir)
+
+	err = node.db.Backup(dir)
+	if err != nil {
+		return err
+	}
+
+	err = node.ds.Backup(dir)
+	if err != nil {
+		return err
+	}
+
+	files := backupConfigFiles
+	if keys {
+		files = append(files, backupKeyFiles...)
+	}
+
+	for _, file := range files {
+		err = copyFileIfExists(path.Join(node.home, file), path.Join(dir, file))
+		if err != nil {
+			return err
+		}
+	}
+
+	if keys {
+		for _, kdir := range backupKeyDirs {
+			err = copyDirIfExists(path.Join(node.home, kdir), path.Join(dir, kdir))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("Backup complete")
+	return nil
+}
+
+// doRestore restores node state from a backup created with doBackup.
+// The node home must not contain a statement db or a datastore, or any of
+// the config and key files in the backup.
+func doRestore(home string, dir string) error {
+	for _, file := range []string{"stmt", "data"} {
+		_, err := os.Stat(path.Join(dir, file))
+		switch {
+		case os.IsNotExist(err):
+			return BackupMissing
+		case err != nil:
+			return err
+		}
+
+		_, err = os.Stat(path.Join(home, file))
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return err
+		default:
+			return RestoreExists
+		}
+	}
+
+	// all the targets are checked before copying anything, so that a failed
+	// restore doesn't leave a half restored home behind
+	err := checkRestoreTargets(home, dir)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Restoring node from %s", dir)
+
+	err = copyDir(path.Join(dir, "stmt"), path.Join(home, "stmt"))
+	if err != nil {
+		return err
+	}
+
+	err = copyDir(path.Join(dir, "data"), path.Join(home, "data"))
+	if err != nil {
+		return err
+	}
+
+	files := append(backupConfigFiles, backupKeyFiles...)
+	for _, file := range files {
+		err = copyFileIfExists(path.Join(dir, file), path.Join(home, file))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, kdir := range backupKeyDirs {
+		err = copyDirIfExists(path.Join(dir, kdir), path.Join(home, kdir))
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Restore complete")
+	return nil
+}
+
+// checkRestoreTargets checks that none of the config and key files in the
+// backup exist in the node home.
+func checkRestoreTargets(home string, dir string) error {
+	files := append([]string{}, backupConfigFiles...)
+	files = append(files, backupKeyFiles...)
+
+	for _, kdir := range backupKeyDirs {
+		kfiles, err := ioutil.ReadDir(path.Join(dir, kdir))
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return err
+		}
+
+		for _, file := range kfiles {
+			if file.Mode().IsRegular() {
+				files = append(files, path.Join(kdir, file.Name()))
+			}
+		}
+	}
+
+	for _, file := range files {
+		_, err := os.Stat(path.Join(dir, file))
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return err
+		}
+
+		_, err = os.Stat(path.Join(home, file))
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return err
+		default:
+			return RestoreExists
+		}
+	}
+
+	return nil
+}
+
+func isEmptyDir(dir string) (bool, error) {
+	files, err := ioutil.ReadDir(dir)
+	switch {
+	case os.IsNotExist(err):
+		return true, nil
+	case err != nil:
+		return false, err
+	default:
+		return len(files) == 0, nil
+	}
+}
+
+// copyDir copies the regular files of a (flat) directory
+func copyDir(src, dest string) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dest, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+
+		err = copyFile(path.Join(src, file.Name()), path.Join(dest, file.Name()), file.Mode())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyDirIfExists(src, dest string) error {
+	_, err := os.Stat(src)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	default:
+		return copyDir(src, dest)
+	}
+}
+
+func copyFileIfExists(src, dest string) error {
+	info, err := os.Stat(src)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	default:
+		return copyFile(src, dest, info.Mode())
+	}
+}
+
+func copyFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	err = out.Sync()
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	multihash "github.com/multiformats/go-multihash"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	BadEviction = errors.New("Unknown eviction policy")
+	BadQuota    = errors.New("Bad quota; must be a non-negative number of bytes")
+)
+
+// Eviction policies for merged objects: with none, merges fail with
+// QuotaExceeded when the datastore quota is reached; with lru, the least
+// recently accessed merged objects are evicted to make room.
+// Objects published locally are never evicted.
+const (
+	EvictNone = "none"
+	EvictLRU  = "lru"
+)
+
+func checkEviction(policy string) error {
+	switch policy {
+	case EvictNone, EvictLRU:
+		return nil
+	default:
+		return BadEviction
+	}
+}
+
+// Eviction starts when the datastore usage exceeds EvictHighWater of the
+// quota, and evicts objects until the usage drops to EvictLowWater.
+// Evicted objects are accounted by their size, which overestimates their
+// disk usage when the datastore is compressed, so evictions err on the
+// side of freeing more space.
+const (
+	EvictPeriod    = time.Minute
+	EvictHighWater = 0.95
+	EvictLowWater  = 0.9
+	EvictBatch     = 1024
+)
+
+// accessLog collects object access times between cache updates
+type accessLog struct {
+	mx    sync.Mutex
+	times map[string]int64
+}
+
+func (al *accessLog) touch(key Key) {
+	key58 := multihash.Multihash(key).B58String()
+	now := time.Now().Unix()
+
+	al.mx.Lock()
+	if al.times == nil {
+		al.times = make(map[string]int64)
+	}
+	al.times[key58] = now
+	al.mx.Unlock()
+}
+
+func (al *accessLog) flush() map[string]int64 {
+	al.mx.Lock()
+	times := al.times
+	al.times = nil
+	al.mx.Unlock()
+	return times
+}
+
+// touchObject records an object access for LRU eviction
+func (node *Node) touchObject(key Key) {
+	if node.evict == EvictLRU {
+		node.access.touch(key)
+	}
+}
+
+func (node *Node) flushObjectAccess() error {
+	times := node.access.flush()
+	if len(times) == 0 {
+		return nil
+	}
+
+	return node.db.TouchObjects(times)
+}
+
+func (node *Node) evictObjects() {
+	for {
+		time.Sleep(EvictPeriod)
+
+		err := node.flushObjectAccess()
+		if err != nil {
+			log.Printf("Error updating object access times: %s", err.Error())
+		}
+
+		if node.evict != EvictLRU {
+			continue
+		}
+
+		count, size, err := node.doEvict()
+		if err != nil {
+			log.Printf("Error evicting objects: %s", err.Error())
+		}
+
+		if count > 0 {
+			log.Printf("Evicted %d objects (%d bytes)", count, size)
+		}
+	}
+}
+
+// doEvict evicts merged objects when the datastore usage is above the
+// high water mark, and compacts the datastore to reclaim their space (or
+// the space of objects evicted by merges); returns the number and size of
+// evicted objects.
+func (node *Node) doEvict() (count int, size int64, err error) {
+	node.evictmx.Lock()
+	defer node.evictmx.Unlock()
+
+	count, size, err = node.evictLRU()
+
+	// deleted objects only free space when their tables are compacted
+	if count > 0 || node.evictdirty {
+		node.ds.Compact()
+		node.evictdirty = false
+	}
+
+	return
+}
+
+// doEvictMerge evicts merged objects to make room for a merge; the evicted
+// size is released from the datastore usage estimate, and compaction is
+// left to the background evictor.
+func (node *Node) doEvictMerge() (count int, size int64, err error) {
+	node.evictmx.Lock()
+	defer node.evictmx.Unlock()
+
+	count, size, err = node.evictLRU()
+	if count > 0 {
+		node.ds.Release(size)
+		node.evictdirty = true
+	}
+
+	return
+}
+
+func (node *Node) evictLRU() (count int, size int64, err error) {
+	quota := node.quota
+	if quota <= 0 {
+		return
+	}
+
+	usage, err := node.ds.Usage()
+	if err != nil {
+		return
+	}
+
+	if usage <= int64(float64(quota)*EvictHighWater) {
+		return
+	}
+
+	err = node.flushObjectAccess()
+	if err != nil {
+		return
+	}
+
+	target := usage - int64(float64(quota)*EvictLowWater)
+	for size < target {
+		var xcount int
+		var xsize int64
+		xcount, xsize, err = node.db.EvictObjects(node.ds, target-size, EvictBatch)
+		count += xcount
+		size += xsize
+		if err != nil || xcount == 0 {
+			break
+		}
+	}
+
+	return
+}
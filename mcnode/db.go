@@ -5,26 +5,59 @@ import (
 	"database/sql"
 	ggproto "github.com/gogo/protobuf/proto"
 	sqlite3 "github.com/mattn/go-sqlite3"
+	mc "github.com/mediachain/concat/mc"
 	mcq "github.com/mediachain/concat/mc/query"
 	pb "github.com/mediachain/concat/proto"
+	"log"
 	"os"
 	"path"
 	"sync"
+	"time"
 )
 
 type SQLDB struct {
-	db                 *sql.DB
-	insertStmtData     *sql.Stmt
-	insertStmtEnvelope *sql.Stmt
-	insertStmtRefs     *sql.Stmt
-	selectStmtData     *sql.Stmt
-	deleteStmtData     *sql.Stmt
-	deleteStmtEnvelope *sql.Stmt
-	deleteStmtRefs     *sql.Stmt
-	wlock              sync.Mutex
+	db                  *sql.DB
+	insertStmtData      *sql.Stmt
+	insertStmtEnvelope  *sql.Stmt
+	insertStmtRefs      *sql.Stmt
+	selectStmtData      *sql.Stmt
+	deleteStmtData      *sql.Stmt
+	deleteStmtEnvelope  *sql.Stmt
+	deleteStmtRefs      *sql.Stmt
+	insertObjectRefs    *sql.Stmt
+	incrObjectRefs      *sql.Stmt
+	decrObjectRefs      *sql.Stmt
+	useObjectRefs       *sql.Stmt
+	selectObjectSweep   *sql.Stmt
+	selectObjectGarbage *sql.Stmt
+	deleteObjectRefs    *sql.Stmt
+	selectObjectDrop    *sql.Stmt
+	releaseObjectRefs   *sql.Stmt
+	insertObjectLink    *sql.Stmt
+	selectObjectLinks   *sql.Stmt
+	deleteObjectLinks   *sql.Stmt
+	insertCacheObject   *sql.Stmt
+	touchCacheObject    *sql.Stmt
+	deleteCacheObject   *sql.Stmt
+	selectCacheLRU      *sql.Stmt
+	insertEvictions     *sql.Stmt
+	updateEvictions     *sql.Stmt
+	insertDedupKey      *sql.Stmt
+	selectDedupKey      *sql.Stmt
+	deleteDedupKeys     *sql.Stmt
+	insertCounter       *sql.Stmt
+	selectCounter       *sql.Stmt
+	updateCounter       *sql.Stmt
+	wlock               sync.Mutex
 }
 
 func (sdb *SQLDB) Put(stmt *pb.Statement) error {
+	return sdb.put(stmt, true)
+}
+
+// put inserts a statement; locally published statements pin their objects,
+// so that they are not evicted from the datastore cache.
+func (sdb *SQLDB) put(stmt *pb.Statement, pin bool) error {
 	sdb.wlock.Lock()
 	defer sdb.wlock.Unlock()
 
@@ -61,51 +94,117 @@ func (sdb *SQLDB) Put(stmt *pb.Statement) error {
 		}
 	}
 
+	err = sdb.addObjectRefs(tx, stmt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if pin {
+		err = sdb.pinObjects(tx, stmt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
 
 func (sdb *SQLDB) PutBatch(stmts []*pb.Statement) error {
+	_, err := sdb.PutBatchDedup(stmts, nil)
+	return err
+}
+
+// PutBatchDedup inserts a batch of statements with dedup keys; a statement
+// whose key is already in the db for its namespace is not inserted, and the
+// id of the existing statement is returned in its place.
+// Statements with an empty key (or all statements if dkeys is nil) are
+// always inserted.
+func (sdb *SQLDB) PutBatchDedup(stmts []*pb.Statement, dkeys []string) ([]string, error) {
 	sdb.wlock.Lock()
 	defer sdb.wlock.Unlock()
 
 	tx, err := sdb.db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	insertData := tx.Stmt(sdb.insertStmtData)
 	insertEnvelope := tx.Stmt(sdb.insertStmtEnvelope)
 	insertRefs := tx.Stmt(sdb.insertStmtRefs)
+	insertDedup := tx.Stmt(sdb.insertDedupKey)
+	selectDedup := tx.Stmt(sdb.selectDedupKey)
+
+	ids := make([]string, len(stmts))
+	for x, stmt := range stmts {
+		ids[x] = stmt.Id
+
+		if dkeys != nil && dkeys[x] != "" {
+			var id string
+			err := selectDedup.QueryRow(stmt.Namespace, dkeys[x]).Scan(&id)
+			switch {
+			case err == nil:
+				ids[x] = id
+				continue
+
+			case err != sql.ErrNoRows:
+				tx.Rollback()
+				return nil, err
+			}
+
+			_, err = insertDedup.Exec(stmt.Namespace, dkeys[x], stmt.Id)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
 
-	for _, stmt := range stmts {
 		bytes, err := ggproto.Marshal(stmt)
 		if err != nil {
 			tx.Rollback()
-			return err
+			return nil, err
 		}
 
 		_, err = insertData.Exec(stmt.Id, bytes)
 		if err != nil {
 			tx.Rollback()
-			return err
+			return nil, err
 		}
 
 		_, err = insertEnvelope.Exec(stmt.Id, stmt.Namespace, stmt.Publisher, mcq.StatementSource(stmt), stmt.Timestamp)
 		if err != nil {
 			tx.Rollback()
-			return err
+			return nil, err
 		}
 
 		for _, wki := range mcq.StatementRefs(stmt) {
 			_, err = insertRefs.Exec(stmt.Id, wki)
 			if err != nil {
 				tx.Rollback()
-				return err
+				return nil, err
 			}
 		}
+
+		err = sdb.addObjectRefs(tx, stmt)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		err = sdb.pinObjects(tx, stmt)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
 
-	return tx.Commit()
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
 }
 
 func (sdb *SQLDB) Get(id string) (*pb.Statement, error) {
@@ -207,9 +306,57 @@ func (sdb *SQLDB) QueryOne(q *mcq.Query) (interface{}, error) {
 	return res, nil
 }
 
-func (sdb *SQLDB) Delete(q *mcq.Query) (count int, err error) {
+func (sdb *SQLDB) Delete(q *mcq.Query) (int, error) {
+	count, _, err := sdb.deleteImpl(q, nil)
+	return count, err
+}
+
+// DeleteCascade deletes statements together with the objects and deps that
+// are no longer referenced by any remaining statement; returns the number
+// of statements and objects deleted.
+func (sdb *SQLDB) DeleteCascade(q *mcq.Query, ds Datastore) (int, int, error) {
+	return sdb.deleteImpl(q, ds)
+}
+
+// DeleteIds deletes statements by id; unknown ids are skipped.
+// Returns the number of statements deleted.
+func (sdb *SQLDB) DeleteIds(ids []string) (count int, err error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	sdb.wlock.Lock()
+	defer sdb.wlock.Unlock()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		err = sdb.deleteStatement(tx, id, nil)
+		switch {
+		case err == sql.ErrNoRows:
+			continue
+		case err != nil:
+			tx.Rollback()
+			return 0, err
+		}
+
+		count += 1
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (sdb *SQLDB) deleteImpl(q *mcq.Query, ds Datastore) (count int, ocount int, err error) {
 	if q.Op != mcq.OpDelete {
-		return 0, BadQuery
+		return 0, 0, BadQuery
 	}
 
 	sdb.wlock.Lock()
@@ -220,57 +367,72 @@ func (sdb *SQLDB) Delete(q *mcq.Query) (count int, err error) {
 
 	ch, err := sdb.QueryStream(ctx, q)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
 	tx, err := sdb.db.Begin()
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	delData := tx.Stmt(sdb.deleteStmtData)
-	delEnvelope := tx.Stmt(sdb.deleteStmtEnvelope)
-	delRefs := tx.Stmt(sdb.deleteStmtRefs)
+	// keys referenced by deleted statements, for cascading deletes
+	var keys map[string]bool
+	if ds != nil {
+		keys = make(map[string]bool)
+	}
 
 	for val := range ch {
 		switch id := val.(type) {
 		case string:
-			_, err = delData.Exec(id)
-			if err != nil {
-				tx.Rollback()
-				return 0, err
-			}
-
-			_, err = delEnvelope.Exec(id)
-			if err != nil {
-				tx.Rollback()
-				return 0, err
-			}
-
-			_, err = delRefs.Exec(id)
+			err = sdb.deleteStatement(tx, id, keys)
 			if err != nil {
 				tx.Rollback()
-				return 0, err
+				return 0, 0, err
 			}
 
 			count += 1
 
 		case StreamError:
 			tx.Rollback()
-			return 0, id
+			return 0, 0, id
 
 		default:
 			tx.Rollback()
-			return 0, BadResult
+			return 0, 0, BadResult
+		}
+	}
+
+	var orphans []string
+	if ds != nil {
+		orphans, err = sdb.dropOrphanObjects(tx, keys)
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, err
 		}
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	return count, nil
+	// The objects are deleted after the commit (with the write lock held);
+	// if deleting fails, the remaining objects are orphaned and can be
+	// collected by an offline GC.
+	for _, key58 := range orphans {
+		key, err := mc.ParseKey(key58)
+		if err != nil {
+			continue
+		}
+
+		err = ds.Delete(Key(key))
+		if err != nil {
+			return count, ocount, err
+		}
+		ocount += 1
+	}
+
+	return count, ocount, nil
 }
 
 func (sdb *SQLDB) Close() error {
@@ -309,6 +471,80 @@ func (sdb *SQLDB) createTables() error {
 	}
 
 	_, err = sdb.db.Exec("CREATE INDEX RefsWki ON Refs (wki)")
+	if err != nil {
+		return err
+	}
+
+	err = sdb.createObjectTables()
+	if err != nil {
+		return err
+	}
+
+	err = sdb.createLinkTables()
+	if err != nil {
+		return err
+	}
+
+	err = sdb.createCacheTables()
+	if err != nil {
+		return err
+	}
+
+	err = sdb.createDedupTables()
+	if err != nil {
+		return err
+	}
+
+	return sdb.createCounterTables()
+}
+
+func (sdb *SQLDB) createObjectTables() error {
+	_, err := sdb.db.Exec("CREATE TABLE Objects (key VARCHAR(64) PRIMARY KEY, refs INTEGER, mtime INTEGER, namespace VARCHAR, used INTEGER DEFAULT 0)")
+	if err != nil {
+		return err
+	}
+
+	_, err = sdb.db.Exec("CREATE INDEX ObjectsRefs ON Objects (refs, mtime)")
+	return err
+}
+
+func (sdb *SQLDB) createLinkTables() error {
+	_, err := sdb.db.Exec("CREATE TABLE ObjectLinks (src VARCHAR(64), dst VARCHAR(64), PRIMARY KEY (src, dst))")
+	if err != nil {
+		return err
+	}
+
+	_, err = sdb.db.Exec("CREATE INDEX ObjectLinksDst ON ObjectLinks (dst)")
+	return err
+}
+
+func (sdb *SQLDB) createCacheTables() error {
+	_, err := sdb.db.Exec("CREATE TABLE Cache (key VARCHAR(64) PRIMARY KEY, namespace VARCHAR, size INTEGER, atime INTEGER)")
+	if err != nil {
+		return err
+	}
+
+	_, err = sdb.db.Exec("CREATE INDEX CacheAtime ON Cache (atime)")
+	if err != nil {
+		return err
+	}
+
+	_, err = sdb.db.Exec("CREATE TABLE Evictions (namespace VARCHAR PRIMARY KEY, count INTEGER, bytes INTEGER)")
+	return err
+}
+
+func (sdb *SQLDB) createDedupTables() error {
+	_, err := sdb.db.Exec("CREATE TABLE Dedup (namespace VARCHAR, key VARCHAR, id VARCHAR(128), PRIMARY KEY (namespace, key))")
+	if err != nil {
+		return err
+	}
+
+	_, err = sdb.db.Exec("CREATE INDEX DedupId ON Dedup (id)")
+	return err
+}
+
+func (sdb *SQLDB) createCounterTables() error {
+	_, err := sdb.db.Exec("CREATE TABLE Counters (name VARCHAR PRIMARY KEY, value INTEGER)")
 	return err
 }
 
@@ -355,95 +591,1223 @@ func (sdb *SQLDB) prepareStatements() error {
 	}
 	sdb.deleteStmtRefs = stmt
 
-	return nil
-}
+	stmt, err = sdb.db.Prepare("INSERT OR IGNORE INTO Objects VALUES (?, 0, ?, NULL, 0)")
+	if err != nil {
+		return err
+	}
+	sdb.insertObjectRefs = stmt
 
-// SQLite backend
-type SQLiteDB struct {
-	SQLDB
-}
+	stmt, err = sdb.db.Prepare("UPDATE Objects SET refs = refs + 1, mtime = ? WHERE key = ?")
+	if err != nil {
+		return err
+	}
+	sdb.incrObjectRefs = stmt
 
-func (sdb *SQLiteDB) Open(home string) error {
-	var dbpath string
-	var mktables bool
+	stmt, err = sdb.db.Prepare("UPDATE Objects SET refs = refs - 1, mtime = ?, namespace = ? WHERE key = ?")
+	if err != nil {
+		return err
+	}
+	sdb.decrObjectRefs = stmt
 
-	if home == ":memory:" { // allow testing
-		dbpath = home
-		mktables = true
-	} else {
-		dbdir := path.Join(home, "stmt")
-		err := os.MkdirAll(dbdir, 0755)
-		if err != nil {
-			return err
-		}
+	stmt, err = sdb.db.Prepare("UPDATE Objects SET used = ? WHERE key = ?")
+	if err != nil {
+		return err
+	}
+	sdb.useObjectRefs = stmt
 
-		dbpath = path.Join(dbdir, "stmt.db")
-		_, err = os.Stat(dbpath)
-		switch {
-		case os.IsNotExist(err):
-			mktables = true
-		case err != nil:
-			return err
-		}
+	stmt, err = sdb.db.Prepare("SELECT key FROM Objects WHERE refs <= 0 AND mtime < ? AND used < ? AND namespace GLOB ? AND NOT EXISTS (SELECT 1 FROM ObjectLinks WHERE dst = Objects.key) LIMIT ?")
+	if err != nil {
+		return err
 	}
+	sdb.selectObjectSweep = stmt
 
-	err := sdb.openDB(dbpath)
+	stmt, err = sdb.db.Prepare("SELECT key FROM Objects WHERE refs <= 0 AND used < ? AND namespace GLOB ? AND NOT EXISTS (SELECT 1 FROM ObjectLinks WHERE dst = Objects.key)")
 	if err != nil {
 		return err
 	}
+	sdb.selectObjectGarbage = stmt
 
-	if mktables {
-		err = sdb.createTables()
-		if err != nil {
-			return err
-		}
+	stmt, err = sdb.db.Prepare("DELETE FROM Objects WHERE key = ? AND refs <= 0 AND used < ? AND NOT EXISTS (SELECT 1 FROM ObjectLinks WHERE dst = Objects.key)")
+	if err != nil {
+		return err
+	}
+	sdb.deleteObjectRefs = stmt
 
-		err = sdb.tuneDB()
-		if err != nil {
-			return err
-		}
+	stmt, err = sdb.db.Prepare("SELECT mtime, IFNULL(namespace, '') FROM Objects WHERE key = ?")
+	if err != nil {
+		return err
 	}
+	sdb.selectObjectDrop = stmt
 
-	return sdb.prepareStatements()
-}
+	stmt, err = sdb.db.Prepare("UPDATE Objects SET mtime = ?, namespace = ? WHERE key = ? AND refs <= 0")
+	if err != nil {
+		return err
+	}
+	sdb.releaseObjectRefs = stmt
 
-func (sdb *SQLiteDB) openDB(dbpath string) error {
-	db, err := sql.Open("sqlite3", dbpath)
+	stmt, err = sdb.db.Prepare("INSERT OR IGNORE INTO ObjectLinks VALUES (?, ?)")
 	if err != nil {
 		return err
 	}
+	sdb.insertObjectLink = stmt
 
-	sdb.db = db
-	return nil
-}
+	stmt, err = sdb.db.Prepare("SELECT dst FROM ObjectLinks WHERE src = ?")
+	if err != nil {
+		return err
+	}
+	sdb.selectObjectLinks = stmt
 
-func (sdb *SQLiteDB) tuneDB() error {
-	_, err := sdb.db.Exec("PRAGMA journal_mode=WAL")
-	return err
-}
+	stmt, err = sdb.db.Prepare("DELETE FROM ObjectLinks WHERE src = ?")
+	if err != nil {
+		return err
+	}
+	sdb.deleteObjectLinks = stmt
 
-func (sdb *SQLiteDB) Merge(stmt *pb.Statement) (bool, error) {
-	err := sdb.Put(stmt)
+	stmt, err = sdb.db.Prepare("INSERT OR IGNORE INTO Cache VALUES (?, ?, ?, ?)")
 	if err != nil {
-		xerr, ok := err.(sqlite3.Error)
-		if ok && xerr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
-			return false, nil
-		}
-		return false, err
+		return err
 	}
-	return true, nil
-}
+	sdb.insertCacheObject = stmt
 
-func (sdb *SQLiteDB) MergeBatch(stmts []*pb.Statement) (count int, err error) {
-	sdb.wlock.Lock()
-	defer sdb.wlock.Unlock()
+	stmt, err = sdb.db.Prepare("UPDATE Cache SET atime = ? WHERE key = ?")
+	if err != nil {
+		return err
+	}
+	sdb.touchCacheObject = stmt
 
-	tx, err := sdb.db.Begin()
+	stmt, err = sdb.db.Prepare("DELETE FROM Cache WHERE key = ?")
 	if err != nil {
-		return 0, err
+		return err
 	}
+	sdb.deleteCacheObject = stmt
 
-	insertData := tx.Stmt(sdb.insertStmtData)
+	stmt, err = sdb.db.Prepare("SELECT key, namespace, size FROM Cache ORDER BY atime LIMIT ?")
+	if err != nil {
+		return err
+	}
+	sdb.selectCacheLRU = stmt
+
+	stmt, err = sdb.db.Prepare("INSERT OR IGNORE INTO Evictions VALUES (?, 0, 0)")
+	if err != nil {
+		return err
+	}
+	sdb.insertEvictions = stmt
+
+	stmt, err = sdb.db.Prepare("UPDATE Evictions SET count = count + ?, bytes = bytes + ? WHERE namespace = ?")
+	if err != nil {
+		return err
+	}
+	sdb.updateEvictions = stmt
+
+	stmt, err = sdb.db.Prepare("INSERT INTO Dedup VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	sdb.insertDedupKey = stmt
+
+	stmt, err = sdb.db.Prepare("SELECT id FROM Dedup WHERE namespace = ? AND key = ?")
+	if err != nil {
+		return err
+	}
+	sdb.selectDedupKey = stmt
+
+	stmt, err = sdb.db.Prepare("DELETE FROM Dedup WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	sdb.deleteDedupKeys = stmt
+
+	stmt, err = sdb.db.Prepare("INSERT OR IGNORE INTO Counters VALUES (?, 0)")
+	if err != nil {
+		return err
+	}
+	sdb.insertCounter = stmt
+
+	stmt, err = sdb.db.Prepare("SELECT value FROM Counters WHERE name = ?")
+	if err != nil {
+		return err
+	}
+	sdb.selectCounter = stmt
+
+	stmt, err = sdb.db.Prepare("UPDATE Counters SET value = value + ? WHERE name = ?")
+	if err != nil {
+		return err
+	}
+	sdb.updateCounter = stmt
+
+	return nil
+}
+
+// ReserveCounter reserves a block of n values from a persistent counter;
+// returns the first value in the block.
+func (sdb *SQLDB) ReserveCounter(name string, n int64) (int64, error) {
+	sdb.wlock.Lock()
+	defer sdb.wlock.Unlock()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Stmt(sdb.insertCounter).Exec(name)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	var start int64
+	err = tx.Stmt(sdb.selectCounter).QueryRow(name).Scan(&start)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.Stmt(sdb.updateCounter).Exec(n, name)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return start, nil
+}
+
+// Object reference counts: every statement holds a reference to each
+// distinct object and dep key in its body.
+// When a reference is dropped, the namespace of the statement is recorded
+// so that garbage can be collected per namespace.
+func (sdb *SQLDB) addObjectRefs(tx *sql.Tx, stmt *pb.Statement) error {
+	keys := make(map[string]bool)
+	err := addStatementKeys(stmt, keys)
+	if err != nil {
+		return err
+	}
+
+	insertObject := tx.Stmt(sdb.insertObjectRefs)
+	incrRefs := tx.Stmt(sdb.incrObjectRefs)
+	now := time.Now().Unix()
+
+	for key, _ := range keys {
+		_, err = insertObject.Exec(key, now)
+		if err != nil {
+			return err
+		}
+
+		_, err = incrRefs.Exec(now, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteStatement deletes a statement by id, dropping its object references;
+// the referenced keys are added to keys if it is not nil.
+// Returns sql.ErrNoRows if there is no such statement.
+func (sdb *SQLDB) deleteStatement(tx *sql.Tx, id string, keys map[string]bool) error {
+	var bytes []byte
+	err := tx.Stmt(sdb.selectStmtData).QueryRow(id).Scan(&bytes)
+	if err != nil {
+		return err
+	}
+
+	stmt := new(pb.Statement)
+	err = ggproto.Unmarshal(bytes, stmt)
+	if err != nil {
+		return err
+	}
+
+	err = sdb.dropObjectRefs(tx, stmt, keys)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Stmt(sdb.deleteStmtData).Exec(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Stmt(sdb.deleteStmtEnvelope).Exec(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Stmt(sdb.deleteStmtRefs).Exec(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Stmt(sdb.deleteDedupKeys).Exec(id)
+	return err
+}
+
+// dropObjectRefs drops the references of a statement; the dropped keys are
+// added to dropped if it is not nil.
+func (sdb *SQLDB) dropObjectRefs(tx *sql.Tx, stmt *pb.Statement, dropped map[string]bool) error {
+	keys := make(map[string]bool)
+	err := addStatementKeys(stmt, keys)
+	if err != nil {
+		return err
+	}
+
+	decrRefs := tx.Stmt(sdb.decrObjectRefs)
+	now := time.Now().Unix()
+
+	for key, _ := range keys {
+		_, err = decrRefs.Exec(now, stmt.Namespace, key)
+		if err != nil {
+			return err
+		}
+
+		if dropped != nil {
+			dropped[key] = true
+		}
+	}
+
+	return nil
+}
+
+// dropOrphanObjects removes the reference count of keys that are no
+// longer referenced; returns the orphaned keys.
+// Objects that have been used within the reuse grace period are not
+// orphaned; their references are left at 0 for the sweeper. Objects linked
+// from other objects are not orphaned either, until the objects linking to
+// them are; the links of orphans are dropped, so that the cascade follows
+// them.
+func (sdb *SQLDB) dropOrphanObjects(tx *sql.Tx, keys map[string]bool) ([]string, error) {
+	used := time.Now().Add(-GCReuseGrace).Unix()
+
+	queue := make([]string, 0, len(keys))
+	for key58, _ := range keys {
+		queue = append(queue, key58)
+	}
+
+	orphans := make([]string, 0, len(keys))
+	for len(queue) > 0 {
+		key58 := queue[0]
+		queue = queue[1:]
+
+		ok, links, err := sdb.dropObject(tx, key58, used)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			orphans = append(orphans, key58)
+			queue = append(queue, links...)
+		}
+	}
+
+	return orphans, nil
+}
+
+// dropObject removes the reference count of an unreferenced object that
+// has not been used since used, and is not linked from another object;
+// returns true if the object was dropped, together with the keys of the
+// objects it links to, which are released.
+func (sdb *SQLDB) dropObject(tx *sql.Tx, key58 string, used int64) (bool, []string, error) {
+	var mtime int64
+	var ns string
+	err := tx.Stmt(sdb.selectObjectDrop).QueryRow(key58).Scan(&mtime, &ns)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil, nil
+	case err != nil:
+		return false, nil, err
+	}
+
+	res, err := tx.Stmt(sdb.deleteObjectRefs).Exec(key58, used)
+	if err != nil {
+		return false, nil, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, nil, err
+	}
+
+	if rows == 0 {
+		return false, nil, nil
+	}
+
+	links, err := sdb.releaseObjectLinks(tx, key58, mtime, ns)
+	if err != nil {
+		return false, nil, err
+	}
+
+	return true, links, nil
+}
+
+// releaseObjectLinks drops the merkle links of an object that is deleted
+// from the datastore; the linked objects that are not referenced by any
+// statement are dropped as of mtime in ns, as if their last reference was
+// dropped with the object. Returns the keys of the linked objects.
+func (sdb *SQLDB) releaseObjectLinks(tx *sql.Tx, key58 string, mtime int64, ns string) ([]string, error) {
+	rows, err := tx.Stmt(sdb.selectObjectLinks).Query(key58)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := scanObjectKeys(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(links) == 0 {
+		return nil, nil
+	}
+
+	_, err = tx.Stmt(sdb.deleteObjectLinks).Exec(key58)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseObject := tx.Stmt(sdb.releaseObjectRefs)
+	for _, link := range links {
+		_, err = releaseObject.Exec(mtime, ns, link)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return links, nil
+}
+
+// LinkObjects records the merkle links of objects merged by following
+// links, by key; linked objects are not swept or deleted by cascading
+// deletes while an object linking to them is in the datastore.
+func (sdb *SQLDB) LinkObjects(links map[string][]string) error {
+	if len(links) == 0 {
+		return nil
+	}
+
+	sdb.wlock.Lock()
+	defer sdb.wlock.Unlock()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	insertLink := tx.Stmt(sdb.insertObjectLink)
+	for src, dsts := range links {
+		for _, dst := range dsts {
+			_, err = insertLink.Exec(src, dst)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PruneObjectLinks drops the links of objects that are no longer in the
+// datastore, after an offline GC.
+func (sdb *SQLDB) PruneObjectLinks(live func(key58 string) (bool, error)) error {
+	rows, err := sdb.db.Query("SELECT DISTINCT src FROM ObjectLinks")
+	if err != nil {
+		return err
+	}
+
+	srcs, err := scanObjectKeys(rows)
+	if err != nil {
+		return err
+	}
+
+	sdb.wlock.Lock()
+	defer sdb.wlock.Unlock()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	deleteLinks := tx.Stmt(sdb.deleteObjectLinks)
+	for _, src := range srcs {
+		ok, err := live(src)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if ok {
+			continue
+		}
+
+		_, err = deleteLinks.Exec(src)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseObjects marks objects as used, before they are put in the datastore
+// or checked for presence by a merge or publish that will reference them.
+// Used objects are not swept for GCReuseGrace, regardless of their
+// reference count; the write lock is held, so that an object is either
+// swept before it is marked or not swept until the grace period expires.
+func (sdb *SQLDB) UseObjects(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sdb.wlock.Lock()
+	defer sdb.wlock.Unlock()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	insertObject := tx.Stmt(sdb.insertObjectRefs)
+	useObject := tx.Stmt(sdb.useObjectRefs)
+	now := time.Now().Unix()
+
+	for _, key58 := range keys {
+		_, err = insertObject.Exec(key58, now)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = useObject.Exec(now, key58)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SweepObjects deletes up to limit objects whose reference count dropped to 0
+// more than grace ago, by deleting statements in namespaces matching the
+// ns selector (* or ns.* wildcards); returns the number of objects swept.
+// Objects used within GCReuseGrace and objects linked from other objects are
+// skipped; the objects linked from swept objects are released, and swept
+// in later rounds.
+// The write lock is held while deleting, so that no statement can reference
+// a swept object before it is removed from the datastore.
+func (sdb *SQLDB) SweepObjects(ds Datastore, ns string, grace time.Duration, limit int) (count int, err error) {
+	sdb.wlock.Lock()
+	defer sdb.wlock.Unlock()
+
+	now := time.Now()
+	used := now.Add(-GCReuseGrace).Unix()
+	rows, err := sdb.selectObjectSweep.Query(now.Add(-grace).Unix(), used, ns, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	keys, err := scanObjectKeys(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key58 := range keys {
+		// the links of swept objects are released, to be swept in turn
+		ok, _, err := sdb.dropObject(tx, key58, used)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		// malformed keys can't be in the datastore; just drop the reference
+		key, err := mc.ParseKey(key58)
+		if err == nil {
+			err = ds.Delete(Key(key))
+			if err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+		}
+
+		count += 1
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// ObjectRefs returns the number of referenced objects and the total number
+// of references to them
+func (sdb *SQLDB) ObjectRefs() (int, int64, error) {
+	row := sdb.db.QueryRow("SELECT COUNT(1), IFNULL(SUM(refs), 0) FROM Objects WHERE refs > 0")
+
+	var count int
+	var refs int64
+	err := row.Scan(&count, &refs)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return count, refs, nil
+}
+
+// UnreferencedObjects returns the keys of objects whose reference count
+// dropped to 0 by deleting statements in namespaces matching ns, and that
+// have not been used within GCReuseGrace
+func (sdb *SQLDB) UnreferencedObjects(ns string) ([]string, error) {
+	used := time.Now().Add(-GCReuseGrace).Unix()
+	rows, err := sdb.selectObjectGarbage.Query(used, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanObjectKeys(rows)
+}
+
+func scanObjectKeys(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key58 string
+		err := rows.Scan(&key58)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key58)
+	}
+
+	return keys, rows.Err()
+}
+
+// Datastore cache: objects fetched by merges are tracked in the Cache table,
+// with the namespace of the statements that referenced them, their size
+// and their last access time, so that they can be evicted when the datastore
+// quota is reached. Objects are pinned (removed from the cache) when a
+// local statement references them.
+type CacheObject struct {
+	Key       string
+	Namespace string
+	Size      int
+}
+
+type CacheStats struct {
+	Objects      int   `json:"objects"`
+	Bytes        int64 `json:"bytes"`
+	Evicted      int   `json:"evicted"`
+	EvictedBytes int64 `json:"evictedBytes"`
+}
+
+// CacheObjects adds merged objects to the cache; the write lock is held, so
+// that the cache doesn't race with pinning and eviction.
+func (sdb *SQLDB) CacheObjects(objs []CacheObject) error {
+	sdb.wlock.Lock()
+	defer sdb.wlock.Unlock()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	insertObject := tx.Stmt(sdb.insertCacheObject)
+	now := time.Now().Unix()
+
+	for _, obj := range objs {
+		_, err = insertObject.Exec(obj.Key, obj.Namespace, obj.Size, now)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// TouchObjects updates the access time of cached objects
+func (sdb *SQLDB) TouchObjects(atimes map[string]int64) error {
+	sdb.wlock.Lock()
+	defer sdb.wlock.Unlock()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	touchObject := tx.Stmt(sdb.touchCacheObject)
+	for key58, atime := range atimes {
+		_, err = touchObject.Exec(atime, key58)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// EvictObjects deletes up to limit of the least recently used cached objects
+// from the datastore, until at least target bytes have been evicted;
+// returns the number and size of evicted objects.
+// The write lock is held, so that objects can't be pinned while evicted.
+func (sdb *SQLDB) EvictObjects(ds Datastore, target int64, limit int) (count int, size int64, err error) {
+	sdb.wlock.Lock()
+	defer sdb.wlock.Unlock()
+
+	rows, err := sdb.selectCacheLRU.Query(limit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var objs []CacheObject
+	for rows.Next() {
+		var obj CacheObject
+		err = rows.Scan(&obj.Key, &obj.Namespace, &obj.Size)
+		if err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		objs = append(objs, obj)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(objs) == 0 {
+		return 0, 0, nil
+	}
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	deleteObject := tx.Stmt(sdb.deleteCacheObject)
+	evicted := make(map[string]*CacheStats)
+	now := time.Now().Unix()
+
+	for _, obj := range objs {
+		if size >= target {
+			break
+		}
+
+		key, err := mc.ParseKey(obj.Key)
+		if err == nil {
+			err = ds.Delete(Key(key))
+			if err != nil {
+				tx.Rollback()
+				return 0, 0, err
+			}
+		}
+
+		_, err = deleteObject.Exec(obj.Key)
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, err
+		}
+
+		_, err = sdb.releaseObjectLinks(tx, obj.Key, now, obj.Namespace)
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, err
+		}
+
+		stats, ok := evicted[obj.Namespace]
+		if !ok {
+			stats = new(CacheStats)
+			evicted[obj.Namespace] = stats
+		}
+		stats.Evicted += 1
+		stats.EvictedBytes += int64(obj.Size)
+
+		count += 1
+		size += int64(obj.Size)
+	}
+
+	insertEvictions := tx.Stmt(sdb.insertEvictions)
+	updateEvictions := tx.Stmt(sdb.updateEvictions)
+	for ns, stats := range evicted {
+		_, err = insertEvictions.Exec(ns)
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, err
+		}
+
+		_, err = updateEvictions.Exec(stats.Evicted, stats.EvictedBytes, ns)
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return count, size, nil
+}
+
+// CacheStats returns the cached and evicted objects by namespace
+func (sdb *SQLDB) CacheStats() (map[string]*CacheStats, error) {
+	res := make(map[string]*CacheStats)
+	getStats := func(ns string) *CacheStats {
+		stats, ok := res[ns]
+		if !ok {
+			stats = new(CacheStats)
+			res[ns] = stats
+		}
+		return stats
+	}
+
+	rows, err := sdb.db.Query("SELECT namespace, COUNT(1), SUM(size) FROM Cache GROUP BY namespace")
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var ns string
+		var count int
+		var bytes int64
+		err = rows.Scan(&ns, &count, &bytes)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		stats := getStats(ns)
+		stats.Objects = count
+		stats.Bytes = bytes
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = sdb.db.Query("SELECT namespace, count, bytes FROM Evictions")
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var ns string
+		var count int
+		var bytes int64
+		err = rows.Scan(&ns, &count, &bytes)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		stats := getStats(ns)
+		stats.Evicted = count
+		stats.EvictedBytes = bytes
+	}
+	rows.Close()
+
+	return res, rows.Err()
+}
+
+// pinObjects removes the objects referenced by a statement from the cache
+func (sdb *SQLDB) pinObjects(tx *sql.Tx, stmt *pb.Statement) error {
+	keys := make(map[string]bool)
+	err := addStatementKeys(stmt, keys)
+	if err != nil {
+		return err
+	}
+
+	deleteObject := tx.Stmt(sdb.deleteCacheObject)
+	for key, _ := range keys {
+		_, err = deleteObject.Exec(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateLinkTables creates the object link table in statement dbs that
+// predate it
+func (sdb *SQLDB) migrateLinkTables() error {
+	var count int
+	row := sdb.db.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = 'ObjectLinks'")
+	err := row.Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return sdb.createLinkTables()
+	}
+
+	return nil
+}
+
+// migrateCacheTables creates the cache tables in statement dbs that
+// predate them
+func (sdb *SQLDB) migrateCacheTables() error {
+	var count int
+	row := sdb.db.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = 'Cache'")
+	err := row.Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return sdb.createCacheTables()
+	}
+
+	return nil
+}
+
+// migrateCounterTables creates the persistent counter table in statement
+// dbs that predate it
+func (sdb *SQLDB) migrateCounterTables() error {
+	var count int
+	row := sdb.db.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = 'Counters'")
+	err := row.Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return sdb.createCounterTables()
+	}
+
+	return nil
+}
+
+// migrateDedupTables creates the dedup key table in statement dbs that
+// predate it
+func (sdb *SQLDB) migrateDedupTables() error {
+	var count int
+	row := sdb.db.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = 'Dedup'")
+	err := row.Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return sdb.createDedupTables()
+	}
+
+	return nil
+}
+
+// migrateObjectTables creates the object reference count table in
+// statement dbs that predate it; returns true if the reference counts
+// need to be computed (which is also the case if a previous migration
+// was interrupted).
+func (sdb *SQLDB) migrateObjectTables() (bool, error) {
+	var count int
+	row := sdb.db.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = 'Objects'")
+	err := row.Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	if count == 0 {
+		return true, sdb.createObjectTables()
+	}
+
+	err = sdb.migrateObjectUsed()
+	if err != nil {
+		return false, err
+	}
+
+	row = sdb.db.QueryRow("SELECT COUNT(1) FROM Objects")
+	err = row.Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count == 0, nil
+}
+
+// migrateObjectUsed adds the object use time to reference count tables
+// that predate it
+func (sdb *SQLDB) migrateObjectUsed() error {
+	rows, err := sdb.db.Query("PRAGMA table_info(Objects)")
+	if err != nil {
+		return err
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return err
+	}
+
+	// cid, name, type, notnull, default, pk
+	vals := make([]interface{}, len(cols))
+	for x := range vals {
+		vals[x] = new(interface{})
+	}
+
+	var used bool
+	for rows.Next() {
+		var name string
+		vals[1] = &name
+		err = rows.Scan(vals...)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		if name == "used" {
+			used = true
+		}
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	if !used {
+		_, err = sdb.db.Exec("ALTER TABLE Objects ADD COLUMN used INTEGER DEFAULT 0")
+	}
+
+	return err
+}
+
+// countObjectRefs populates the object reference count table from the
+// existing statements.
+func (sdb *SQLDB) countObjectRefs() error {
+	log.Printf("Migrating statement db: computing object reference counts")
+
+	rows, err := sdb.db.Query("SELECT data FROM Statement")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var bytes []byte
+		err = rows.Scan(&bytes)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		stmt := new(pb.Statement)
+		err = ggproto.Unmarshal(bytes, stmt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = sdb.addObjectRefs(tx, stmt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SQLite backend
+type SQLiteDB struct {
+	SQLDB
+	dbpath string
+}
+
+func (sdb *SQLiteDB) Open(home string) error {
+	var dbpath string
+	var mktables, migrate bool
+
+	if home == ":memory:" { // allow testing
+		dbpath = home
+		mktables = true
+	} else {
+		dbdir := path.Join(home, "stmt")
+		err := os.MkdirAll(dbdir, 0755)
+		if err != nil {
+			return err
+		}
+
+		dbpath = path.Join(dbdir, "stmt.db")
+		_, err = os.Stat(dbpath)
+		switch {
+		case os.IsNotExist(err):
+			mktables = true
+		case err != nil:
+			return err
+		}
+	}
+
+	err := sdb.openDB(dbpath)
+	if err != nil {
+		return err
+	}
+
+	if mktables {
+		err = sdb.createTables()
+		if err != nil {
+			return err
+		}
+
+		err = sdb.tuneDB()
+		if err != nil {
+			return err
+		}
+	} else {
+		migrate, err = sdb.migrateObjectTables()
+		if err != nil {
+			return err
+		}
+
+		err = sdb.migrateLinkTables()
+		if err != nil {
+			return err
+		}
+
+		err = sdb.migrateCacheTables()
+		if err != nil {
+			return err
+		}
+
+		err = sdb.migrateDedupTables()
+		if err != nil {
+			return err
+		}
+
+		err = sdb.migrateCounterTables()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = sdb.prepareStatements()
+	if err != nil {
+		return err
+	}
+
+	if migrate {
+		return sdb.countObjectRefs()
+	}
+
+	return nil
+}
+
+func (sdb *SQLiteDB) openDB(dbpath string) error {
+	db, err := sql.Open("sqlite3", dbpath)
+	if err != nil {
+		return err
+	}
+
+	sdb.db = db
+	sdb.dbpath = dbpath
+	return nil
+}
+
+func (sdb *SQLiteDB) tuneDB() error {
+	_, err := sdb.db.Exec("PRAGMA journal_mode=WAL")
+	return err
+}
+
+// Backup uses the SQLite online backup API to copy the db to dir/stmt/stmt.db
+// The copy is performed in a single step, which holds a read transaction
+// in the source db; the backup is a consistent snapshot, while concurrent
+// readers and writers are not blocked (thanks to WAL mode).
+func (sdb *SQLiteDB) Backup(dir string) error {
+	if sdb.dbpath == ":memory:" {
+		return BadBackup
+	}
+
+	dbdir := path.Join(dir, "stmt")
+	err := os.MkdirAll(dbdir, 0755)
+	if err != nil {
+		return err
+	}
+
+	driver := &sqlite3.SQLiteDriver{}
+	src, err := driver.Open(sdb.dbpath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dest, err := driver.Open(path.Join(dbdir, "stmt.db"))
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	bk, err := dest.(*sqlite3.SQLiteConn).Backup("main", src.(*sqlite3.SQLiteConn), "main")
+	if err != nil {
+		return err
+	}
+
+	_, err = bk.Step(-1)
+	if err != nil {
+		bk.Finish()
+		return err
+	}
+
+	return bk.Finish()
+}
+
+func (sdb *SQLiteDB) Merge(stmt *pb.Statement) (bool, error) {
+	err := sdb.put(stmt, false)
+	if err != nil {
+		xerr, ok := err.(sqlite3.Error)
+		if ok && xerr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (sdb *SQLiteDB) MergeBatch(stmts []*pb.Statement) (count int, err error) {
+	sdb.wlock.Lock()
+	defer sdb.wlock.Unlock()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	insertData := tx.Stmt(sdb.insertStmtData)
 	insertEnvelope := tx.Stmt(sdb.insertStmtEnvelope)
 	insertRefs := tx.Stmt(sdb.insertStmtRefs)
 
@@ -478,6 +1842,12 @@ func (sdb *SQLiteDB) MergeBatch(stmts []*pb.Statement) (count int, err error) {
 			}
 		}
 
+		err = sdb.addObjectRefs(tx, stmt)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
 		count += 1
 	}
 
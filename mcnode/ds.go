@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	mc "github.com/mediachain/concat/mc"
 	rocksdb "github.com/mediachain/gorocksdb"
 	"log"
@@ -9,13 +10,79 @@ import (
 	"path"
 	"runtime"
 	"strconv"
+	"sync"
+	"time"
 )
 
+var (
+	BadCompression = errors.New("Unknown compression type")
+	BadDSVersion   = errors.New("Unsupported datastore version")
+	QuotaExceeded  = errors.New("Datastore quota exceeded")
+)
+
+// Datastore key format: objects are keyed by their full multihash, so that
+// objects hashed with different functions can coexist.
+// Keys starting with a 0 byte are reserved for datastore metadata; this
+// can't clash with object keys, as the identity hash is not supported.
+// Version 0 datastores keyed objects by their raw sha2-256 digest, and are
+// migrated when opened.
+const dsVersion = 1
+
+var (
+	dsVersionKey = []byte("\x00version")
+	dsDataPrefix = []byte{1}
+)
+
+// datastore compression types; the rocksdb default is used when unset
+var compressionTypes = map[string]rocksdb.CompressionType{
+	"none":   rocksdb.NoCompression,
+	"snappy": rocksdb.SnappyCompression,
+	"zlib":   rocksdb.ZLibCompression,
+	"lz4":    rocksdb.LZ4Compression,
+	"zstd":   rocksdb.ZSTDCompression,
+}
+
+func checkCompression(compression string) error {
+	_, ok := compressionTypes[compression]
+	if !ok {
+		return BadCompression
+	}
+	return nil
+}
+
 type RocksDS struct {
-	db *rocksdb.DB
-	ro *rocksdb.ReadOptions
-	wo *rocksdb.WriteOptions
-	fo *rocksdb.FlushOptions
+	db          *rocksdb.DB
+	ro          *rocksdb.ReadOptions
+	wo          *rocksdb.WriteOptions
+	fo          *rocksdb.FlushOptions
+	compression string
+	quota       int64
+	usage       int64
+	released    int64
+	utime       time.Time
+	umx         sync.Mutex
+}
+
+// Datastore quota: the quota applies to the disk usage of the datastore,
+// which is estimated from the size of the rocksdb tables and memtables.
+// The estimate is refreshed periodically, and accounts for the objects
+// written in between.
+const dsUsageRefresh = time.Second
+
+type DataStats struct {
+	Objects     int               `json:"objects"`
+	Bytes       int64             `json:"bytes"`
+	Compressed  int64             `json:"compressedBytes"`
+	Compression string            `json:"compression"`
+	Histogram   []DataStatsBucket `json:"histogram"`
+	Referenced  int               `json:"referencedObjects"`
+	Refs        int64             `json:"refs"`
+}
+
+// object size histogram bucket, for sizes in (size/2, size]
+type DataStatsBucket struct {
+	Size  int `json:"size"`
+	Count int `json:"count"`
 }
 
 func (ds *RocksDS) Open(home string) error {
@@ -50,6 +117,17 @@ func (ds *RocksDS) Open(home string) error {
 	}
 	opts.OptimizeForPointLookup(uint64(bcmb))
 
+	// compression applies to newly written tables; existing data is
+	// recompressed as it gets compacted.
+	if ds.compression != "" {
+		ctype, ok := compressionTypes[ds.compression]
+		if !ok {
+			return BadCompression
+		}
+		log.Printf("Using %s compression for datastore", ds.compression)
+		opts.SetCompression(ctype)
+	}
+
 	db, err := rocksdb.OpenDb(opts, dbpath)
 	if err != nil {
 		return err
@@ -60,27 +138,165 @@ func (ds *RocksDS) Open(home string) error {
 	ds.wo = rocksdb.NewDefaultWriteOptions()
 	ds.fo = rocksdb.NewDefaultFlushOptions()
 
-	return nil
+	return ds.migrate()
+}
+
+func (ds *RocksDS) migrate() error {
+	val, err := ds.db.GetBytes(ds.ro, dsVersionKey)
+	if err != nil {
+		return err
+	}
+
+	if val != nil {
+		version, err := strconv.Atoi(string(val))
+		if err != nil || version > dsVersion {
+			return BadDSVersion
+		}
+		return nil
+	}
+
+	count, err := ds.migrateKeys()
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		log.Printf("Migrated %d datastore keys to multihash format", count)
+	}
+
+	return ds.db.Put(ds.wo, dsVersionKey, []byte(strconv.Itoa(dsVersion)))
+}
+
+// migrateKeys rewrites raw sha2-256 digest keys as multihash keys.
+// Every batch moves its objects atomically, so an interrupted migration
+// is resumed on the next open: migrated keys are longer than raw digests.
+func (ds *RocksDS) migrateKeys() (count int, err error) {
+	it := ds.db.NewIterator(ds.ro)
+	defer it.Close()
+
+	wb := rocksdb.NewWriteBatch()
+	defer wb.Destroy()
+
+	const batch = 1024
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		kslice := it.Key()
+		key := kslice.Data()
+		if len(key) != 32 {
+			kslice.Free()
+			continue
+		}
+
+		vslice := it.Value()
+		wb.Put(mc.HashFromBytes(key), vslice.Data())
+		wb.Delete(key)
+		vslice.Free()
+		kslice.Free()
+
+		count++
+		if wb.Count() >= 2*batch {
+			err = ds.db.Write(ds.wo, wb)
+			if err != nil {
+				return
+			}
+			wb.Clear()
+		}
+	}
+
+	err = it.Err()
+	if err != nil {
+		return
+	}
+
+	if wb.Count() > 0 {
+		err = ds.db.Write(ds.wo, wb)
+	}
+
+	return
 }
 
 func (ds *RocksDS) Put(data []byte) (Key, error) {
+	err := ds.checkQuota(len(data))
+	if err != nil {
+		return nil, err
+	}
+
 	key := mc.Hash(data)
-	err := ds.db.Put(ds.wo, key[2:], data)
+	err = ds.db.Put(ds.wo, key, data)
 	return Key(key), err
 }
 
+// PutHash stores an object, keyed with a supported hash function
+func (ds *RocksDS) PutHash(data []byte, code uint64) (Key, error) {
+	key, err := mc.HashWith(data, code)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ds.checkQuota(len(data))
+	if err != nil {
+		return nil, err
+	}
+
+	err = ds.db.Put(ds.wo, key, data)
+	return Key(key), err
+}
+
+// PutKey stores an object with a precomputed key; the caller must have
+// verified the key with mc.VerifyHash.
+func (ds *RocksDS) PutKey(key Key, data []byte) error {
+	err := ds.checkQuota(len(data))
+	if err != nil {
+		return err
+	}
+
+	return ds.db.Put(ds.wo, key, data)
+}
+
+// PutKeyBatch stores a batch of objects with precomputed keys in a single
+// write; the caller must have verified the keys with mc.VerifyHash.
+func (ds *RocksDS) PutKeyBatch(keys []Key, batch [][]byte) error {
+	size := 0
+	for _, data := range batch {
+		size += len(data)
+	}
+
+	err := ds.checkQuota(size)
+	if err != nil {
+		return err
+	}
+
+	wb := rocksdb.NewWriteBatch()
+	defer wb.Destroy()
+
+	for x, data := range batch {
+		wb.Put(keys[x], data)
+	}
+
+	return ds.db.Write(ds.wo, wb)
+}
+
 func (ds *RocksDS) PutBatch(batch [][]byte) ([]Key, error) {
+	size := 0
+	for _, data := range batch {
+		size += len(data)
+	}
+
+	err := ds.checkQuota(size)
+	if err != nil {
+		return nil, err
+	}
+
 	keys := make([]Key, len(batch))
 	wb := rocksdb.NewWriteBatch()
 	defer wb.Destroy()
 
 	for x, data := range batch {
 		key := mc.Hash(data)
-		wb.Put(key[2:], data)
+		wb.Put(key, data)
 		keys[x] = Key(key)
 	}
 
-	err := ds.db.Write(ds.wo, wb)
+	err = ds.db.Write(ds.wo, wb)
 	if err != nil {
 		return nil, err
 	}
@@ -92,7 +308,7 @@ func (ds *RocksDS) Has(key Key) (bool, error) {
 	// gorocksdb has no native key check, so we need to do a get
 	// small optimization: use Get instead of GetBytes to avoid the extra copy
 	// to byte slice when the data is present
-	val, err := ds.db.Get(ds.ro, key[2:])
+	val, err := ds.db.Get(ds.ro, key)
 	if err != nil {
 		return false, err
 	}
@@ -101,11 +317,37 @@ func (ds *RocksDS) Has(key Key) (bool, error) {
 }
 
 func (ds *RocksDS) Get(key Key) ([]byte, error) {
-	return ds.db.GetBytes(ds.ro, key[2:])
+	return ds.db.GetBytes(ds.ro, key)
+}
+
+// GetBatch retrieves a batch of objects with a single MultiGet;
+// missing objects are nil.
+func (ds *RocksDS) GetBatch(keys []Key) ([][]byte, error) {
+	rkeys := make([][]byte, len(keys))
+	for x, key := range keys {
+		rkeys[x] = key
+	}
+
+	vals, err := ds.db.MultiGet(ds.ro, rkeys...)
+	if err != nil {
+		return nil, err
+	}
+	defer vals.Destroy()
+
+	res := make([][]byte, len(keys))
+	for x, val := range vals {
+		if val.Exists() {
+			data := make([]byte, val.Size())
+			copy(data, val.Data())
+			res[x] = data
+		}
+	}
+
+	return res, nil
 }
 
 func (ds *RocksDS) Delete(key Key) error {
-	return ds.db.Delete(ds.wo, key[2:])
+	return ds.db.Delete(ds.wo, key)
 }
 
 func (ds *RocksDS) Sync() error {
@@ -126,9 +368,10 @@ func (ds *RocksDS) IterKeys(ctx context.Context) (<-chan Key, error) {
 		defer it.Close()
 
 	loop:
-		for it.SeekToFirst(); it.Valid(); it.Next() {
+		for it.Seek(dsDataPrefix); it.Valid(); it.Next() {
 			kslice := it.Key()
-			key := mc.HashFromBytes(kslice.Data())
+			key := make([]byte, kslice.Size())
+			copy(key, kslice.Data())
 			kslice.Free()
 			select {
 			case ch <- Key(key):
@@ -140,8 +383,171 @@ func (ds *RocksDS) IterKeys(ctx context.Context) (<-chan Key, error) {
 	return ch, nil
 }
 
+// Backup creates a checkpoint of the datastore in dir/data
+// The checkpoint hard links the immutable sst files when dir is in the same
+// filesystem as the node home, so it's cheap; the memtable is flushed first,
+// so that the checkpoint includes all writes up to this point.
+func (ds *RocksDS) Backup(dir string) error {
+	cp, err := ds.db.NewCheckpoint()
+	if err != nil {
+		return err
+	}
+	defer cp.Destroy()
+
+	return cp.CreateCheckpoint(path.Join(dir, "data"), 0)
+}
+
+// Stats scans the datastore and computes object size statistics.
+// Compressed bytes are the size of the datastore tables on disk.
+func (ds *RocksDS) Stats(ctx context.Context) (*DataStats, error) {
+	err := ds.Sync()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := new(DataStats)
+	stats.Compression = ds.compression
+	if stats.Compression == "" {
+		stats.Compression = "default"
+	}
+
+	buckets := make([]int, 64)
+	top := 0
+
+	it := ds.db.NewIterator(ds.ro)
+	defer it.Close()
+
+	for it.Seek(dsDataPrefix); it.Valid(); it.Next() {
+		err = ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+
+		vslice := it.Value()
+		size := vslice.Size()
+		vslice.Free()
+
+		stats.Objects += 1
+		stats.Bytes += int64(size)
+
+		x := 0
+		for (1 << uint(x)) < size {
+			x++
+		}
+		buckets[x]++
+		if x > top {
+			top = x
+		}
+	}
+
+	err = it.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.Objects > 0 {
+		stats.Histogram = make([]DataStatsBucket, 0, top+1)
+		for x := 0; x <= top; x++ {
+			if buckets[x] > 0 {
+				stats.Histogram = append(stats.Histogram, DataStatsBucket{1 << uint(x), buckets[x]})
+			}
+		}
+	}
+
+	stats.Compressed, err = ds.intProperty("rocksdb.total-sst-files-size")
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// SetQuota sets the datastore quota in bytes; 0 disables the quota
+func (ds *RocksDS) SetQuota(quota int64) {
+	ds.umx.Lock()
+	ds.quota = quota
+	ds.umx.Unlock()
+}
+
+// Release subtracts the size of deleted objects from the usage estimate;
+// the space is only reclaimed on disk when the datastore is compacted.
+func (ds *RocksDS) Release(size int64) {
+	ds.umx.Lock()
+	ds.released += size
+	ds.usage -= size
+	ds.umx.Unlock()
+}
+
+// Usage returns the current estimate of the datastore disk usage
+func (ds *RocksDS) Usage() (int64, error) {
+	ds.umx.Lock()
+	defer ds.umx.Unlock()
+
+	err := ds.refreshUsage()
+	return ds.usage, err
+}
+
+func (ds *RocksDS) checkQuota(size int) error {
+	ds.umx.Lock()
+	defer ds.umx.Unlock()
+
+	if ds.quota <= 0 {
+		return nil
+	}
+
+	if time.Since(ds.utime) > dsUsageRefresh {
+		err := ds.refreshUsage()
+		if err != nil {
+			return err
+		}
+	}
+
+	if ds.usage+int64(size) > ds.quota {
+		return QuotaExceeded
+	}
+
+	ds.usage += int64(size)
+	return nil
+}
+
+func (ds *RocksDS) refreshUsage() error {
+	sst, err := ds.intProperty("rocksdb.total-sst-files-size")
+	if err != nil {
+		return err
+	}
+
+	mem, err := ds.intProperty("rocksdb.cur-size-all-mem-tables")
+	if err != nil {
+		return err
+	}
+
+	ds.usage = sst + mem - ds.released
+	ds.utime = time.Now()
+	return nil
+}
+
+func (ds *RocksDS) intProperty(name string) (int64, error) {
+	prop := ds.db.GetProperty(name)
+	if prop == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(prop, 10, 64)
+}
+
+// Compact compacts the datastore, reclaiming the space of deleted objects;
+// the usage estimate is refreshed.
 func (ds *RocksDS) Compact() {
 	ds.db.CompactRange(rocksdb.Range{})
+
+	ds.umx.Lock()
+	ds.released = 0
+	err := ds.refreshUsage()
+	ds.umx.Unlock()
+
+	if err != nil {
+		log.Printf("Error refreshing datastore usage: %s", err.Error())
+	}
 }
 
 func (ds *RocksDS) Close() {
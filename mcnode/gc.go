@@ -5,33 +5,193 @@ import (
 	"database/sql"
 	"errors"
 	sqlite3 "github.com/mattn/go-sqlite3"
+	mc "github.com/mediachain/concat/mc"
 	mcq "github.com/mediachain/concat/mc/query"
 	pb "github.com/mediachain/concat/proto"
 	multihash "github.com/multiformats/go-multihash"
+	"log"
+	"time"
 )
 
 var (
 	NodeMustBeOffline = errors.New("Node must be offline")
 )
 
-func (node *Node) doGC(ctx context.Context) (int, error) {
+type GCOptions struct {
+	// report garbage objects without deleting them
+	dryrun bool
+	// only collect objects whose last reference was dropped from a namespace
+	namespace string
+	// called for every garbage object in dry runs
+	garbage func(key Key, size int)
+	// called periodically with the number of keys scanned and deleted
+	progress func(scanned, deleted int)
+	// called periodically with the number of statements scanned and live
+	// keys found while building the live set of a full GC
+	liveProgress func(scanned, live int)
+}
+
+// progress report interval, in scanned keys
+const GCProgressInterval = 10000
+
+// doGC collects unreferenced objects; returns the number of objects deleted
+// and, for dry runs, their total size.
+// A full GC scans the entire datastore and requires the node to be offline.
+// A namespace GC only considers objects whose reference count dropped to 0
+// by deleting statements in the namespace, and can run while the node is
+// online.
+func (node *Node) doGC(ctx context.Context, opts GCOptions) (int, int64, error) {
+	if opts.namespace != "" {
+		return node.doGCNamespace(ctx, opts)
+	}
+
 	if node.status != StatusOffline {
-		return 0, NodeMustBeOffline
+		return 0, 0, NodeMustBeOffline
 	}
 
 	gc := &GCDB{}
 	err := gc.Open(node.home)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	defer gc.Close()
 
-	err = gc.Merge(ctx, node.db)
+	err = gc.Merge(ctx, node.db, node.ds, opts.liveProgress)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	return gc.GC(ctx, node.ds)
+	count, size, err := gc.GC(ctx, node.ds, opts)
+	if err != nil || opts.dryrun {
+		return count, size, err
+	}
+
+	// the links of collected objects no longer protect the objects they
+	// link to from online GC
+	err = node.db.PruneObjectLinks(func(key58 string) (bool, error) {
+		mhash, err := mc.ParseKey(key58)
+		if err != nil {
+			return false, nil
+		}
+		return gc.validKey(Key(mhash))
+	})
+
+	return count, size, err
+}
+
+func (node *Node) doGCNamespace(ctx context.Context, opts GCOptions) (count int, size int64, err error) {
+	if !opts.dryrun {
+		for {
+			var xcount int
+			xcount, err = node.db.SweepObjects(node.ds, opts.namespace, 0, GCSweepBatch)
+			count += xcount
+			if opts.progress != nil {
+				opts.progress(count, count)
+			}
+
+			// sweeping releases linked objects, so sweep until there is
+			// nothing left
+			if err != nil || xcount == 0 {
+				return
+			}
+
+			err = ctx.Err()
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	keys, err := node.db.UnreferencedObjects(opts.namespace)
+	if err != nil {
+		return
+	}
+
+	for x, key58 := range keys {
+		err = ctx.Err()
+		if err != nil {
+			return
+		}
+
+		if opts.progress != nil && x > 0 && x%GCProgressInterval == 0 {
+			opts.progress(x, count)
+		}
+
+		mhash, xerr := mc.ParseKey(key58)
+		if xerr != nil {
+			continue
+		}
+
+		key := Key(mhash)
+		var data []byte
+		data, err = node.ds.Get(key)
+		if err != nil {
+			return
+		}
+
+		if data == nil {
+			continue
+		}
+
+		count += 1
+		size += int64(len(data))
+		if opts.garbage != nil {
+			opts.garbage(key, len(data))
+		}
+	}
+
+	if opts.progress != nil {
+		opts.progress(len(keys), count)
+	}
+
+	return
+}
+
+// Online GC: the statement db keeps reference counts for objects, and
+// the sweeper periodically deletes objects whose references have dropped to 0.
+// Objects are only swept after a grace period from the time their last
+// reference was dropped.
+// A merge or publish that reuses an object marks it as used (with
+// UseObjects) before it puts the object or checks that it is present; used
+// objects are not swept or deleted by cascading deletes for GCReuseGrace,
+// which bounds the time between the check and the statement write.
+// Objects merged by following merkle links are tracked by their links: they
+// are not swept while an object linking to them is in the datastore, and
+// they are dropped, in the namespace of the linking object, when that object
+// is swept, deleted by a cascading delete or evicted.
+// Objects that were never referenced by a statement or merged through a link
+// are not tracked, and can only be collected by an offline GC.
+const (
+	GCSweepPeriod = 10 * time.Minute
+	GCSweepGrace  = time.Hour
+	GCReuseGrace  = time.Hour
+	GCSweepBatch  = 1024
+)
+
+func (node *Node) sweepObjects() {
+	for {
+		time.Sleep(GCSweepPeriod)
+
+		count, err := node.doSweep()
+		if err != nil {
+			log.Printf("Error sweeping objects: %s", err.Error())
+		}
+
+		if count > 0 {
+			log.Printf("Swept %d unreferenced objects", count)
+		}
+	}
+}
+
+func (node *Node) doSweep() (count int, err error) {
+	for {
+		var xcount int
+		xcount, err = node.db.SweepObjects(node.ds, "*", GCSweepGrace, GCSweepBatch)
+		count += xcount
+		if err != nil || xcount == 0 {
+			return
+		}
+	}
 }
 
 func (node *Node) doCompact() error {
@@ -76,7 +236,12 @@ func (gc *GCDB) Close() error {
 	return gc.db.Close()
 }
 
-func (gc *GCDB) Merge(ctx context.Context, db StatementDB) error {
+// Merge builds the live key set from the statements in db; the merkle links
+// of live objects are followed, so that objects merged through links are
+// live as long as an object referenced by a statement links to them.
+// If progress is not nil, it is called periodically with the number of
+// statements scanned and live keys found.
+func (gc *GCDB) Merge(ctx context.Context, db StatementDB, ds Datastore, progress func(scanned, live int)) error {
 	q, err := mcq.ParseQuery("SELECT * FROM *")
 	if err != nil {
 		return err
@@ -89,19 +254,26 @@ func (gc *GCDB) Merge(ctx context.Context, db StatementDB) error {
 
 	const batch = 1024
 	keys := make(map[string]bool)
+	var scanned, live int
 
 	for val := range ch {
 		switch val := val.(type) {
 		case *pb.Statement:
 			gc.addKeys(val, keys)
 			if len(keys) >= batch {
-				err := gc.mergeKeys(keys)
+				count, err := gc.mergeLiveKeys(ctx, ds, keys)
 				if err != nil {
 					return err
 				}
+				live += count
 				keys = make(map[string]bool)
 			}
 
+			scanned += 1
+			if progress != nil && scanned%GCProgressInterval == 0 {
+				progress(scanned, live)
+			}
+
 		case StreamError:
 			return val
 
@@ -111,55 +283,136 @@ func (gc *GCDB) Merge(ctx context.Context, db StatementDB) error {
 	}
 
 	if len(keys) > 0 {
-		return gc.mergeKeys(keys)
+		count, err := gc.mergeLiveKeys(ctx, ds, keys)
+		if err != nil {
+			return err
+		}
+		live += count
+	}
+
+	if progress != nil {
+		progress(scanned, live)
+	}
+
+	return nil
+}
+
+// mergeLiveKeys adds keys to the live set, together with the objects
+// reachable from them through merkle links; returns the number of keys
+// added to the set.
+func (gc *GCDB) mergeLiveKeys(ctx context.Context, ds Datastore, keys map[string]bool) (count int, err error) {
+	for len(keys) > 0 {
+		var added []string
+		added, err = gc.mergeKeys(keys)
+		if err != nil {
+			return
+		}
+		count += len(added)
+
+		err = ctx.Err()
+		if err != nil {
+			return
+		}
+
+		// only follow links from keys new to the set, as the links of the
+		// rest have already been followed
+		keys = make(map[string]bool)
+		for _, key58 := range added {
+			err = gc.addLinkKeys(ds, key58, keys)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// addLinkKeys collects the keys of the merkle links of an object; objects
+// that are missing or not valid CBOR have no links.
+func (gc *GCDB) addLinkKeys(ds Datastore, key58 string, keys map[string]bool) error {
+	mhash, err := mc.ParseKey(key58)
+	if err != nil {
+		return nil
+	}
+
+	data, err := ds.Get(Key(mhash))
+	if err != nil {
+		return err
+	}
+
+	if data == nil {
+		return nil
+	}
+
+	links, err := mc.CBORLinks(data)
+	if err != nil {
+		return nil
+	}
+
+	for _, link := range links {
+		keys[link.B58String()] = true
 	}
 
 	return nil
 }
 
 func (gc *GCDB) addKeys(stmt *pb.Statement, keys map[string]bool) error {
+	return addStatementKeys(stmt, keys)
+}
+
+// addStatementKeys collects the object and dep keys referenced by a statement
+func addStatementKeys(stmt *pb.Statement, keys map[string]bool) error {
 	switch body := stmt.Body.Body.(type) {
 	case *pb.StatementBody_Simple:
-		gc.addSimpleKeys(body.Simple, keys)
+		addSimpleStatementKeys(body.Simple, keys)
 		return nil
 
 	case *pb.StatementBody_Compound:
 		ss := body.Compound.Body
 		for _, s := range ss {
-			gc.addSimpleKeys(s, keys)
+			addSimpleStatementKeys(s, keys)
 		}
 		return nil
 
 	case *pb.StatementBody_Envelope:
 		stmts := body.Envelope.Body
 		for _, stmt := range stmts {
-			err := gc.addKeys(stmt, keys)
+			err := addStatementKeys(stmt, keys)
 			if err != nil {
 				return err
 			}
 		}
 		return nil
 
+	case *pb.StatementBody_Succession:
+		return nil
+
 	default:
 		return BadStatementBody
 	}
 }
 
-func (gc *GCDB) addSimpleKeys(s *pb.SimpleStatement, keys map[string]bool) {
-	keys[s.Object] = true
+// keys are normalized to b58 multihashes, so that objects referenced by
+// CID are counted together with their legacy references.
+func addSimpleStatementKeys(s *pb.SimpleStatement, keys map[string]bool) {
+	keys[mc.CanonicalKey(s.Object)] = true
 	for _, dep := range s.Deps {
-		keys[dep] = true
+		keys[mc.CanonicalKey(dep)] = true
 	}
 }
 
-func (gc *GCDB) mergeKeys(keys map[string]bool) error {
+// mergeKeys adds keys to the live set; returns the keys that were not
+// already in the set.
+func (gc *GCDB) mergeKeys(keys map[string]bool) ([]string, error) {
 	tx, err := gc.db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	insertKey := tx.Stmt(gc.insertKey)
 
+	added := make([]string, 0, len(keys))
 	for key, _ := range keys {
 		_, err := insertKey.Exec(key)
 		if err != nil {
@@ -168,20 +421,32 @@ func (gc *GCDB) mergeKeys(keys map[string]bool) error {
 				continue
 			}
 			tx.Rollback()
-			return err
+			return nil, err
 		}
+		added = append(added, key)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
 	}
 
-	return tx.Commit()
+	return added, nil
 }
 
-func (gc *GCDB) GC(ctx context.Context, ds Datastore) (count int, err error) {
+func (gc *GCDB) GC(ctx context.Context, ds Datastore, opts GCOptions) (count int, size int64, err error) {
 	keys, err := ds.IterKeys(ctx)
 	if err != nil {
 		return
 	}
 
+	var scanned int
 	for key := range keys {
+		scanned += 1
+		if opts.progress != nil && scanned%GCProgressInterval == 0 {
+			opts.progress(scanned, count)
+		}
+
 		var valid bool
 		valid, err = gc.validKey(key)
 		if err != nil {
@@ -192,6 +457,22 @@ func (gc *GCDB) GC(ctx context.Context, ds Datastore) (count int, err error) {
 			continue
 		}
 
+		if opts.dryrun {
+			var data []byte
+			data, err = ds.Get(key)
+			if err != nil {
+				return
+			}
+
+			size += int64(len(data))
+			if opts.garbage != nil {
+				opts.garbage(key, len(data))
+			}
+
+			count += 1
+			continue
+		}
+
 		err = ds.Delete(key)
 		if err != nil {
 			return
@@ -199,7 +480,17 @@ func (gc *GCDB) GC(ctx context.Context, ds Datastore) (count int, err error) {
 		count += 1
 	}
 
-	if count > 0 {
+	// the key iterator stops early if the context is cancelled
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+
+	if opts.progress != nil {
+		opts.progress(scanned, count)
+	}
+
+	if count > 0 && !opts.dryrun {
 		ds.Compact()
 	}
 
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	mc "github.com/mediachain/concat/mc"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var BadKeyCommand = errors.New("Usage: key show|export|import|generate [options ...] [key]")
+
+// Key commands: mcnode key show|export|import|generate manage the node keys
+// with the node offline.
+// Keys are named node for the peer key, publisher (or default) for the
+// default publisher key, and by name for named publisher keys; keys are
+// exported and imported as armored keys.
+// New node keys are generated with the key type of the -peer-key flag.
+func doKeyCommand(home string, peerKey string, pass []byte, args []string) error {
+	if len(args) == 0 {
+		return BadKeyCommand
+	}
+
+	cmd, args := args[0], args[1:]
+	flags := flag.NewFlagSet("key "+cmd, flag.ContinueOnError)
+	var file *string
+	switch cmd {
+	case "show", "import":
+		file = flags.String("in", "", "Read an armored key from a file; - for stdin")
+	case "export":
+		file = flags.String("out", "", "Write the armored key to a file instead of stdout")
+	case "generate":
+		file = new(string)
+	default:
+		return BadKeyCommand
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	switch {
+	case cmd == "show" && len(args) == 0:
+		return keyShowAll(home, *file)
+	case len(args) != 1:
+		return BadKeyCommand
+	}
+
+	name := args[0]
+	ktype, kpath, err := keyPath(home, name)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "show":
+		return keyShow(name, ktype, kpath)
+	case "export":
+		return keyExport(ktype, kpath, *file)
+	case "import":
+		return keyImport(home, pass, name, ktype, kpath, *file)
+	default:
+		return keyGenerate(home, peerKey, pass, name, ktype, kpath)
+	}
+}
+
+func keyPath(home string, name string) (ktype string, kpath string, err error) {
+	switch name {
+	case "node":
+		return mc.NodeKeyType, path.Join(home, "identity.node"), nil
+	case "publisher", DefaultPublisher:
+		return mc.PublisherKeyType, path.Join(home, "identity.publisher"), nil
+	default:
+		kpath, err = mc.NamedPublisherKeyPath(path.Join(home, "publishers"), name)
+		return mc.PublisherKeyType, kpath, err
+	}
+}
+
+func keyShowAll(home string, in string) error {
+	if in != "" {
+		armor, err := readKeyInput(in)
+		if err != nil {
+			return err
+		}
+
+		info, _, err := mc.ParseArmoredKey(armor)
+		if err != nil {
+			return err
+		}
+
+		printKeyInfo(info.Type, info)
+		return nil
+	}
+
+	names := []string{"node", "publisher"}
+	files, err := ioutil.ReadDir(path.Join(home, "publishers"))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		var pubs []string
+		for _, file := range files {
+			if file.Mode().IsRegular() && strings.HasSuffix(file.Name(), ".publisher") {
+				pubs = append(pubs, strings.TrimSuffix(file.Name(), ".publisher"))
+			}
+		}
+		sort.Strings(pubs)
+		names = append(names, pubs...)
+	}
+
+	for _, name := range names {
+		ktype, kpath, err := keyPath(home, name)
+		if err != nil {
+			return err
+		}
+
+		err = keyShow(name, ktype, kpath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func keyShow(name, ktype, kpath string) error {
+	info, err := mc.KeyFileInfo(kpath, ktype)
+	if err != nil {
+		return err
+	}
+
+	printKeyInfo(name, info)
+	return nil
+}
+
+func printKeyInfo(name string, info mc.KeyInfo) {
+	var encrypted string
+	if info.Encrypted {
+		encrypted = " (encrypted)"
+	}
+	fmt.Printf("%s %s %s%s\n", name, info.Id, info.Fingerprint, encrypted)
+}
+
+func keyExport(ktype, kpath, out string) error {
+	armor, err := mc.ArmorKeyFile(kpath, ktype)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		_, err = os.Stdout.Write(armor)
+		return err
+	}
+
+	return ioutil.WriteFile(out, armor, 0600)
+}
+
+// keyImport imports an armored key, encrypting it if there is a passphrase;
+// the key is loaded back as a node identity, so that a key that the node
+// can't load or unlock (eg encrypted with a different passphrase, or without
+// a passphrase) is not installed.
+func keyImport(home string, pass []byte, name, ktype, kpath, in string) error {
+	if in == "" {
+		in = "-"
+	}
+
+	armor, err := readKeyInput(in)
+	if err != nil {
+		return err
+	}
+
+	_, err = mc.ImportArmoredKey(kpath, ktype, armor)
+	if err != nil {
+		return err
+	}
+
+	err = loadImportedKey(home, pass, name, ktype, kpath)
+	if err != nil {
+		os.Remove(kpath)
+		return err
+	}
+
+	return keyShow(name, ktype, kpath)
+}
+
+func loadImportedKey(home string, pass []byte, name, ktype, kpath string) error {
+	if pass != nil {
+		_, err := mc.EncryptKeyFile(kpath, pass)
+		if err != nil {
+			return err
+		}
+	}
+
+	// only the imported key is loaded, so that an unrelated bad key file
+	// doesn't fail the import
+	var locked bool
+	switch {
+	case ktype == mc.NodeKeyType:
+		id, err := mc.LoadPeerIdentity(home, pass)
+		if err != nil {
+			return err
+		}
+		locked = id.Locked()
+	case name == "publisher" || name == DefaultPublisher:
+		pub, err := mc.LoadPublisherIdentity(home, pass)
+		if err != nil {
+			return err
+		}
+		locked = pub.Locked()
+	default:
+		pub, err := mc.LoadNamedPublisherIdentity(path.Dir(kpath), name, pass)
+		if err != nil {
+			return err
+		}
+		locked = pub.Locked()
+	}
+
+	if locked {
+		return mc.KeyLocked
+	}
+
+	return nil
+}
+
+func keyGenerate(home string, peerKey string, pass []byte, name, ktype, kpath string) error {
+	_, err := os.Stat(kpath)
+	switch {
+	case err == nil:
+		return mc.KeyExists
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	switch {
+	case ktype == mc.NodeKeyType:
+		_, err = mc.MakePeerIdentityKey(home, peerKey, pass)
+	case name == "publisher" || name == DefaultPublisher:
+		_, err = mc.MakePublisherIdentity(home, pass)
+	default:
+		_, err = mc.MakeNamedPublisherIdentity(path.Dir(kpath), name, pass)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return keyShow(name, ktype, kpath)
+}
+
+func readKeyInput(in string) ([]byte, error) {
+	if in == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(in)
+}
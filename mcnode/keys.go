@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	mc "github.com/mediachain/concat/mc"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+var (
+	NodeLocked        = errors.New("Node is locked; unlock with the key passphrase")
+	NoPassphrase      = errors.New("Empty passphrase")
+	NoSignerPublisher = errors.New("No publisher key; set the publisher id of the signer key with -signer-publisher")
+	SignerPublisher   = errors.New("Publisher key file does not match the signer publisher")
+	SignerKeys        = errors.New("Publisher keys are held by the external signer")
+	NoEncryptedKeys   = errors.New("Node keys are not encrypted")
+)
+
+// The key passphrase is read from the environment or from a passphrase file;
+// without a passphrase, nodes with encrypted keys start locked and must be
+// unlocked with POST /unlock before they can publish or go online.
+const PassphraseEnv = "MCNODE_PASSPHRASE"
+
+func readPassphrase(pfile string) ([]byte, error) {
+	pass := os.Getenv(PassphraseEnv)
+	if pass != "" {
+		return []byte(pass), nil
+	}
+
+	if pfile == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(pfile)
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimRight(data, "\r\n")
+	if len(data) == 0 {
+		return nil, NoPassphrase
+	}
+
+	return data, nil
+}
+
+// keyFiles lists the key files in the node home; files in the list may not
+// exist.
+func keyFiles(home string) ([]string, error) {
+	kpaths := []string{path.Join(home, "identity.node"), path.Join(home, "identity.publisher")}
+	for _, kdir := range backupKeyDirs {
+		files, err := ioutil.ReadDir(path.Join(home, kdir))
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		for _, file := range files {
+			if file.Mode().IsRegular() && strings.HasSuffix(file.Name(), ".publisher") {
+				kpaths = append(kpaths, path.Join(home, kdir, file.Name()))
+			}
+		}
+	}
+
+	return kpaths, nil
+}
+
+// hasEncryptedKeys checks whether any of the key files in the node home is
+// encrypted.
+func hasEncryptedKeys(home string) (bool, error) {
+	kpaths, err := keyFiles(home)
+	if err != nil {
+		return false, err
+	}
+
+	for _, kpath := range kpaths {
+		ok, err := mc.IsEncryptedKeyFile(kpath)
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return false, err
+		case ok:
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// doEncryptKeys encrypts the plain key files in the node home with pass
+func doEncryptKeys(home string, pass []byte) error {
+	kpaths, err := keyFiles(home)
+	if err != nil {
+		return err
+	}
+
+	for _, kpath := range kpaths {
+		ok, err := mc.EncryptKeyFile(kpath, pass)
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return err
+		case ok:
+			log.Printf("Encrypted %s", kpath)
+		}
+	}
+
+	return nil
+}
+
+// doUnlock decrypts the node keys with pass; the passphrase is retained for
+// encrypting new publisher keys.
+// Nodes without encrypted keys can't be unlocked, as there is nothing to
+// check the passphrase against.
+func (node *Node) doUnlock(pass []byte) error {
+	ok, err := hasEncryptedKeys(node.home)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return NoEncryptedKeys
+	}
+
+	id, err := mc.LoadPeerIdentity(node.home, pass)
+	if err != nil {
+		return err
+	}
+
+	pubid, err := mc.LoadPublisherIdentity(node.home, pass)
+	switch {
+	case os.IsNotExist(err) && node.signer != "":
+		// the default publisher key is held by the signer
+		pubid = node.defaultPublisher()
+	case err != nil:
+		return err
+	}
+
+	pubids, err := mc.LoadPublisherIdentities(path.Join(node.home, "publishers"), pass)
+	if err != nil {
+		return err
+	}
+
+	node.mx.Lock()
+	node.PeerIdentity = id
+	node.mx.Unlock()
+
+	node.pubmx.Lock()
+	node.publisher = pubid
+	node.pubids = pubids
+	node.passphrase = pass
+	node.attachSigners()
+	node.pubmx.Unlock()
+
+	log.Printf("Node unlocked")
+	return nil
+}
+
+// peerIdentity returns the node identity; the identity is replaced when the
+// node is unlocked, so it must be read with mx held.
+func (node *Node) peerIdentity() mc.PeerIdentity {
+	node.mx.Lock()
+	defer node.mx.Unlock()
+	return node.PeerIdentity
+}
+
+func (node *Node) isLocked() bool {
+	return node.peerIdentity().Locked() || node.defaultPublisher().Locked()
+}
+
+// loadDefaultPublisher loads the default publisher identity of the node.
+// With an external signer the node never generates a publisher key: the
+// identity is loaded from the key file in the home if there is one, or else
+// it is the key of the signer configured by its publisher id.
+func loadDefaultPublisher(home string, pass []byte, signer, signerPub string) (empty mc.PublisherIdentity, err error) {
+	if signer == "" {
+		return mc.MakePublisherIdentity(home, pass)
+	}
+
+	_, err = os.Stat(path.Join(home, "identity.publisher"))
+	switch {
+	case err == nil:
+		pub, err := mc.LoadPublisherIdentity(home, pass)
+		if err != nil {
+			return empty, err
+		}
+
+		if signerPub != "" && pub.ID58 != signerPub {
+			return empty, SignerPublisher
+		}
+
+		return pub, nil
+
+	case !os.IsNotExist(err):
+		return
+	}
+
+	if signerPub == "" {
+		return empty, NoSignerPublisher
+	}
+
+	_, err = mc.PublisherKey(signerPub)
+	if err != nil {
+		return
+	}
+
+	log.Printf("Publisher ID: %s (external)", signerPub)
+	return mc.PublisherIdentity{ID58: signerPub}, nil
+}
+
+// attachSigners sets the external signer for the publisher identities whose
+// private keys are not available to the node; pubmx must be held.
+func (node *Node) attachSigners() {
+	if node.signer == "" {
+		return
+	}
+
+	node.publisher = node.withSigner(node.publisher)
+	for name, pub := range node.pubids {
+		node.pubids[name] = node.withSigner(pub)
+	}
+}
+
+func (node *Node) withSigner(pub mc.PublisherIdentity) mc.PublisherIdentity {
+	if pub.PrivKey == nil {
+		pub.Signer = &mc.SocketSigner{Path: node.signer, Publisher: pub.ID58}
+	}
+	return pub
+}
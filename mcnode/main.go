@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path"
 )
 
 func main() {
@@ -16,10 +17,17 @@ func main() {
 	cport := flag.Int("c", 9002, "Peer control interface port [http]")
 	bindaddr := flag.String("b", "127.0.0.1", "Peer control bind address [http]")
 	hdir := flag.String("d", "~/.mediachain/mcnode", "Node home")
+	restore := flag.String("restore", "", "Restore node state from a backup directory and exit")
+	pfile := flag.String("passphrase-file", "", "Read the key passphrase from a file")
+	encrypt := flag.Bool("encrypt-keys", false, "Encrypt the node keys with the passphrase and exit")
+	signer := flag.String("signer", "", "Unix socket of an external signer for locked publisher keys")
+	signerPub := flag.String("signer-publisher", "", "Publisher id of the default publisher key held by the external signer")
+	peerKey := flag.String("peer-key", mc.DefaultPeerKey, "Key type for a new node key: rsa or ed25519")
 	flag.Parse()
 
-	if len(flag.Args()) != 0 {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options ...]\nOptions:\n", os.Args[0])
+	args := flag.Args()
+	if len(args) != 0 && args[0] != "key" {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options ...] [key show|export|import|generate ...]\nOptions:\n", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -39,17 +47,64 @@ func main() {
 		log.Fatal(err)
 	}
 
-	id, err := mc.MakePeerIdentity(home)
+	if *restore != "" {
+		bdir, err := homedir.Expand(*restore)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = doRestore(home, bdir)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	pass, err := readPassphrase(*pfile)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	pubid, err := mc.MakePublisherIdentity(home)
+	if len(args) != 0 {
+		err = doKeyCommand(home, *peerKey, pass, args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if *encrypt {
+		if pass == nil {
+			log.Fatal(NoPassphrase)
+		}
+
+		err = doEncryptKeys(home, pass)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	id, err := mc.MakePeerIdentityKey(home, *peerKey, pass)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	node := &Node{PeerIdentity: id, publisher: pubid, home: home, laddr: addr}
+	pubid, err := loadDefaultPublisher(home, pass, *signer, *signerPub)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pubids, err := mc.LoadPublisherIdentities(path.Join(home, "publishers"), pass)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	node := &Node{PeerIdentity: id, publisher: pubid, pubids: pubids, passphrase: pass, signer: *signer, home: home, laddr: addr}
+	node.attachSigners()
 
 	err = node.loadConfig()
 	if err != nil {
@@ -66,7 +121,13 @@ func main() {
 		log.Fatal(err)
 	}
 
+	go node.sweepObjects()
+	go node.evictObjects()
+
 	log.Println("Node is offline")
+	if node.isLocked() {
+		log.Println("Node is locked")
+	}
 
 	haddr := fmt.Sprintf("%s:%d", *bindaddr, *cport)
 	router := mux.NewRouter().StrictSlash(true)
@@ -74,7 +135,13 @@ func main() {
 	router.HandleFunc("/id/{peerId}", node.httpRemoteId)
 	router.HandleFunc("/ping/{peerId}", node.httpPing)
 	router.HandleFunc("/publish/{namespace}", node.httpPublish)
+	router.HandleFunc("/publish/{namespace}/objects", node.httpPublishObjects)
+	router.HandleFunc("/publish/{namespace}/envelope", node.httpPublishEnvelope)
 	router.HandleFunc("/publish/{namespace}/{combine}", node.httpPublishCompound)
+	router.HandleFunc("/publisher", node.httpPublishers)
+	router.HandleFunc("/publisher/{name}", node.httpPublisher)
+	router.HandleFunc("/publisher/{name}/rotate", node.httpPublisherRotate)
+	router.HandleFunc("/succession/conflicts", node.httpSuccessionConflicts)
 	router.HandleFunc("/stmt/{statementId}", node.httpStatement)
 	router.HandleFunc("/query", node.httpQuery)
 	router.HandleFunc("/query/{peerId}", node.httpRemoteQuery)
@@ -82,17 +149,32 @@ func main() {
 	router.HandleFunc("/push/{peerId}", node.httpPush)
 	router.HandleFunc("/delete", node.httpDelete)
 	router.HandleFunc("/data/put", node.httpPutData)
+	router.HandleFunc("/data/get", node.httpGetDataBatch)
 	router.HandleFunc("/data/get/{objectId}", node.httpGetData)
+	router.HandleFunc("/data/resolve/{objectId}", node.httpResolveData)
 	router.HandleFunc("/data/merge/{peerId}", node.httpMergeData)
 	router.HandleFunc("/data/keys", node.httpDataKeys)
+	router.HandleFunc("/data/stats", node.httpDataStats)
+	router.HandleFunc("/data/cache", node.httpDataCache)
 	router.HandleFunc("/data/gc", node.httpGCData)
 	router.HandleFunc("/data/compact", node.httpCompactData)
 	router.HandleFunc("/data/sync", node.httpSyncData)
+	router.HandleFunc("/export", node.httpExport)
+	router.HandleFunc("/import", node.httpImport)
+	router.HandleFunc("/backup", node.httpBackup)
 	router.HandleFunc("/status", node.httpStatus)
 	router.HandleFunc("/status/{state}", node.httpStatusSet)
 	router.HandleFunc("/config/dir", node.httpConfigDir)
 	router.HandleFunc("/config/nat", node.httpConfigNAT)
 	router.HandleFunc("/config/info", node.httpConfigInfo)
+	router.HandleFunc("/config/compression", node.httpConfigCompression)
+	router.HandleFunc("/config/quota", node.httpConfigQuota)
+	router.HandleFunc("/config/eviction", node.httpConfigEviction)
+	router.HandleFunc("/config/schema", node.httpConfigSchemas)
+	router.HandleFunc("/config/schema/{namespace}", node.httpConfigSchema)
+	router.HandleFunc("/config/validation", node.httpConfigValidation)
+	router.HandleFunc("/config/instance", node.httpConfigInstance)
+	router.HandleFunc("/config/signature", node.httpConfigSignature)
 	router.HandleFunc("/auth", node.httpAuth)
 	router.HandleFunc("/auth/{peerId}", node.httpAuthPeer)
 	router.HandleFunc("/dir/list", node.httpDirList)
@@ -100,6 +182,7 @@ func main() {
 	router.HandleFunc("/net/addr/{peerId}", node.httpNetPeerAddr)
 	router.HandleFunc("/net/conns", node.httpNetConns)
 	router.HandleFunc("/net/lookup/{peerId}", node.httpNetLookup)
+	router.HandleFunc("/unlock", node.httpUnlock)
 	router.HandleFunc("/shutdown", node.httpShutdown)
 
 	log.Printf("Serving client interface at %s", haddr)
@@ -68,6 +68,10 @@ func (node *Node) goOnline() error {
 }
 
 func (node *Node) _goOnline() error {
+	if node.PeerIdentity.Locked() {
+		return NodeLocked
+	}
+
 	var opts []interface{}
 	if node.natCfg.Opt == mc.NATConfigAuto {
 		opts = []interface{}{mc.NATPortMap}
@@ -170,7 +174,7 @@ func (node *Node) registerPeerImpl(ctx context.Context) error {
 	}
 	defer s.Close()
 
-	var pinfo = p2p_pstore.PeerInfo{ID: node.ID}
+	var pinfo = p2p_pstore.PeerInfo{ID: node.peerIdentity().ID}
 	var pbpi pb.PeerInfo
 
 	w := ggio.NewDelimitedWriter(s)
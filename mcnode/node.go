@@ -19,6 +19,7 @@ import (
 	"log"
 	"os"
 	"path"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -26,28 +27,45 @@ import (
 
 type Node struct {
 	mc.PeerIdentity
-	publisher mc.PublisherIdentity
-	info      string
-	status    int
-	laddr     multiaddr.Multiaddr
-	host      p2p_host.Host
-	netCtx    context.Context
-	netCancel context.CancelFunc
-	dht       DHT
-	dir       *p2p_pstore.PeerInfo
-	natCfg    mc.NATConfig
-	home      string
-	db        StatementDB
-	ds        Datastore
-	auth      PeerAuth
-	mx        sync.Mutex
-	counter   int
+	publisher  mc.PublisherIdentity
+	pubids     map[string]mc.PublisherIdentity
+	pubmx      sync.Mutex
+	passphrase []byte
+	signer     string
+	info       string
+	status     int
+	laddr      multiaddr.Multiaddr
+	host       p2p_host.Host
+	netCtx     context.Context
+	netCancel  context.CancelFunc
+	dht        DHT
+	dir        *p2p_pstore.PeerInfo
+	natCfg     mc.NATConfig
+	compress   string
+	quota      int64
+	evict      string
+	access     accessLog
+	evictmx    sync.Mutex
+	evictdirty bool
+	schemas    SchemaRegistry
+	validate   string
+	home       string
+	db         StatementDB
+	ds         Datastore
+	auth       PeerAuth
+	mx         sync.Mutex
+	counter    int64
+	climit     int64
+	instance   string
+	sigmode    string
 }
 
 type StatementDB interface {
 	Open(home string) error
 	Put(*pb.Statement) error
 	PutBatch([]*pb.Statement) error
+	PutBatchDedup(stmts []*pb.Statement, dkeys []string) ([]string, error)
+	ReserveCounter(name string, n int64) (int64, error)
 	Get(id string) (*pb.Statement, error)
 	Query(*mcq.Query) ([]interface{}, error)
 	QueryStream(context.Context, *mcq.Query) (<-chan interface{}, error)
@@ -55,6 +73,19 @@ type StatementDB interface {
 	Merge(*pb.Statement) (bool, error)
 	MergeBatch([]*pb.Statement) (int, error)
 	Delete(*mcq.Query) (int, error)
+	DeleteCascade(*mcq.Query, Datastore) (int, int, error)
+	DeleteIds(ids []string) (int, error)
+	ObjectRefs() (int, int64, error)
+	UseObjects(keys []string) error
+	LinkObjects(links map[string][]string) error
+	PruneObjectLinks(live func(key58 string) (bool, error)) error
+	SweepObjects(ds Datastore, ns string, grace time.Duration, limit int) (int, error)
+	UnreferencedObjects(ns string) ([]string, error)
+	CacheObjects([]CacheObject) error
+	TouchObjects(atimes map[string]int64) error
+	EvictObjects(ds Datastore, target int64, limit int) (int, int64, error)
+	CacheStats() (map[string]*CacheStats, error)
+	Backup(dir string) error
 	Close() error
 }
 
@@ -62,12 +93,21 @@ type Key multihash.Multihash
 type Datastore interface {
 	Open(home string) error
 	Put(data []byte) (Key, error)
+	PutHash(data []byte, code uint64) (Key, error)
+	PutKey(key Key, data []byte) error
 	PutBatch(batch [][]byte) ([]Key, error)
+	PutKeyBatch(keys []Key, batch [][]byte) error
 	Has(Key) (bool, error)
 	Get(Key) ([]byte, error)
+	GetBatch([]Key) ([][]byte, error)
 	Delete(Key) error
 	IterKeys(ctx context.Context) (<-chan Key, error)
 	Sync() error
+	Backup(dir string) error
+	Stats(ctx context.Context) (*DataStats, error)
+	SetQuota(quota int64)
+	Release(size int64)
+	Usage() (int64, error)
 	Compact()
 	Close()
 }
@@ -96,6 +136,7 @@ var (
 	BadQuery         = errors.New("Unexpected query")
 	BadState         = errors.New("Unrecognized state")
 	BadMethod        = errors.New("Unsupported method")
+	BadFormat        = errors.New("Unsupported format")
 	BadNamespace     = errors.New("Illegal namespace")
 	BadResult        = errors.New("Bad result set")
 	BadStatement     = errors.New("Bad statement; verification failed")
@@ -110,6 +151,10 @@ var (
 	NoDirectory      = errors.New("No directory server")
 	UnknownPeer      = errors.New("Unknown peer")
 	IllegalState     = errors.New("Illegal node state")
+	UnknownPublisher = errors.New("Unknown publisher identity")
+	BadInstance      = errors.New("Illegal instance name")
+	BadCombine       = errors.New("Bad combine option; must be a positive integer")
+	BadSigMode       = errors.New("Unknown signature mode")
 )
 
 const (
@@ -141,16 +186,31 @@ func sendStreamError(ctx context.Context, ch chan interface{}, what string) {
 	}
 }
 
-func (node *Node) stmtCounter() int {
+// Statement counters are reserved from the statement db in blocks, so that
+// counter values are never reused across restarts; the unused part of the
+// last block is skipped when the node restarts.
+const CounterBlock = 1024
+
+func (node *Node) stmtCounter() (int64, error) {
 	node.mx.Lock()
+	defer node.mx.Unlock()
+
+	if node.counter >= node.climit {
+		start, err := node.db.ReserveCounter("statement", CounterBlock)
+		if err != nil {
+			return 0, err
+		}
+		node.counter = start
+		node.climit = start + CounterBlock
+	}
+
 	counter := node.counter
 	node.counter++
-	node.mx.Unlock()
-	return counter
+	return counter, nil
 }
 
-func (node *Node) doPublish(ns string, body interface{}) (string, error) {
-	stmt, err := node.makeStatement(ns, body)
+func (node *Node) doPublish(pub mc.PublisherIdentity, ns string, body interface{}) (string, error) {
+	stmt, err := node.makeStatement(pub, ns, body)
 	if err != nil {
 		return "", err
 	}
@@ -159,35 +219,102 @@ func (node *Node) doPublish(ns string, body interface{}) (string, error) {
 	return stmt.Id, err
 }
 
-func (node *Node) doPublishBatch(ns string, lst []interface{}) ([]string, error) {
+// doPublishBatch publishes a batch of statement bodies in ns, signed by pub;
+// with dedup keys, bodies already published by pub or its predecessors with
+// the same key in ns are not published again and the id of the existing
+// statement is returned instead.
+func (node *Node) doPublishBatch(pub mc.PublisherIdentity, ns string, lst []interface{}, dkeys []string) ([]string, error) {
 	stmts := make([]*pb.Statement, len(lst))
-	sids := make([]string, len(lst))
 	for x, body := range lst {
-		stmt, err := node.makeStatement(ns, body)
+		stmt, err := node.makeStatement(pub, ns, body)
 		if err != nil {
 			return nil, err
 		}
 		stmts[x] = stmt
-		sids[x] = stmt.Id
 	}
 
-	err := node.db.PutBatch(stmts)
+	// dedup keys are scoped by the root of the publisher's succession chain,
+	// so that keys published before a rotation still dedup after it;
+	// without rotations the root is the publisher itself.
+	var pkeys []string
+	if dkeys != nil {
+		ps, err := node.loadSuccession()
+		if err != nil {
+			return nil, err
+		}
+
+		root := ps.Root(pub.ID58)
+		pkeys = make([]string, len(dkeys))
+		for x, key := range dkeys {
+			if key != "" {
+				pkeys[x] = root + "/" + key
+			}
+		}
+	}
+
+	return node.db.PutBatchDedup(stmts, pkeys)
+}
+
+// dedupKey computes the default dedup key of a statement body, as the hash
+// of its namespace and body.
+func dedupKey(ns string, body interface{}) (string, error) {
+	msg, ok := body.(ggproto.Message)
+	if !ok {
+		return "", BadStatementBody
+	}
+
+	bytes, err := ggproto.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 0, len(ns)+1+len(bytes))
+	buf = append(buf, ns...)
+	buf = append(buf, 0)
+	buf = append(buf, bytes...)
+
+	hash, err := multihash.Sum(buf, multihash.SHA2_256, -1)
+	if err != nil {
+		return "", err
+	}
+
+	return hash.B58String(), nil
+}
+
+func (node *Node) makeStatement(pub mc.PublisherIdentity, ns string, body interface{}) (*pb.Statement, error) {
+	stmt, err := node.newStatement(pub, ns, body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = node.signStatement(pub, stmt)
 	if err != nil {
 		return nil, err
 	}
 
-	return sids, err
+	return stmt, nil
 }
 
-func (node *Node) makeStatement(ns string, body interface{}) (*pb.Statement, error) {
+// newStatement creates an unsigned statement with a fresh id
+func (node *Node) newStatement(pub mc.PublisherIdentity, ns string, body interface{}) (*pb.Statement, error) {
 	stmt := new(pb.Statement)
-	pid := node.publisher.ID58
+	pid := pub.ID58
 	ts := time.Now().Unix()
-	counter := node.stmtCounter()
-	stmt.Id = fmt.Sprintf("%s:%d:%d", pid, ts, counter)
+	counter, err := node.stmtCounter()
+	if err != nil {
+		return nil, err
+	}
+
+	// nodes sharing a publisher key are distinguished by their instance name
+	if node.instance != "" {
+		stmt.Id = fmt.Sprintf("%s:%d:%s:%d", pid, ts, node.instance, counter)
+	} else {
+		stmt.Id = fmt.Sprintf("%s:%d:%d", pid, ts, counter)
+	}
 	stmt.Publisher = pid
 	stmt.Namespace = ns
 	stmt.Timestamp = ts
+	stmt.SigVersion = node.sigVersion()
 	switch body := body.(type) {
 	case *pb.SimpleStatement:
 		stmt.Body = &pb.StatementBody{&pb.StatementBody_Simple{body}}
@@ -201,25 +328,23 @@ func (node *Node) makeStatement(ns string, body interface{}) (*pb.Statement, err
 	case *pb.ArchiveStatement:
 		stmt.Body = &pb.StatementBody{&pb.StatementBody_Archive{body}}
 
+	case *pb.SuccessionStatement:
+		stmt.Body = &pb.StatementBody{&pb.StatementBody_Succession{body}}
+
 	default:
 		return nil, BadStatementBody
 	}
 
-	err := node.signStatement(stmt)
-	if err != nil {
-		return nil, err
-	}
-
 	return stmt, nil
 }
 
-func (node *Node) signStatement(stmt *pb.Statement) error {
-	bytes, err := ggproto.Marshal(stmt)
+func (node *Node) signStatement(pub mc.PublisherIdentity, stmt *pb.Statement) error {
+	bytes, err := mc.StatementSigPayload(stmt)
 	if err != nil {
 		return err
 	}
 
-	sig, err := node.publisher.PrivKey.Sign(bytes)
+	sig, err := pub.Sign(bytes)
 	if err != nil {
 		return err
 	}
@@ -247,32 +372,41 @@ func (node *Node) verifyStatement(stmt *pb.Statement) (bool, error) {
 }
 
 func (node *Node) verifyStatementCacheKeys(stmt *pb.Statement, pkcache map[string]p2p_crypto.PubKey) (bool, error) {
-	var pubk p2p_crypto.PubKey
-	var err error
-
-	pubk, ok := pkcache[stmt.Publisher]
-	if !ok {
-		pubk, err = mc.PublisherKey(stmt.Publisher)
-		if err != nil {
-			return false, err
-		}
-		pkcache[stmt.Publisher] = pubk
+	pubk, err := cachedPublisherKey(stmt.Publisher, pkcache)
+	if err != nil {
+		return false, err
 	}
 
 	return node.verifyStatementSig(stmt, pubk)
 }
 
-func (node *Node) verifyStatementSig(stmt *pb.Statement, pubk p2p_crypto.PubKey) (bool, error) {
-	sig := stmt.Signature
-	stmt.Signature = nil
-	bytes, err := ggproto.Marshal(stmt)
-	stmt.Signature = sig
+func cachedPublisherKey(pub string, pkcache map[string]p2p_crypto.PubKey) (p2p_crypto.PubKey, error) {
+	pubk, ok := pkcache[pub]
+	if ok {
+		return pubk, nil
+	}
 
+	pubk, err := mc.PublisherKey(pub)
 	if err != nil {
+		return nil, err
+	}
+
+	pkcache[pub] = pubk
+	return pubk, nil
+}
+
+// verifyStatementSig verifies the signature of a statement in any of the
+// supported signature versions.
+func (node *Node) verifyStatementSig(stmt *pb.Statement, pubk p2p_crypto.PubKey) (bool, error) {
+	bytes, err := mc.StatementSigPayload(stmt)
+	switch {
+	case err == mc.BadSigVersion:
+		return false, nil
+	case err != nil:
 		return false, err
 	}
 
-	return pubk.Verify(bytes, sig)
+	return pubk.Verify(bytes, stmt.Signature)
 }
 
 func (node *Node) openDB() error {
@@ -281,16 +415,59 @@ func (node *Node) openDB() error {
 }
 
 func (node *Node) openDS() error {
-	node.ds = &RocksDS{}
+	node.ds = &RocksDS{compression: node.compress, quota: node.quota}
 	return node.ds.Open(node.home)
 }
 
+var instrx = regexp.MustCompile("^[a-zA-Z0-9-]+$")
+
+// checkInstance checks an instance name, which becomes part of statement ids
+func checkInstance(instance string) error {
+	if !instrx.MatchString(instance) {
+		return BadInstance
+	}
+	return nil
+}
+
+// Signature modes for published statements: proto signs the protobuf
+// encoding of statements (sigVersion 0), understood by all nodes; canonical
+// signs the canonical payload (sigVersion 1), which doesn't depend on
+// protobuf marshalling but can't be verified by older nodes.
+// Statements are verified in both modes regardless of the setting.
+const (
+	SigModeProto     = "proto"
+	SigModeCanonical = "canonical"
+)
+
+func checkSigMode(mode string) error {
+	switch mode {
+	case SigModeProto, SigModeCanonical:
+		return nil
+	default:
+		return BadSigMode
+	}
+}
+
+func (node *Node) sigVersion() uint32 {
+	if node.sigmode == SigModeCanonical {
+		return mc.SigVersionCanonical
+	}
+	return mc.SigVersionProto
+}
+
 // persistent configuration
 type NodeConfig struct {
-	Info string                 `json:"info,omitempty"`
-	NAT  string                 `json:"nat,omitempty"`
-	Dir  string                 `json:"dir,omitempty"`
-	Auth map[string]interface{} `json:"auth,omitempty"`
+	Info        string                     `json:"info,omitempty"`
+	NAT         string                     `json:"nat,omitempty"`
+	Dir         string                     `json:"dir,omitempty"`
+	Auth        map[string]interface{}     `json:"auth,omitempty"`
+	Compression string                     `json:"compression,omitempty"`
+	Quota       int64                      `json:"quota,omitempty"`
+	Eviction    string                     `json:"eviction,omitempty"`
+	Schemas     map[string]json.RawMessage `json:"schemas,omitempty"`
+	Validation  string                     `json:"validation,omitempty"`
+	Instance    string                     `json:"instance,omitempty"`
+	Signature   string                     `json:"signature,omitempty"`
 }
 
 func (node *Node) saveConfig() error {
@@ -301,6 +478,13 @@ func (node *Node) saveConfig() error {
 		cfg.Dir = mc.FormatHandle(*node.dir)
 	}
 	cfg.Auth = node.auth.toJSON()
+	cfg.Compression = node.compress
+	cfg.Quota = node.quota
+	cfg.Eviction = node.evict
+	cfg.Schemas = node.schemas.toJSON()
+	cfg.Validation = node.validate
+	cfg.Instance = node.instance
+	cfg.Signature = node.sigmode
 
 	bytes, err := json.Marshal(cfg)
 	if err != nil {
@@ -344,6 +528,53 @@ func (node *Node) loadConfig() error {
 		node.dir = &pinfo
 	}
 
+	if cfg.Compression != "" {
+		err = checkCompression(cfg.Compression)
+		if err != nil {
+			return err
+		}
+		node.compress = cfg.Compression
+	}
+
+	node.quota = cfg.Quota
+
+	if cfg.Eviction != "" {
+		err = checkEviction(cfg.Eviction)
+		if err != nil {
+			return err
+		}
+		node.evict = cfg.Eviction
+	}
+
+	if cfg.Validation != "" {
+		err = checkValidation(cfg.Validation)
+		if err != nil {
+			return err
+		}
+		node.validate = cfg.Validation
+	}
+
+	if cfg.Instance != "" {
+		err = checkInstance(cfg.Instance)
+		if err != nil {
+			return err
+		}
+		node.instance = cfg.Instance
+	}
+
+	if cfg.Signature != "" {
+		err = checkSigMode(cfg.Signature)
+		if err != nil {
+			return err
+		}
+		node.sigmode = cfg.Signature
+	}
+
+	err = node.schemas.fromJSON(cfg.Schemas)
+	if err != nil {
+		return err
+	}
+
 	err = node.auth.fromJSON(cfg.Auth)
 	return err
 }
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	ggio "github.com/gogo/protobuf/io"
 	p2p_crypto "github.com/libp2p/go-libp2p-crypto"
@@ -59,8 +58,8 @@ func (node *Node) idHandler(s p2p_net.Stream) {
 		return
 	}
 
-	res.Peer = node.PeerIdentity.Pretty()
-	res.Publisher = node.publisher.ID58
+	res.Peer = node.peerIdentity().Pretty()
+	res.Publisher = node.defaultPublisher().ID58
 	res.Info = node.info
 
 	w.WriteMsg(&res)
@@ -196,7 +195,7 @@ func (node *Node) dataHandler(s p2p_net.Stream) {
 		log.Printf("node/data: %s asked for %d objects", pid.Pretty(), len(req.Keys))
 
 		for _, key58 := range req.Keys {
-			key, err := multihash.FromB58String(key58)
+			key, err := mc.ParseKey(key58)
 			if err != nil {
 				writeError(err)
 				return
@@ -209,6 +208,7 @@ func (node *Node) dataHandler(s p2p_net.Stream) {
 			}
 
 			if data != nil {
+				node.touchObject(Key(key))
 				err = writeData(key58, data)
 				if err != nil {
 					return
@@ -267,7 +267,8 @@ func (node *Node) pushHandler(s p2p_net.Stream) {
 	var mdone bool
 
 	go func() {
-		scount, ocount, err := node.doMergeStream(ctx, pid, wch)
+		// objects rejected by merge validation are logged by the merge
+		scount, ocount, _, err := node.doMergeStream(ctx, pid, wch, 0)
 		rch <- PushMergeResult{scount, ocount, err}
 	}()
 
@@ -465,64 +466,61 @@ func (node *Node) doRemoteQueryStream(ctx context.Context, s p2p_net.Stream, ch
 	}
 }
 
-func (node *Node) doMerge(ctx context.Context, pid p2p_peer.ID, q string) (count int, ocount int, err error) {
+// doMerge merges the statements matching q from a remote peer, together with
+// their data. With a non-zero depth, merkle links in merged objects are
+// followed up to depth levels (or without limit if depth is negative).
+// With merge validation, objects that violate the schema of their namespace
+// are skipped and returned as rejects, and the statements referencing them
+// are deleted.
+func (node *Node) doMerge(ctx context.Context, pid p2p_peer.ID, q string, depth int) (count int, ocount int, rejects []SchemaViolation, err error) {
 	ch, err := node.doRemoteQuery(ctx, pid, q)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, nil, err
 	}
 
-	return node.doMergeStream(ctx, pid, ch)
+	return node.doMergeStream(ctx, pid, ch, depth)
 }
 
-func (node *Node) doMergeStream(ctx context.Context, pid p2p_peer.ID, ch <-chan interface{}) (count int, ocount int, err error) {
-	// publisher key cache
-	pkcache := make(map[string]p2p_crypto.PubKey)
+func (node *Node) doMergeStream(ctx context.Context, pid p2p_peer.ID, ch <-chan interface{}, depth int) (count int, ocount int, rejects []SchemaViolation, err error) {
+	// background statement verification; the pipeline is stopped when the
+	// merge returns
+	vctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	vch := node.verifyMergeStream(vctx, ch)
 
 	// background data merges
 	workers := runtime.NumCPU()
-	workch := make(chan map[string]Key, 64*workers) // ~ 3MB/worker
+	workch := make(chan MergeKeys, 64*workers) // ~ 3MB/worker
 	resch := make(chan MergeResult, workers)
 	for x := 0; x < workers; x++ {
-		go node.doMergeDataAsync(ctx, pid, workch, resch)
+		go node.doMergeDataAsync(ctx, pid, depth, workch, resch)
 	}
 
 	const batch = 1024
 	stmts := make([]*pb.Statement, 0, batch)
-	keys := make(map[string]Key)
+	keys := makeMergeKeys()
+
+	// objects rejected by merge validation; their statements are deleted
+	// when the merge is done, as they may have already been committed
+	var mrejects []MergeReject
 
 loop:
-	for val := range ch {
+	for val := range vch {
 		switch val := val.(type) {
 		case *pb.Statement:
-			if !node.checkStatement(val) {
-				err = BadStatement
-				break loop
-			}
-
-			var verify bool
-			verify, err = node.verifyStatementCacheKeys(val, pkcache)
-			if err != nil {
-				break loop
-			}
-
-			// a verification failure taints the result set; abort the merge
-			if !verify {
-				err = BadStatement
-				break loop
-			}
-
-			err = node.mergeStatementKeys(val, keys)
+			err = node.mergeStatementKeysNS(val, keys)
 			if err != nil {
 				break loop
 			}
 
-			if len(keys) >= batch {
+			if len(keys.keys) >= batch {
 				select {
 				case workch <- keys:
-					keys = make(map[string]Key)
+					keys = makeMergeKeys()
 
 				case res := <-resch:
 					ocount += res.count
+					mrejects = append(mrejects, res.rejects...)
 					err = res.err
 					workers -= 1
 					break loop
@@ -545,6 +543,11 @@ loop:
 				stmts = stmts[:0]
 			}
 
+		case verifyError:
+			// a verification failure taints the result set; abort the merge
+			err = val.err
+			break loop
+
 		case StreamError:
 			err = val
 			break loop
@@ -555,12 +558,13 @@ loop:
 		}
 	}
 
-	if len(keys) > 0 && err == nil {
+	if len(keys.keys) > 0 && err == nil {
 		select {
 		case workch <- keys:
 
 		case res := <-resch:
 			ocount += res.count
+			mrejects = append(mrejects, res.rejects...)
 			err = res.err
 			workers -= 1
 
@@ -579,17 +583,92 @@ loop:
 	for x := 0; x < workers; x++ {
 		res := <-resch
 		ocount += res.count
+		mrejects = append(mrejects, res.rejects...)
 		if err == nil && res.err != nil {
 			err = res.err
 		}
 	}
 
-	return count, ocount, err
+	if len(mrejects) > 0 {
+		var xcount int
+		var xerr error
+		rejects, xcount, xerr = node.dropMergeRejects(mrejects)
+		count -= xcount
+		if count < 0 {
+			count = 0
+		}
+		if err == nil {
+			err = xerr
+		}
+	}
+
+	return count, ocount, rejects, err
+}
+
+// dropMergeRejects deletes the statements referencing objects rejected by
+// merge validation; returns the schema violations and the number of
+// statements deleted.
+func (node *Node) dropMergeRejects(mrejects []MergeReject) ([]SchemaViolation, int, error) {
+	rejects := make([]SchemaViolation, len(mrejects))
+	ids := make([]string, 0, len(mrejects))
+	for x, reject := range mrejects {
+		rejects[x] = reject.SchemaViolation
+		ids = append(ids, reject.ids...)
+		log.Printf("node/merge: rejected object %s: %s", reject.Object, reject.Reason)
+	}
+
+	count, err := node.db.DeleteIds(ids)
+	return rejects, count, err
 }
 
 type MergeResult struct {
-	count int
-	err   error
+	count   int
+	rejects []MergeReject
+	err     error
+}
+
+// MergeKeys is a batch of object keys to merge, with the namespace of the
+// statement that first referenced each key; merged objects are cached
+// under that namespace.
+// With merge validation, statement objects are validated against the
+// schema of their namespace before they are stored; the ids of the
+// statements referencing them are kept, so that the statements can be
+// deleted if an object is rejected.
+type MergeKeys struct {
+	keys   map[string]Key
+	ns     map[string]string
+	schema map[string]*mc.Schema
+	stmts  map[string][]string
+}
+
+func makeMergeKeys() MergeKeys {
+	return MergeKeys{make(map[string]Key), make(map[string]string), make(map[string]*mc.Schema), make(map[string][]string)}
+}
+
+// MergeReject is an object rejected by merge validation, with the ids of
+// the statements referencing it.
+type MergeReject struct {
+	SchemaViolation
+	ids []string
+}
+
+func (node *Node) mergeStatementKeysNS(stmt *pb.Statement, keys MergeKeys) error {
+	skeys := make(map[string]Key)
+	err := node.mergeStatementKeys(stmt, skeys)
+	if err != nil {
+		return err
+	}
+
+	for key58, key := range skeys {
+		_, have := keys.keys[key58]
+		if !have {
+			keys.keys[key58] = key
+			keys.ns[key58] = stmt.Namespace
+		}
+	}
+
+	node.mergeStatementSchemas(stmt, keys)
+	return nil
 }
 
 // Note: it is possible to refetch the same object if it appears in multiple batches.
@@ -599,12 +678,13 @@ type MergeResult struct {
 // schema objects, and would result in at most NumCPU dupe fetches.
 // So the overhead should be minimal and not worth the complexity/slowdown from
 // tracking in-flight requests
-func (node *Node) doMergeDataAsync(ctx context.Context, pid p2p_peer.ID,
-	in <-chan map[string]Key,
+func (node *Node) doMergeDataAsync(ctx context.Context, pid p2p_peer.ID, depth int,
+	in <-chan MergeKeys,
 	out chan<- MergeResult) {
 	var s p2p_net.Stream
 	var err error
 	var count int
+	var rejects []MergeReject
 
 	for keys := range in {
 		if s == nil {
@@ -616,33 +696,79 @@ func (node *Node) doMergeDataAsync(ctx context.Context, pid p2p_peer.ID,
 		}
 
 		var xcount int
-		xcount, err = node.doMergeDataImpl(s, keys)
+		var xrejects []MergeReject
+		xcount, xrejects, err = node.doMergeDataImpl(s, keys, depth)
 		count += xcount
+		rejects = append(rejects, xrejects...)
 		if err != nil {
 			break
 		}
 	}
 
-	out <- MergeResult{count, err}
+	out <- MergeResult{count, rejects, err}
 }
 
-func (node *Node) doMergeDataImpl(s p2p_net.Stream, keys map[string]Key) (count int, err error) {
-	keys58 := make([]string, 0, len(keys))
-	for key58, key := range keys {
-		have, err := node.ds.Has(key)
-		if err != nil {
-			return 0, err
+// doMergeDataImpl fetches the missing objects in keys from a data stream.
+// With a non-zero depth, the merkle links of fetched objects are resolved
+// and the linked objects are fetched in turn; links are only followed from
+// objects fetched by the merge, as local objects are assumed to be complete.
+// Linked objects are cached in the namespace of the object linking them.
+func (node *Node) doMergeDataImpl(s p2p_net.Stream, keys MergeKeys, depth int) (count int, rejects []MergeReject, err error) {
+	for {
+		var links MergeKeys
+		if depth != 0 {
+			links = makeMergeKeys()
 		}
-		if have {
-			continue
+
+		var xcount int
+		var xrejects []MergeReject
+		xcount, xrejects, err = node.doMergeDataRound(s, keys, links)
+		count += xcount
+		rejects = append(rejects, xrejects...)
+		if err != nil || len(links.keys) == 0 {
+			return count, rejects, err
 		}
-		keys58 = append(keys58, key58)
+
+		if depth > 0 {
+			depth--
+		}
+		keys = links
+	}
+}
+
+// doMergeDataRound fetches the missing objects in keys; if links is not
+// empty, the merkle links of the fetched objects are added to it.
+// The fetched objects are added to the datastore cache.
+// Objects that fail merge validation are not stored; they are returned as
+// rejects, and their links are not followed.
+func (node *Node) doMergeDataRound(s p2p_net.Stream, keys MergeKeys, links MergeKeys) (count int, rejects []MergeReject, err error) {
+	keys58, err := node.missingDataKeys(keys.keys)
+	if err != nil {
+		return 0, nil, err
 	}
 
 	if len(keys58) == 0 {
-		return 0, nil
+		return 0, nil, nil
 	}
 
+	cache := make([]CacheObject, 0, len(keys58))
+	objlinks := make(map[string][]string)
+	defer func() {
+		if len(cache) > 0 {
+			xerr := node.db.CacheObjects(cache)
+			if xerr != nil && err == nil {
+				err = xerr
+			}
+		}
+
+		if len(objlinks) > 0 {
+			xerr := node.db.LinkObjects(objlinks)
+			if xerr != nil && err == nil {
+				err = xerr
+			}
+		}
+	}()
+
 	var req pb.DataRequest
 	var res pb.DataResult
 
@@ -652,61 +778,201 @@ func (node *Node) doMergeDataImpl(s p2p_net.Stream, keys map[string]Key) (count
 	req.Keys = keys58
 	err = w.WriteMsg(&req)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
 loop:
 	for {
 		err := r.ReadMsg(&res)
 		if err != nil {
-			return count, err
+			return count, rejects, err
 		}
 
 		switch res := res.Result.(type) {
 		case *pb.DataResult_Data:
 			key58 := res.Data.Key
+			ns := keys.ns[key58]
+
+			schema, ok := keys.schema[key58]
+			if ok {
+				err = node.validateMergeData(schema, res.Data, keys.keys)
+				if xerr, ok := err.(SchemaViolation); ok {
+					// skip the object; its statements are deleted by the merge
+					rejects = append(rejects, MergeReject{xerr, keys.stmts[key58]})
+					break
+				}
 
-			key, ok := keys[key58]
-			if !ok {
-				return count, UnexpectedData
+				if err != nil {
+					return count, rejects, err
+				}
 			}
 
-			// verify data hash
-			data := res.Data.Data
-			hash := mc.Hash(data)
-			if !bytes.Equal([]byte(key), []byte(hash)) {
-				return count, BadData
+			err = node.mergeDataObjectEvict(res.Data, keys.keys)
+			if err != nil {
+				return count, rejects, err
 			}
 
-			_, err = node.ds.Put(data)
-			if err != nil {
-				return count, err
+			cache = append(cache, CacheObject{key58, ns, len(res.Data.Data)})
+
+			if links.keys != nil {
+				xlinks := node.mergeObjectLinks(res.Data.Data, links, ns)
+				if len(xlinks) > 0 {
+					objlinks[key58] = xlinks
+				}
 			}
 
-			delete(keys, key58)
 			count++
 
 		case *pb.DataResult_End:
 			break loop
 
 		case *pb.DataResult_Error:
-			return count, StreamError{res.Error.Error}
+			return count, rejects, StreamError{res.Error.Error}
 
 		default:
-			return count, BadResult
+			return count, rejects, BadResult
 		}
 
 		res.Reset()
 	}
 
-	if count < len(keys58) { // we didn't get all the data we asked for, signal error
-		return count, MissingData
+	if count+len(rejects) < len(keys58) { // we didn't get all the data we asked for, signal error
+		return count, rejects, MissingData
 	}
 
-	return count, nil
+	return count, rejects, nil
 }
 
-func (node *Node) doRawMerge(ctx context.Context, pid p2p_peer.ID, keys map[string]Key) (int, error) {
+// validateMergeData validates a data object received for merge; the object
+// must be one of the expected keys, and is removed from the map if it is
+// rejected, so that it doesn't count as missing.
+func (node *Node) validateMergeData(schema *mc.Schema, obj *pb.DataObject, keys map[string]Key) error {
+	key, ok := keys[obj.Key]
+	if !ok {
+		return UnexpectedData
+	}
+
+	// the hash is verified first, so that a peer can't get statements
+	// deleted by sending bogus data for their objects
+	if !mc.VerifyHash(multihash.Multihash(key), obj.Data) {
+		return BadData
+	}
+
+	err := validateData(schema, obj.Key, obj.Data)
+	if err != nil {
+		delete(keys, obj.Key)
+	}
+
+	return err
+}
+
+// verifyMergeStatement checks the signature of a statement received for merge;
+// succession statements must carry a valid successor signature as well.
+func (node *Node) verifyMergeStatement(stmt *pb.Statement, pkcache map[string]p2p_crypto.PubKey) error {
+	if !node.checkStatement(stmt) {
+		return BadStatement
+	}
+
+	verify, err := node.verifyStatementCacheKeys(stmt, pkcache)
+	if err != nil {
+		return err
+	}
+
+	if !verify {
+		return BadStatement
+	}
+
+	if stmt.Body.GetSuccession() != nil {
+		verify, err = node.verifySuccession(stmt)
+		if err != nil {
+			return err
+		}
+
+		if !verify {
+			return BadSuccession
+		}
+	}
+
+	return nil
+}
+
+// missingDataKeys returns the keys that are not in the local datastore;
+// the keys are marked as used first, so that objects found present are not
+// swept before the merge references them.
+func (node *Node) missingDataKeys(keys map[string]Key) ([]string, error) {
+	err := node.useDataKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	keys58 := make([]string, 0, len(keys))
+	for key58, key := range keys {
+		have, err := node.ds.Has(key)
+		if err != nil {
+			return nil, err
+		}
+		if have {
+			continue
+		}
+		keys58 = append(keys58, key58)
+	}
+
+	return keys58, nil
+}
+
+// useDataKeys marks the objects of keys as used
+func (node *Node) useDataKeys(keys map[string]Key) error {
+	keys58 := make([]string, 0, len(keys))
+	for key58, _ := range keys {
+		keys58 = append(keys58, key58)
+	}
+
+	return node.db.UseObjects(keys58)
+}
+
+// mergeDataObjectEvict merges a data object, evicting cached objects to
+// make room if the datastore quota is exceeded.
+func (node *Node) mergeDataObjectEvict(obj *pb.DataObject, keys map[string]Key) error {
+	err := node.mergeDataObject(obj, keys)
+	if err != QuotaExceeded || node.evict != EvictLRU {
+		return err
+	}
+
+	count, _, err := node.doEvictMerge()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return QuotaExceeded
+	}
+
+	return node.mergeDataObject(obj, keys)
+}
+
+// mergeDataObject verifies and stores a data object received for merge;
+// the object must be one of the expected keys and is removed from the map.
+func (node *Node) mergeDataObject(obj *pb.DataObject, keys map[string]Key) error {
+	key, ok := keys[obj.Key]
+	if !ok {
+		return UnexpectedData
+	}
+
+	// verify data hash
+	if !mc.VerifyHash(multihash.Multihash(key), obj.Data) {
+		return BadData
+	}
+
+	err := node.ds.PutKey(key, obj.Data)
+	if err != nil {
+		return err
+	}
+
+	delete(keys, obj.Key)
+	return nil
+}
+
+func (node *Node) doRawMerge(ctx context.Context, pid p2p_peer.ID, keys MergeKeys, depth int) (int, error) {
 	err := node.doConnect(ctx, pid)
 	if err != nil {
 		return 0, err
@@ -718,7 +984,9 @@ func (node *Node) doRawMerge(ctx context.Context, pid p2p_peer.ID, keys map[stri
 	}
 	defer s.Close()
 
-	return node.doMergeDataImpl(s, keys)
+	// raw merges have no statements, and thus no schemas to validate
+	count, _, err := node.doMergeDataImpl(s, keys, depth)
+	return count, err
 }
 
 func (node *Node) mergeStatementKeys(stmt *pb.Statement, keys map[string]Key) error {
@@ -762,23 +1030,49 @@ func (node *Node) mergeStatementKeys(stmt *pb.Statement, keys map[string]Key) er
 		}
 		return nil
 
+	case *pb.StatementBody_Succession:
+		return nil
+
 	default:
 		return BadStatementBody
 	}
 }
 
+// mergeObjectLinks adds the merkle links of a CBOR object to keys; returns
+// the keys of the links.
+// Objects that are not valid CBOR have no links; this is not an error,
+// as the datastore does not constrain the encoding of objects.
+func (node *Node) mergeObjectLinks(data []byte, keys MergeKeys, ns string) []string {
+	links, err := mc.CBORLinks(data)
+	if err != nil {
+		return nil
+	}
+
+	keys58 := make([]string, len(links))
+	for x, link := range links {
+		key58 := link.B58String()
+		keys.keys[key58] = Key(link)
+		keys.ns[key58] = ns
+		keys58[x] = key58
+	}
+
+	return keys58
+}
+
+// mergeObjectKey adds an object key to keys; CID keys are normalized to
+// b58 multihashes, which is what peers expect in data requests.
 func (node *Node) mergeObjectKey(key58 string, keys map[string]Key) error {
 	_, have := keys[key58]
 	if have {
 		return nil
 	}
 
-	mhash, err := multihash.FromB58String(key58)
+	mhash, err := mc.ParseKey(key58)
 	if err != nil {
 		return err
 	}
 
-	keys[key58] = Key(mhash)
+	keys[mhash.B58String()] = Key(mhash)
 	return nil
 }
 
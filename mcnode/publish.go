@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	mc "github.com/mediachain/concat/mc"
+	mcq "github.com/mediachain/concat/mc/query"
+	pb "github.com/mediachain/concat/proto"
+	multihash "github.com/multiformats/go-multihash"
+	"path"
+	"strings"
+)
+
+var (
+	BadIdSelector = errors.New("Bad id selector")
+	NoObjectId    = errors.New("Id selector doesn't match a string or number in the object")
+)
+
+// PublishStatement is a record for publishing a SimpleStatement, with an
+// optional client supplied dedup key.
+type PublishStatement struct {
+	pb.SimpleStatement
+	Key string `json:"key,omitempty"`
+}
+
+// PublishObject is a record for publishing an object together with its
+// statement; Object is a JSON value, stored as canonical CBOR.
+type PublishObject struct {
+	Object interface{} `json:"object"`
+	Refs   []string    `json:"refs,omitempty"`
+	Tags   []string    `json:"tags,omitempty"`
+	Deps   []string    `json:"deps,omitempty"`
+	Key    string      `json:"key,omitempty"`
+}
+
+type PublishResult struct {
+	Id     string `json:"id"`
+	Object string `json:"object"`
+}
+
+// DefaultPublisher is the name of the node's primary publisher identity,
+// loaded from identity.publisher
+const DefaultPublisher = "default"
+
+// getPublisher returns a publisher identity by name; the empty name selects
+// the default identity. Locked identities can't be used for publishing, and
+// neither can any identity while the node is locked.
+func (node *Node) getPublisher(name string) (empty mc.PublisherIdentity, err error) {
+	if node.isLocked() {
+		return empty, NodeLocked
+	}
+
+	node.pubmx.Lock()
+	defer node.pubmx.Unlock()
+
+	pub := node.publisher
+	if name != "" && name != DefaultPublisher {
+		var ok bool
+		pub, ok = node.pubids[name]
+		if !ok {
+			return empty, UnknownPublisher
+		}
+	}
+
+	if pub.Locked() {
+		return empty, NodeLocked
+	}
+
+	return pub, nil
+}
+
+// defaultPublisher returns the default publisher identity, which changes
+// when its key is rotated.
+func (node *Node) defaultPublisher() mc.PublisherIdentity {
+	node.pubmx.Lock()
+	defer node.pubmx.Unlock()
+	return node.publisher
+}
+
+// listPublishers returns the ids of the publisher identities by name
+func (node *Node) listPublishers() map[string]string {
+	node.pubmx.Lock()
+	defer node.pubmx.Unlock()
+
+	pubs := make(map[string]string)
+	pubs[DefaultPublisher] = node.publisher.ID58
+	for name, pub := range node.pubids {
+		pubs[name] = pub.ID58
+	}
+
+	return pubs
+}
+
+// addPublisher creates a named publisher identity, by generating a new key
+// or importing key if it is not empty.
+func (node *Node) addPublisher(name string, key []byte) (empty mc.PublisherIdentity, err error) {
+	if name == DefaultPublisher {
+		return empty, mc.PublisherExists
+	}
+
+	// a locked node doesn't have the passphrase to encrypt the new key
+	if node.isLocked() {
+		return empty, NodeLocked
+	}
+
+	node.pubmx.Lock()
+	defer node.pubmx.Unlock()
+
+	dir := path.Join(node.home, "publishers")
+
+	var pub mc.PublisherIdentity
+	switch {
+	case len(key) == 0 && node.signer != "":
+		// the node doesn't generate keys when they are held by a signer
+		return empty, SignerKeys
+	case len(key) == 0:
+		pub, err = mc.MakeNamedPublisherIdentity(dir, name, node.passphrase)
+	default:
+		pub, err = mc.ImportPublisherIdentity(dir, name, key, node.passphrase)
+	}
+
+	if err != nil {
+		return empty, err
+	}
+
+	if node.pubids == nil {
+		node.pubids = make(map[string]mc.PublisherIdentity)
+	}
+	node.pubids[name] = pub
+
+	return pub, nil
+}
+
+// Streaming publish: statements are committed in chunks of PublishChunk
+const PublishChunk = 1024
+
+// PublishStreamResult is the result of publishing a statement in a streaming
+// publish; Index is the position of the statement in the request.
+type PublishStreamResult struct {
+	Index int    `json:"index"`
+	Id    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type PublishSummary struct {
+	Statements int    `json:"statements"`
+	Errors     int    `json:"errors"`
+	Error      string `json:"error,omitempty"`
+}
+
+type PublishStreamSummary struct {
+	Summary PublishSummary `json:"summary"`
+}
+
+// doPublishChunk publishes a chunk of statement bodies in a streaming publish;
+// statements that fail schema validation are rejected individually, while
+// the rest of the chunk is published in a single transaction.
+// base is the index of the first statement of the chunk in the request.
+func (node *Node) doPublishChunk(pub mc.PublisherIdentity, ns string, stmts []interface{}, keys []string, dedup bool, base int) ([]PublishStreamResult, error) {
+	dkeys, err := publishDedupKeys(ns, stmts, keys, dedup)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := node.validateStatements(ns, stmts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]PublishStreamResult, len(stmts))
+	for x := range res {
+		res[x].Index = base + x
+	}
+
+	for _, entry := range report {
+		if res[entry.Index].Error == "" {
+			res[entry.Index].Error = SchemaViolation{entry.Object, entry.Error}.Error()
+		}
+	}
+
+	valid := make([]interface{}, 0, len(stmts))
+	var vkeys []string
+	if dkeys != nil {
+		vkeys = make([]string, 0, len(stmts))
+	}
+
+	for x, body := range stmts {
+		if res[x].Error != "" {
+			continue
+		}
+
+		valid = append(valid, body)
+		if dkeys != nil {
+			vkeys = append(vkeys, dkeys[x])
+		}
+	}
+
+	if len(valid) == 0 {
+		return res, nil
+	}
+
+	sids, err := node.doPublishBatch(pub, ns, valid, vkeys)
+	if err != nil {
+		return nil, err
+	}
+
+	for x := range res {
+		if res[x].Error == "" {
+			res[x].Id = sids[0]
+			sids = sids[1:]
+		}
+	}
+
+	return res, nil
+}
+
+// publishDedupKeys returns the dedup keys for a batch of statement bodies:
+// client supplied keys are used when present, and with dedup the missing
+// keys are computed from the bodies. Returns nil if there are no keys.
+func publishDedupKeys(ns string, stmts []interface{}, keys []string, dedup bool) ([]string, error) {
+	if !dedup {
+		for _, key := range keys {
+			if key != "" {
+				return keys, nil
+			}
+		}
+		return nil, nil
+	}
+
+	dkeys := make([]string, len(stmts))
+	for x, body := range stmts {
+		if keys != nil && keys[x] != "" {
+			dkeys[x] = keys[x]
+			continue
+		}
+
+		key, err := dedupKey(ns, body)
+		if err != nil {
+			return nil, err
+		}
+		dkeys[x] = key
+	}
+
+	return dkeys, nil
+}
+
+// parseIdSelector parses a dotted path selecting the well-known identifier
+// of an object (eg .meta.id); a leading $ is accepted for jsonpath
+// compatibility.
+func parseIdSelector(sel string) ([]string, error) {
+	sel = strings.TrimPrefix(sel, "$")
+	sel = strings.TrimPrefix(sel, ".")
+	if sel == "" {
+		return nil, BadIdSelector
+	}
+
+	path := strings.Split(sel, ".")
+	for _, elt := range path {
+		if elt == "" {
+			return nil, BadIdSelector
+		}
+	}
+
+	return path, nil
+}
+
+func selectObjectId(val interface{}, path []string) (string, error) {
+	for _, elt := range path {
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			return "", NoObjectId
+		}
+
+		val, ok = obj[elt]
+		if !ok {
+			return "", NoObjectId
+		}
+	}
+
+	switch val := val.(type) {
+	case string:
+		return val, nil
+	case json.Number:
+		return string(val), nil
+	default:
+		return "", NoObjectId
+	}
+}
+
+// doPublishObjects stores a batch of objects in the datastore and publishes
+// a statement for each of them in ns.
+// The batch is checked in full before anything is written: objects that
+// can't be encoded, fail schema validation or lack an id when an id
+// selector is given, result in a validation report and nothing is published.
+// The objects are written in a single datastore batch, followed by the
+// statements in a single transaction; if the statement write fails, the
+// stored objects are left unreferenced and eventually removed by GC.
+func (node *Node) doPublishObjects(pub mc.PublisherIdentity, ns string, objs []*PublishObject, code uint64, sel []string, prefix string, dedup bool) ([]PublishResult, []ValidationReport, error) {
+	schema := node.schemas.getSchema(ns)
+
+	var report []ValidationReport
+	datas := make([][]byte, len(objs))
+	keys := make([]Key, len(objs))
+	stmts := make([]interface{}, len(objs))
+	ckeys := make([]string, len(objs)) // client dedup keys
+
+	for x, obj := range objs {
+		data, err := mc.JSONToCBOR(obj.Object)
+		if err != nil {
+			report = append(report, ValidationReport{x, "", err.Error()})
+			continue
+		}
+
+		key, err := mc.HashWith(data, code)
+		if err != nil {
+			return nil, nil, err
+		}
+		key58 := key.B58String()
+
+		if schema != nil {
+			err = validateData(schema, key58, data)
+			if err != nil {
+				report = append(report, ValidationReport{x, key58, err.(SchemaViolation).Reason})
+				continue
+			}
+		}
+
+		refs := obj.Refs
+		if sel != nil {
+			id, err := selectObjectId(obj.Object, sel)
+			if err != nil {
+				report = append(report, ValidationReport{x, key58, err.Error()})
+				continue
+			}
+			refs = append(refs, prefix+id)
+		}
+
+		datas[x] = data
+		keys[x] = Key(key)
+		ckeys[x] = obj.Key
+		stmts[x] = &pb.SimpleStatement{Object: key58, Refs: refs, Tags: obj.Tags, Deps: obj.Deps}
+	}
+
+	if len(report) > 0 {
+		return nil, report, nil
+	}
+
+	keys58 := make([]string, len(keys))
+	for x, key := range keys {
+		keys58[x] = multihash.Multihash(key).B58String()
+	}
+
+	err := node.db.UseObjects(keys58)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = node.ds.PutKeyBatch(keys, datas)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dkeys, err := publishDedupKeys(ns, stmts, ckeys, dedup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sids, err := node.doPublishBatch(pub, ns, stmts, dkeys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := make([]PublishResult, len(sids))
+	for x, sid := range sids {
+		res[x] = PublishResult{sid, multihash.Multihash(keys[x]).B58String()}
+	}
+
+	return res, nil, nil
+}
+
+// doPublishEnvelopes re-publishes statements in ns, wrapped in envelopes of
+// up to clen statements.
+// Statements are grouped by source, as an envelope has the source of the
+// statements it contains; the relative order of statements with the same
+// source is preserved.
+func (node *Node) doPublishEnvelopes(pub mc.PublisherIdentity, ns string, stmts []*pb.Statement, clen int) ([]string, error) {
+	sources := make([]string, 0)
+	groups := make(map[string][]*pb.Statement)
+	for _, stmt := range stmts {
+		src := mcq.StatementSource(stmt)
+		group, ok := groups[src]
+		if !ok {
+			sources = append(sources, src)
+		}
+		groups[src] = append(group, stmt)
+	}
+
+	bodies := make([]interface{}, 0, len(stmts)/clen+len(sources))
+	for _, src := range sources {
+		group := groups[src]
+		for len(group) > 0 {
+			n := clen
+			if n > len(group) {
+				n = len(group)
+			}
+			bodies = append(bodies, &pb.EnvelopeStatement{group[:n]})
+			group = group[n:]
+		}
+	}
+
+	return node.doPublishBatch(pub, ns, bodies, nil)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	mc "github.com/mediachain/concat/mc"
+	pb "github.com/mediachain/concat/proto"
+	"sync"
+)
+
+var (
+	BadValidation = errors.New("Unknown validation mode")
+	NoSchema      = errors.New("No schema for namespace")
+)
+
+// Validation modes for namespace schemas: with publish, the objects of
+// locally published statements are validated against the schema of their
+// namespace; with merge, objects fetched by merges are validated as well.
+// Objects that are already in the local datastore are not revalidated by
+// merges.
+const (
+	ValidatePublish = "publish"
+	ValidateMerge   = "merge"
+)
+
+func checkValidation(mode string) error {
+	switch mode {
+	case ValidatePublish, ValidateMerge:
+		return nil
+	default:
+		return BadValidation
+	}
+}
+
+// SchemaRegistry holds the schemas registered for namespaces
+type SchemaRegistry struct {
+	schemas map[string]NamespaceSchema
+	mx      sync.Mutex
+}
+
+type NamespaceSchema struct {
+	src    json.RawMessage
+	schema *mc.Schema
+}
+
+// SchemaViolation is the validation failure of a data object
+type SchemaViolation struct {
+	Object string
+	Reason string
+}
+
+func (e SchemaViolation) Error() string {
+	return fmt.Sprintf("Schema violation in object %s: %s", e.Object, e.Reason)
+}
+
+// ValidationReport is an entry in the validation report of a publish request;
+// Index is the position of the offending statement in the request.
+type ValidationReport struct {
+	Index  int    `json:"index"`
+	Object string `json:"object"`
+	Error  string `json:"error"`
+}
+
+func (reg *SchemaRegistry) fromJSON(smap map[string]json.RawMessage) error {
+	reg.mx.Lock()
+	defer reg.mx.Unlock()
+
+	reg.schemas = make(map[string]NamespaceSchema)
+	for ns, src := range smap {
+		schema, err := mc.ParseSchema(src)
+		if err != nil {
+			return err
+		}
+
+		reg.schemas[ns] = NamespaceSchema{src, schema}
+	}
+
+	return nil
+}
+
+func (reg *SchemaRegistry) toJSON() map[string]json.RawMessage {
+	reg.mx.Lock()
+	defer reg.mx.Unlock()
+
+	smap := make(map[string]json.RawMessage)
+	for ns, s := range reg.schemas {
+		smap[ns] = s.src
+	}
+
+	return smap
+}
+
+func (reg *SchemaRegistry) getSchema(ns string) *mc.Schema {
+	reg.mx.Lock()
+	defer reg.mx.Unlock()
+	return reg.schemas[ns].schema
+}
+
+func (reg *SchemaRegistry) getSchemaSource(ns string) json.RawMessage {
+	reg.mx.Lock()
+	defer reg.mx.Unlock()
+	return reg.schemas[ns].src
+}
+
+func (reg *SchemaRegistry) setSchema(ns string, src json.RawMessage, schema *mc.Schema) {
+	reg.mx.Lock()
+	if reg.schemas == nil {
+		reg.schemas = make(map[string]NamespaceSchema)
+	}
+	reg.schemas[ns] = NamespaceSchema{src, schema}
+	reg.mx.Unlock()
+}
+
+func (reg *SchemaRegistry) clearSchema(ns string) {
+	reg.mx.Lock()
+	delete(reg.schemas, ns)
+	reg.mx.Unlock()
+}
+
+// validateStatements validates the objects of statement bodies published
+// in ns; returns a report entry for each invalid object.
+func (node *Node) validateStatements(ns string, stmts []interface{}) ([]ValidationReport, error) {
+	schema := node.schemas.getSchema(ns)
+	if schema == nil {
+		return nil, nil
+	}
+
+	var report []ValidationReport
+	validate := func(x int, s *pb.SimpleStatement) error {
+		err := node.validateObject(schema, s.Object)
+		switch err := err.(type) {
+		case nil:
+			return nil
+		case SchemaViolation:
+			report = append(report, ValidationReport{x, err.Object, err.Reason})
+			return nil
+		default:
+			return err
+		}
+	}
+
+	for x, body := range stmts {
+		switch body := body.(type) {
+		case *pb.SimpleStatement:
+			err := validate(x, body)
+			if err != nil {
+				return nil, err
+			}
+
+		case *pb.CompoundStatement:
+			for _, s := range body.Body {
+				err := validate(x, s)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// validateObject validates a datastore object against a schema;
+// invalid or missing objects result in a SchemaViolation.
+func (node *Node) validateObject(schema *mc.Schema, key58 string) error {
+	key, err := mc.ParseKey(key58)
+	if err != nil {
+		return SchemaViolation{key58, err.Error()}
+	}
+
+	data, err := node.ds.Get(Key(key))
+	if err != nil {
+		return err
+	}
+
+	if data == nil {
+		return SchemaViolation{key58, MissingData.Error()}
+	}
+
+	return validateData(schema, key58, data)
+}
+
+func validateData(schema *mc.Schema, key58 string, data []byte) error {
+	val, err := mc.CBORToJSON(data)
+	if err != nil {
+		return SchemaViolation{key58, err.Error()}
+	}
+
+	err = schema.Validate(val)
+	if err != nil {
+		return SchemaViolation{key58, err.Error()}
+	}
+
+	return nil
+}
+
+// mergeStatementSchemas records the schemas for validating the objects of
+// a statement received for merge, when merge validation is enabled, together
+// with the statement, which is deleted if an object is rejected.
+func (node *Node) mergeStatementSchemas(stmt *pb.Statement, keys MergeKeys) {
+	if node.validate != ValidateMerge {
+		return
+	}
+
+	node.mergeStatementSchemasImpl(stmt.Id, stmt, keys)
+}
+
+func (node *Node) mergeStatementSchemasImpl(id string, stmt *pb.Statement, keys MergeKeys) {
+	switch body := stmt.Body.Body.(type) {
+	case *pb.StatementBody_Simple:
+		node.mergeObjectSchema(id, stmt.Namespace, body.Simple.Object, keys)
+
+	case *pb.StatementBody_Compound:
+		for _, s := range body.Compound.Body {
+			node.mergeObjectSchema(id, stmt.Namespace, s.Object, keys)
+		}
+
+	case *pb.StatementBody_Envelope:
+		// envelopes are stored as a whole, so the envelope is deleted
+		for _, xstmt := range body.Envelope.Body {
+			node.mergeStatementSchemasImpl(id, xstmt, keys)
+		}
+	}
+}
+
+func (node *Node) mergeObjectSchema(id string, ns string, key58 string, keys MergeKeys) {
+	schema := node.schemas.getSchema(ns)
+	if schema != nil {
+		key58 = mc.CanonicalKey(key58)
+		keys.schema[key58] = schema
+		keys.stmts[key58] = append(keys.stmts[key58], id)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	mc "github.com/mediachain/concat/mc"
+	mcq "github.com/mediachain/concat/mc/query"
+	pb "github.com/mediachain/concat/proto"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	BadSuccession     = errors.New("Bad succession statement; verification failed")
+	ExternalPublisher = errors.New("Publisher key is held by an external signer")
+)
+
+// Publisher key successions are published as statements in the succession
+// namespace: the statement is published by the predecessor key, and carries
+// the signature of the successor key over the statement (without signatures).
+// Both signatures are checked when succession statements are merged.
+const SuccessionNamespace = "mediachain.succession"
+
+// PublisherSuccession is the succession graph of publisher keys.
+// A key can have only one successor and one predecessor. Conflicting
+// successions (eg a succession published with a compromised key, together
+// with the succession published by the key owner) are not followed by
+// either key; they are reported as conflicts, for the publishers to
+// resolve out of band.
+type PublisherSuccession struct {
+	next      map[string]string
+	prev      map[string]string
+	stmts     map[successionEdge]string
+	conflicts []SuccessionConflict
+}
+
+type successionEdge struct {
+	pub  string
+	succ string
+}
+
+// SuccessionConflict is a key with multiple successors or predecessors,
+// with the ids of the conflicting succession statements.
+type SuccessionConflict struct {
+	Key        string   `json:"key"`
+	Statements []string `json:"statements"`
+}
+
+// Chain returns the succession chain of a publisher, from the first
+// predecessor to the last successor; a publisher without successions is a
+// chain of its own.
+func (ps *PublisherSuccession) Chain(pub string) []string {
+	root := ps.Root(pub)
+
+	seen := map[string]bool{root: true}
+	chain := []string{root}
+	for cur := root; ; {
+		next, ok := ps.next[cur]
+		if !ok || seen[next] {
+			break
+		}
+		seen[next] = true
+		chain = append(chain, next)
+		cur = next
+	}
+
+	return chain
+}
+
+// Root returns the first predecessor in the succession chain of a publisher
+func (ps *PublisherSuccession) Root(pub string) string {
+	// the seen set guards against cycles
+	seen := map[string]bool{pub: true}
+	root := pub
+	for {
+		prev, ok := ps.prev[root]
+		if !ok || seen[prev] {
+			break
+		}
+		seen[prev] = true
+		root = prev
+	}
+
+	return root
+}
+
+// Successors returns the successors of a publisher in all successions,
+// including conflicting ones.
+func (ps *PublisherSuccession) Successors(pub string) []string {
+	var succs []string
+	for edge, _ := range ps.stmts {
+		if edge.pub == pub {
+			succs = append(succs, edge.succ)
+		}
+	}
+	sort.Strings(succs)
+	return succs
+}
+
+// Statement returns the id of the succession statement from pub to succ
+func (ps *PublisherSuccession) Statement(pub, succ string) (string, bool) {
+	id, ok := ps.stmts[successionEdge{pub, succ}]
+	return id, ok
+}
+
+func (ps *PublisherSuccession) Conflicts() []SuccessionConflict {
+	return ps.conflicts
+}
+
+func (node *Node) loadSuccession() (*PublisherSuccession, error) {
+	q, err := mcq.ParseQuery("SELECT * FROM " + SuccessionNamespace + " ORDER BY counter")
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := node.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := &PublisherSuccession{
+		next:  make(map[string]string),
+		prev:  make(map[string]string),
+		stmts: make(map[successionEdge]string),
+	}
+
+	// distinct successors and predecessors of each key, in statement order;
+	// the same succession published more than once is not a conflict.
+	succs := make(map[string][]string)
+	preds := make(map[string][]string)
+	for _, val := range res {
+		stmt, ok := val.(*pb.Statement)
+		if !ok {
+			return nil, BadResult
+		}
+
+		succ := stmt.Body.GetSuccession()
+		if succ == nil {
+			continue
+		}
+
+		edge := successionEdge{stmt.Publisher, succ.Successor}
+		_, have := ps.stmts[edge]
+		if have {
+			continue
+		}
+
+		ps.stmts[edge] = stmt.Id
+		succs[edge.pub] = append(succs[edge.pub], edge.succ)
+		preds[edge.succ] = append(preds[edge.succ], edge.pub)
+	}
+
+	conflicts := make(map[string][]string)
+	for pub, xsuccs := range succs {
+		if len(xsuccs) > 1 {
+			for _, succ := range xsuccs {
+				conflicts[pub] = append(conflicts[pub], ps.stmts[successionEdge{pub, succ}])
+			}
+			continue
+		}
+
+		succ := xsuccs[0]
+		if len(preds[succ]) > 1 {
+			continue
+		}
+
+		ps.next[pub] = succ
+		ps.prev[succ] = pub
+	}
+
+	for succ, xpreds := range preds {
+		if len(xpreds) > 1 {
+			for _, pub := range xpreds {
+				conflicts[succ] = append(conflicts[succ], ps.stmts[successionEdge{pub, succ}])
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(conflicts))
+	for key, _ := range conflicts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		ps.conflicts = append(ps.conflicts, SuccessionConflict{key, conflicts[key]})
+	}
+
+	return ps, nil
+}
+
+// doRotatePublisher replaces the key of a publisher identity with a new key,
+// and publishes the succession statement; returns the new identity and the
+// id of the succession statement.
+// The new key is staged before the succession is published, and installed
+// after; if the publication fails, the rotation is aborted and the current
+// key is kept. If installing the key fails, retrying the rotation completes
+// it with the staged key.
+// A key that already has a succession published elsewhere (eg with a
+// compromised copy of the key) can still be rotated; the successions
+// conflict, and neither is followed.
+func (node *Node) doRotatePublisher(name string) (empty mc.PublisherIdentity, sid string, err error) {
+	node.pubmx.Lock()
+	defer node.pubmx.Unlock()
+
+	var prev mc.PublisherIdentity
+	if name == "" || name == DefaultPublisher {
+		name = DefaultPublisher
+		prev = node.publisher
+	} else {
+		pub, ok := node.pubids[name]
+		if !ok {
+			return empty, "", UnknownPublisher
+		}
+		prev = pub
+	}
+
+	switch {
+	case prev.Locked():
+		return empty, "", NodeLocked
+	case prev.PrivKey == nil:
+		return empty, "", ExternalPublisher
+	}
+
+	ps, err := node.loadSuccession()
+	if err != nil {
+		return empty, "", err
+	}
+
+	kpath := node.publisherKeyPath(name)
+	rdir := path.Join(node.home, "retired")
+
+	pending, err := mc.PendingPublisherRotation(kpath, node.passphrase)
+	switch {
+	case err == nil:
+		sid, ok := ps.Statement(prev.ID58, pending.ID58)
+		if ok {
+			// the succession was published; complete the rotation
+			err = mc.CommitPublisherRotation(kpath, rdir, prev, node.passphrase)
+			if err != nil {
+				return empty, "", err
+			}
+
+			node.setPublisher(name, pending)
+			return pending, sid, nil
+		}
+
+		// left over from a rotation that failed to publish
+		err = mc.AbortPublisherRotation(kpath)
+		if err != nil {
+			return empty, "", err
+		}
+
+	case !os.IsNotExist(err):
+		return empty, "", err
+	}
+
+	succs := ps.Successors(prev.ID58)
+	if len(succs) > 0 {
+		log.Printf("Warning: publisher %s already has successions to %s; the rotation conflicts with them", prev.ID58, strings.Join(succs, ", "))
+	}
+
+	next, err := mc.NewPublisherIdentity()
+	if err != nil {
+		return empty, "", err
+	}
+
+	stmt, err := node.makeSuccessionStatement(prev, next)
+	if err != nil {
+		return empty, "", err
+	}
+
+	err = mc.StagePublisherRotation(kpath, next, node.passphrase)
+	if err != nil {
+		return empty, "", err
+	}
+
+	err = node.db.Put(stmt)
+	if err != nil {
+		mc.AbortPublisherRotation(kpath)
+		return empty, "", err
+	}
+
+	err = mc.CommitPublisherRotation(kpath, rdir, prev, node.passphrase)
+	if err != nil {
+		log.Printf("Error installing the key of succession %s -> %s: %s", prev.ID58, next.ID58, err.Error())
+		return empty, "", err
+	}
+
+	node.setPublisher(name, next)
+	return next, stmt.Id, nil
+}
+
+// setPublisher replaces a publisher identity; must be called with pubmx held
+func (node *Node) setPublisher(name string, pub mc.PublisherIdentity) {
+	if name == DefaultPublisher {
+		node.publisher = pub
+	} else {
+		node.pubids[name] = pub
+	}
+}
+
+func (node *Node) publisherKeyPath(name string) string {
+	if name == DefaultPublisher {
+		return path.Join(node.home, "identity.publisher")
+	}
+	return path.Join(node.home, "publishers", name+".publisher")
+}
+
+// makeSuccessionStatement creates a succession statement from prev to next;
+// the successor signs the statement first, and then the predecessor signs
+// the statement together with the successor signature.
+func (node *Node) makeSuccessionStatement(prev, next mc.PublisherIdentity) (*pb.Statement, error) {
+	body := &pb.SuccessionStatement{Successor: next.ID58}
+	stmt, err := node.newStatement(prev, SuccessionNamespace, body)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := mc.StatementSigPayload(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := next.Sign(bytes)
+	if err != nil {
+		return nil, err
+	}
+	body.Signature = sig
+
+	err = node.signStatement(prev, stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+// verifySuccession checks the successor signature of a succession statement,
+// whose publisher signature has already been verified.
+func (node *Node) verifySuccession(stmt *pb.Statement) (bool, error) {
+	succ := stmt.Body.GetSuccession()
+	if succ == nil || stmt.Namespace != SuccessionNamespace {
+		return false, nil
+	}
+
+	if succ.Successor == "" || succ.Successor == stmt.Publisher || succ.Signature == nil {
+		return false, nil
+	}
+
+	pubk, err := mc.PublisherKey(succ.Successor)
+	if err != nil {
+		return false, err
+	}
+
+	ssig := succ.Signature
+	succ.Signature = nil
+	bytes, err := mc.StatementSigPayload(stmt)
+	succ.Signature = ssig
+
+	if err != nil {
+		return false, err
+	}
+
+	return pubk.Verify(bytes, ssig)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	p2p_crypto "github.com/libp2p/go-libp2p-crypto"
+	mc "github.com/mediachain/concat/mc"
+	pb "github.com/mediachain/concat/proto"
+	"runtime"
+)
+
+// Merge verification: statements received in a merge are verified in
+// chunks by a pool of workers, and delivered to the merge in stream order,
+// so that MergeBatch sees the statements in the order they were received.
+// A chunk is dispatched when it is full, or when the stream has no value
+// immediately available, so that slow streams are not delayed; chunks fill
+// up when verification is the bottleneck.
+const verifyChunk = 256
+
+// batchVerifier is implemented by public keys that can verify multiple
+// signatures at once (eg Ed25519 batch verification); signatures by keys
+// that don't are verified one at a time.
+// Note: the Ed25519 keys of the vendored libp2p-crypto don't implement it;
+// their raw bytes can be extracted from the marshalled key, but the
+// underlying ed25519 package (agl/ed25519) has no batch verification, so
+// there is nothing to verify them with until libp2p-crypto provides it.
+type batchVerifier interface {
+	VerifyBatch(data [][]byte, sigs [][]byte) (bool, error)
+}
+
+// verifyJob is a chunk of statements to verify, or a stream value to pass
+// through in order.
+type verifyJob struct {
+	stmts []*pb.Statement
+	val   interface{}
+	res   chan error
+}
+
+// verifyError is a statement verification failure in a verified stream
+type verifyError struct {
+	err error
+}
+
+// verifyMergeStream verifies the statements of a merge stream in a pool of
+// workers; the output stream has the values of the input stream in order,
+// with a verifyError in place of the first chunk that fails verification.
+// The pipeline stops when the context is cancelled.
+func (node *Node) verifyMergeStream(ctx context.Context, ch <-chan interface{}) <-chan interface{} {
+	workers := runtime.NumCPU()
+	jobs := make(chan verifyJob, workers)
+	pending := make(chan verifyJob, 2*workers)
+	out := make(chan interface{}, verifyChunk)
+
+	for x := 0; x < workers; x++ {
+		go node.verifyMergeWorker(jobs)
+	}
+
+	go node.verifyMergeDispatch(ctx, ch, jobs, pending)
+	go node.verifyMergeCollect(ctx, pending, out)
+
+	return out
+}
+
+func (node *Node) verifyMergeWorker(jobs <-chan verifyJob) {
+	// publisher key cache
+	pkcache := make(map[string]p2p_crypto.PubKey)
+
+	for job := range jobs {
+		job.res <- node.verifyMergeBatch(job.stmts, pkcache)
+	}
+}
+
+// verifyMergeDispatch reads the input stream and dispatches chunks to the
+// workers; jobs are queued in pending in stream order.
+func (node *Node) verifyMergeDispatch(ctx context.Context, ch <-chan interface{}, jobs chan<- verifyJob, pending chan<- verifyJob) {
+	defer close(pending)
+	defer close(jobs)
+
+	stmts := make([]*pb.Statement, 0, verifyChunk)
+
+	queue := func(job verifyJob) bool {
+		select {
+		case pending <- job:
+		case <-ctx.Done():
+			return false
+		}
+
+		if job.stmts == nil {
+			return true
+		}
+
+		select {
+		case jobs <- job:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	flush := func() bool {
+		if len(stmts) == 0 {
+			return true
+		}
+
+		job := verifyJob{stmts: stmts, res: make(chan error, 1)}
+		stmts = make([]*pb.Statement, 0, verifyChunk)
+		return queue(job)
+	}
+
+	for {
+		var val interface{}
+		var ok bool
+		if len(stmts) == 0 {
+			select {
+			case val, ok = <-ch:
+			case <-ctx.Done():
+				return
+			}
+		} else {
+			select {
+			case val, ok = <-ch:
+			default:
+				// no value immediately available; dispatch the partial chunk
+				if !flush() {
+					return
+				}
+				continue
+			}
+		}
+
+		if !ok {
+			break
+		}
+
+		stmt, ok := val.(*pb.Statement)
+		if !ok {
+			if !flush() || !queue(verifyJob{val: val}) {
+				return
+			}
+			continue
+		}
+
+		stmts = append(stmts, stmt)
+		if len(stmts) >= verifyChunk {
+			if !flush() {
+				return
+			}
+		}
+	}
+
+	flush()
+}
+
+// verifyMergeCollect delivers the verified statements in stream order
+func (node *Node) verifyMergeCollect(ctx context.Context, pending <-chan verifyJob, out chan<- interface{}) {
+	defer close(out)
+
+	send := func(val interface{}) bool {
+		select {
+		case out <- val:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for job := range pending {
+		if job.stmts == nil {
+			if !send(job.val) {
+				return
+			}
+			continue
+		}
+
+		var err error
+		select {
+		case err = <-job.res:
+		case <-ctx.Done():
+			return
+		}
+
+		if err != nil {
+			send(verifyError{err})
+			return
+		}
+
+		for _, stmt := range job.stmts {
+			if !send(stmt) {
+				return
+			}
+		}
+	}
+}
+
+// verifyMergeBatch verifies a chunk of statements received for merge.
+// Signatures by keys that support batch verification are verified together
+// per publisher; the rest are verified with verifyMergeStatement.
+func (node *Node) verifyMergeBatch(stmts []*pb.Statement, pkcache map[string]p2p_crypto.PubKey) error {
+	type sigBatch struct {
+		pubk batchVerifier
+		data [][]byte
+		sigs [][]byte
+	}
+	batches := make(map[string]*sigBatch)
+
+	for _, stmt := range stmts {
+		if !node.checkStatement(stmt) {
+			return BadStatement
+		}
+
+		pubk, err := cachedPublisherKey(stmt.Publisher, pkcache)
+		if err != nil {
+			return err
+		}
+
+		bpubk, ok := pubk.(batchVerifier)
+		if !ok || stmt.Body.GetSuccession() != nil {
+			err = node.verifyMergeStatement(stmt, pkcache)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		bytes, err := mc.StatementSigPayload(stmt)
+		switch {
+		case err == mc.BadSigVersion:
+			return BadStatement
+		case err != nil:
+			return err
+		}
+
+		batch, ok := batches[stmt.Publisher]
+		if !ok {
+			batch = &sigBatch{pubk: bpubk}
+			batches[stmt.Publisher] = batch
+		}
+		batch.data = append(batch.data, bytes)
+		batch.sigs = append(batch.sigs, stmt.Signature)
+	}
+
+	for _, batch := range batches {
+		verify, err := batch.pubk.VerifyBatch(batch.data, batch.sigs)
+		if err != nil {
+			return err
+		}
+
+		if !verify {
+			return BadStatement
+		}
+	}
+
+	return nil
+}
@@ -1011,6 +1011,190 @@ func (m *PushEnd) String() string            { return proto1.CompactTextString(m
 func (*PushEnd) ProtoMessage()               {}
 func (*PushEnd) Descriptor() ([]byte, []int) { return fileDescriptorNode, []int{20} }
 
+// archive format for /export and /import
+type ArchiveEntry struct {
+	// Types that are valid to be assigned to Entry:
+	//	*ArchiveEntry_Stmt
+	//	*ArchiveEntry_Data
+	//	*ArchiveEntry_End
+	//	*ArchiveEntry_Error
+	Entry isArchiveEntry_Entry `protobuf_oneof:"entry"`
+}
+
+func (m *ArchiveEntry) Reset()                    { *m = ArchiveEntry{} }
+func (m *ArchiveEntry) String() string            { return proto1.CompactTextString(m) }
+func (*ArchiveEntry) ProtoMessage()               {}
+func (*ArchiveEntry) Descriptor() ([]byte, []int) { return fileDescriptorNode, []int{21} }
+
+type isArchiveEntry_Entry interface {
+	isArchiveEntry_Entry()
+}
+
+type ArchiveEntry_Stmt struct {
+	Stmt *Statement `protobuf:"bytes,1,opt,name=stmt,oneof"`
+}
+type ArchiveEntry_Data struct {
+	Data *DataObject `protobuf:"bytes,2,opt,name=data,oneof"`
+}
+type ArchiveEntry_End struct {
+	End *StreamEnd `protobuf:"bytes,3,opt,name=end,oneof"`
+}
+type ArchiveEntry_Error struct {
+	Error *StreamError `protobuf:"bytes,4,opt,name=error,oneof"`
+}
+
+func (*ArchiveEntry_Stmt) isArchiveEntry_Entry()  {}
+func (*ArchiveEntry_Data) isArchiveEntry_Entry()  {}
+func (*ArchiveEntry_End) isArchiveEntry_Entry()   {}
+func (*ArchiveEntry_Error) isArchiveEntry_Entry() {}
+
+func (m *ArchiveEntry) GetEntry() isArchiveEntry_Entry {
+	if m != nil {
+		return m.Entry
+	}
+	return nil
+}
+
+func (m *ArchiveEntry) GetStmt() *Statement {
+	if x, ok := m.GetEntry().(*ArchiveEntry_Stmt); ok {
+		return x.Stmt
+	}
+	return nil
+}
+
+func (m *ArchiveEntry) GetData() *DataObject {
+	if x, ok := m.GetEntry().(*ArchiveEntry_Data); ok {
+		return x.Data
+	}
+	return nil
+}
+
+func (m *ArchiveEntry) GetEnd() *StreamEnd {
+	if x, ok := m.GetEntry().(*ArchiveEntry_End); ok {
+		return x.End
+	}
+	return nil
+}
+
+func (m *ArchiveEntry) GetError() *StreamError {
+	if x, ok := m.GetEntry().(*ArchiveEntry_Error); ok {
+		return x.Error
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*ArchiveEntry) XXX_OneofFuncs() (func(msg proto1.Message, b *proto1.Buffer) error, func(msg proto1.Message, tag, wire int, b *proto1.Buffer) (bool, error), func(msg proto1.Message) (n int), []interface{}) {
+	return _ArchiveEntry_OneofMarshaler, _ArchiveEntry_OneofUnmarshaler, _ArchiveEntry_OneofSizer, []interface{}{
+		(*ArchiveEntry_Stmt)(nil),
+		(*ArchiveEntry_Data)(nil),
+		(*ArchiveEntry_End)(nil),
+		(*ArchiveEntry_Error)(nil),
+	}
+}
+
+func _ArchiveEntry_OneofMarshaler(msg proto1.Message, b *proto1.Buffer) error {
+	m := msg.(*ArchiveEntry)
+	// entry
+	switch x := m.Entry.(type) {
+	case *ArchiveEntry_Stmt:
+		_ = b.EncodeVarint(1<<3 | proto1.WireBytes)
+		if err := b.EncodeMessage(x.Stmt); err != nil {
+			return err
+		}
+	case *ArchiveEntry_Data:
+		_ = b.EncodeVarint(2<<3 | proto1.WireBytes)
+		if err := b.EncodeMessage(x.Data); err != nil {
+			return err
+		}
+	case *ArchiveEntry_End:
+		_ = b.EncodeVarint(3<<3 | proto1.WireBytes)
+		if err := b.EncodeMessage(x.End); err != nil {
+			return err
+		}
+	case *ArchiveEntry_Error:
+		_ = b.EncodeVarint(4<<3 | proto1.WireBytes)
+		if err := b.EncodeMessage(x.Error); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		return fmt.Errorf("ArchiveEntry.Entry has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _ArchiveEntry_OneofUnmarshaler(msg proto1.Message, tag, wire int, b *proto1.Buffer) (bool, error) {
+	m := msg.(*ArchiveEntry)
+	switch tag {
+	case 1: // entry.stmt
+		if wire != proto1.WireBytes {
+			return true, proto1.ErrInternalBadWireType
+		}
+		msg := new(Statement)
+		err := b.DecodeMessage(msg)
+		m.Entry = &ArchiveEntry_Stmt{msg}
+		return true, err
+	case 2: // entry.data
+		if wire != proto1.WireBytes {
+			return true, proto1.ErrInternalBadWireType
+		}
+		msg := new(DataObject)
+		err := b.DecodeMessage(msg)
+		m.Entry = &ArchiveEntry_Data{msg}
+		return true, err
+	case 3: // entry.end
+		if wire != proto1.WireBytes {
+			return true, proto1.ErrInternalBadWireType
+		}
+		msg := new(StreamEnd)
+		err := b.DecodeMessage(msg)
+		m.Entry = &ArchiveEntry_End{msg}
+		return true, err
+	case 4: // entry.error
+		if wire != proto1.WireBytes {
+			return true, proto1.ErrInternalBadWireType
+		}
+		msg := new(StreamError)
+		err := b.DecodeMessage(msg)
+		m.Entry = &ArchiveEntry_Error{msg}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+func _ArchiveEntry_OneofSizer(msg proto1.Message) (n int) {
+	m := msg.(*ArchiveEntry)
+	// entry
+	switch x := m.Entry.(type) {
+	case *ArchiveEntry_Stmt:
+		s := proto1.Size(x.Stmt)
+		n += proto1.SizeVarint(1<<3 | proto1.WireBytes)
+		n += proto1.SizeVarint(uint64(s))
+		n += s
+	case *ArchiveEntry_Data:
+		s := proto1.Size(x.Data)
+		n += proto1.SizeVarint(2<<3 | proto1.WireBytes)
+		n += proto1.SizeVarint(uint64(s))
+		n += s
+	case *ArchiveEntry_End:
+		s := proto1.Size(x.End)
+		n += proto1.SizeVarint(3<<3 | proto1.WireBytes)
+		n += proto1.SizeVarint(uint64(s))
+		n += s
+	case *ArchiveEntry_Error:
+		s := proto1.Size(x.Error)
+		n += proto1.SizeVarint(4<<3 | proto1.WireBytes)
+		n += proto1.SizeVarint(uint64(s))
+		n += s
+	case nil:
+	default:
+		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
+	}
+	return n
+}
+
 func init() {
 	proto1.RegisterType((*StreamEnd)(nil), "proto.StreamEnd")
 	proto1.RegisterType((*StreamError)(nil), "proto.StreamError")
@@ -1033,50 +1217,53 @@ func init() {
 	proto1.RegisterType((*PushReject)(nil), "proto.PushReject")
 	proto1.RegisterType((*PushValue)(nil), "proto.PushValue")
 	proto1.RegisterType((*PushEnd)(nil), "proto.PushEnd")
+	proto1.RegisterType((*ArchiveEntry)(nil), "proto.ArchiveEntry")
 }
 
 func init() { proto1.RegisterFile("node.proto", fileDescriptorNode) }
 
 var fileDescriptorNode = []byte{
-	// 628 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xb4, 0x94, 0xcf, 0x6e, 0xd3, 0x4e,
-	0x10, 0xc7, 0xe3, 0xda, 0x4d, 0x93, 0x71, 0x7e, 0xfa, 0xb5, 0x4b, 0x25, 0x22, 0x54, 0xa1, 0xb2,
-	0x54, 0xb4, 0xe2, 0x4f, 0x91, 0xc2, 0x85, 0x2b, 0x81, 0x4a, 0x01, 0x24, 0x08, 0x46, 0x42, 0xe2,
-	0xe8, 0xc4, 0xd3, 0xd4, 0x34, 0xd9, 0x75, 0xbd, 0x36, 0x52, 0x0e, 0x3c, 0x04, 0x57, 0x9e, 0x83,
-	0x07, 0x44, 0x33, 0xbb, 0x9b, 0x38, 0x15, 0x15, 0x5c, 0x38, 0x79, 0x67, 0xe6, 0xb3, 0x33, 0xdf,
-	0x99, 0x9d, 0x04, 0x40, 0xe9, 0x0c, 0x4f, 0x8b, 0x52, 0x57, 0x5a, 0x6c, 0xf3, 0xe7, 0x0e, 0x98,
-	0x6a, 0x51, 0x59, 0x97, 0x8c, 0xa1, 0xfb, 0xb1, 0x2a, 0x31, 0x5d, 0x9c, 0xa9, 0x4c, 0xde, 0x87,
-	0xd8, 0x19, 0x65, 0xa9, 0x4b, 0xb1, 0x0f, 0xdb, 0x48, 0x87, 0x7e, 0x70, 0x18, 0x9c, 0x74, 0x13,
-	0x6b, 0xc8, 0x3d, 0xf8, 0xff, 0x9d, 0xce, 0xf0, 0xb5, 0x3a, 0xd7, 0x09, 0x5e, 0xd5, 0x68, 0x2a,
-	0x39, 0x86, 0x8e, 0x77, 0x09, 0x01, 0x51, 0x81, 0xe8, 0xef, 0xf0, 0x59, 0x1c, 0x40, 0xb7, 0xa8,
-	0x27, 0xf3, 0xdc, 0x5c, 0x60, 0xd9, 0xdf, 0xe2, 0xc0, 0xda, 0x41, 0x37, 0x72, 0x75, 0xae, 0xfb,
-	0xa1, 0xbd, 0x41, 0x67, 0xd9, 0x86, 0x68, 0x9c, 0xab, 0x19, 0x7f, 0xb5, 0x9a, 0xc9, 0x23, 0xe8,
-	0x7d, 0xa8, 0xb1, 0x5c, 0xba, 0x8a, 0x24, 0xed, 0x8a, 0x6c, 0x2f, 0x8d, 0x0d, 0xf9, 0x23, 0x80,
-	0xd8, 0x61, 0xa6, 0x9e, 0x57, 0xe2, 0x29, 0x6c, 0x7f, 0x4d, 0xe7, 0x35, 0x32, 0x15, 0x0f, 0x6e,
-	0xdb, 0x9e, 0x4f, 0x1b, 0xc8, 0x27, 0x0a, 0x8f, 0x5a, 0x89, 0xe5, 0xc4, 0x11, 0x84, 0xa8, 0x32,
-	0x96, 0x18, 0x0f, 0x76, 0x1d, 0xbe, 0x9a, 0xcf, 0xa8, 0x95, 0x50, 0x58, 0x3c, 0xf4, 0x73, 0x09,
-	0x99, 0x13, 0x9b, 0x1c, 0x45, 0x28, 0x23, 0x23, 0xc3, 0x0e, 0xb4, 0x4b, 0xae, 0x24, 0xbf, 0xc1,
-	0xee, 0xf5, 0xc2, 0xe2, 0x31, 0xb4, 0x4d, 0xbe, 0x28, 0xe6, 0x5e, 0xe1, 0x2a, 0x15, 0x3b, 0xbd,
-	0x38, 0xc7, 0x88, 0x01, 0x74, 0xa6, 0x7a, 0x51, 0xe8, 0x7a, 0x25, 0x71, 0xdf, 0xf1, 0x2f, 0x9d,
-	0xdb, 0xdf, 0x58, 0x71, 0xc3, 0x1d, 0x37, 0x02, 0xf9, 0x33, 0x80, 0xb8, 0x91, 0x56, 0x1c, 0x40,
-	0x27, 0x57, 0x56, 0x06, 0x17, 0x0f, 0xe9, 0x9a, 0xf7, 0x08, 0x09, 0xb1, 0xa9, 0xca, 0x5c, 0xcd,
-	0x2c, 0xc0, 0x6f, 0x36, 0x6a, 0x25, 0x4d, 0xa7, 0x78, 0x00, 0x11, 0x2d, 0x92, 0x9b, 0xc2, 0x7a,
-	0x5a, 0x69, 0x85, 0x0b, 0x54, 0xd5, 0xa8, 0x95, 0x70, 0x9c, 0x64, 0xd3, 0x77, 0xa8, 0xb3, 0x65,
-	0x3f, 0xda, 0x90, 0xbd, 0x62, 0x29, 0x46, 0xf5, 0x3d, 0xb7, 0x96, 0xfd, 0x1c, 0xfe, 0xdb, 0x68,
-	0x4e, 0x1c, 0x43, 0x34, 0xa1, 0x4c, 0xc1, 0x61, 0x78, 0x12, 0x0f, 0x6e, 0xb9, 0x4c, 0x6f, 0x71,
-	0xc9, 0xe1, 0x71, 0x9a, 0x97, 0x09, 0x03, 0xf2, 0x0d, 0xf4, 0x9a, 0x5e, 0xb1, 0x0b, 0xe1, 0x25,
-	0xfa, 0x85, 0xa1, 0xa3, 0x38, 0xf1, 0xeb, 0xb1, 0x75, 0xd3, 0xf0, 0xdd, 0x5e, 0xc8, 0x7b, 0x10,
-	0xbf, 0x4a, 0xab, 0xd4, 0x6f, 0x9f, 0x80, 0xe8, 0x12, 0x97, 0x86, 0x35, 0x74, 0x13, 0x3e, 0xcb,
-	0xef, 0x01, 0x80, 0x65, 0x78, 0xf5, 0x8e, 0x21, 0xca, 0xd2, 0x2a, 0x75, 0xef, 0xba, 0xe7, 0x52,
-	0x13, 0xf0, 0x7e, 0xf2, 0x05, 0xa7, 0x3c, 0x1d, 0x02, 0xfe, 0xe9, 0xca, 0x0d, 0xac, 0x24, 0x5b,
-	0xf1, 0x37, 0x03, 0x10, 0x4e, 0x24, 0x15, 0xef, 0x59, 0x3d, 0xf2, 0x09, 0xc4, 0xe3, 0xda, 0x5c,
-	0xf8, 0x56, 0xef, 0x02, 0xa8, 0x74, 0x81, 0xa6, 0x48, 0xa7, 0xe8, 0x1b, 0x6e, 0x78, 0x64, 0x01,
-	0x3d, 0x8b, 0x9b, 0x42, 0x2b, 0x83, 0xe2, 0x11, 0xb4, 0xd3, 0xe9, 0x14, 0x8b, 0xea, 0x5a, 0xe7,
-	0x04, 0xbd, 0xe0, 0x00, 0x2d, 0xb4, 0x45, 0x08, 0x2e, 0x91, 0xb4, 0xb9, 0xf6, 0x9b, 0x70, 0x82,
-	0x6e, 0x4c, 0x0e, 0x19, 0xb6, 0xed, 0xc3, 0xcb, 0x1e, 0xc0, 0x3a, 0x99, 0x94, 0xd6, 0xb2, 0xf4,
-	0x0d, 0xff, 0x58, 0x13, 0xe8, 0x12, 0xb3, 0xb9, 0xb5, 0xc1, 0x1f, 0xb6, 0xf6, 0xaf, 0xde, 0x65,
-	0xbd, 0xa7, 0x9f, 0x61, 0x87, 0x6a, 0x9c, 0xa9, 0x8c, 0x46, 0x66, 0x7c, 0x3a, 0x63, 0x7f, 0x5b,
-	0x49, 0xc3, 0x23, 0xfa, 0xb0, 0xa3, 0xf9, 0x45, 0x0c, 0x67, 0x0f, 0x13, 0x6f, 0xae, 0xe5, 0x87,
-	0x0d, 0xf9, 0x93, 0x36, 0xd7, 0x7e, 0xf6, 0x2b, 0x00, 0x00, 0xff, 0xff, 0x49, 0x9c, 0x1f, 0xdf,
-	0xca, 0x05, 0x00, 0x00,
+	// 660 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xb4, 0x94, 0x4d, 0x6f, 0xd3, 0x4c,
+	0x10, 0xc7, 0xe3, 0xda, 0x79, 0x1b, 0xe7, 0xd1, 0xd3, 0x2e, 0x95, 0xb0, 0x50, 0x85, 0xca, 0x52,
+	0xd1, 0x8a, 0x97, 0x22, 0x85, 0x0b, 0xd7, 0x06, 0x2a, 0x05, 0x90, 0x20, 0x18, 0x09, 0x89, 0xa3,
+	0x13, 0x4f, 0x5b, 0xd3, 0x64, 0xed, 0x7a, 0xd7, 0x95, 0x72, 0xe0, 0x43, 0x70, 0xe5, 0x73, 0x70,
+	0xe2, 0xd3, 0xa1, 0xd9, 0x97, 0xc4, 0x09, 0x54, 0xcd, 0x85, 0x93, 0x77, 0x67, 0x7e, 0x3b, 0xf3,
+	0xdf, 0xd9, 0x7f, 0x02, 0x20, 0xf2, 0x14, 0x8f, 0x8b, 0x32, 0x57, 0x39, 0x6b, 0xea, 0xcf, 0x3d,
+	0x90, 0x6a, 0xa6, 0x4c, 0x88, 0x87, 0xd0, 0xfd, 0xa4, 0x4a, 0x4c, 0x66, 0xa7, 0x22, 0xe5, 0x0f,
+	0x21, 0xb4, 0x9b, 0xb2, 0xcc, 0x4b, 0xb6, 0x0b, 0x4d, 0xa4, 0x45, 0xe4, 0xed, 0x7b, 0x47, 0xdd,
+	0xd8, 0x6c, 0xf8, 0x0e, 0xfc, 0xff, 0x3e, 0x4f, 0xf1, 0x8d, 0x38, 0xcb, 0x63, 0xbc, 0xaa, 0x50,
+	0x2a, 0x3e, 0x82, 0x8e, 0x0b, 0x31, 0x06, 0x41, 0x81, 0xe8, 0xce, 0xe8, 0x35, 0xdb, 0x83, 0x6e,
+	0x51, 0x8d, 0xa7, 0x99, 0xbc, 0xc0, 0x32, 0xda, 0xd2, 0x89, 0x65, 0x80, 0x4e, 0x64, 0xe2, 0x2c,
+	0x8f, 0x7c, 0x73, 0x82, 0xd6, 0xbc, 0x05, 0xc1, 0x28, 0x13, 0xe7, 0xfa, 0x9b, 0x8b, 0x73, 0x7e,
+	0x00, 0xbd, 0x8f, 0x15, 0x96, 0x73, 0xdb, 0x91, 0xa4, 0x5d, 0xd1, 0xde, 0x49, 0xd3, 0x1b, 0xfe,
+	0xc3, 0x83, 0xd0, 0x62, 0xb2, 0x9a, 0x2a, 0xf6, 0x1c, 0x9a, 0xd7, 0xc9, 0xb4, 0x42, 0x4d, 0x85,
+	0xfd, 0xbb, 0xe6, 0xce, 0xc7, 0x35, 0xe4, 0x33, 0xa5, 0x87, 0x8d, 0xd8, 0x70, 0xec, 0x00, 0x7c,
+	0x14, 0xa9, 0x96, 0x18, 0xf6, 0xb7, 0x2d, 0xbe, 0x98, 0xcf, 0xb0, 0x11, 0x53, 0x9a, 0x3d, 0x76,
+	0x73, 0xf1, 0x35, 0xc7, 0x56, 0x39, 0xca, 0x50, 0x45, 0x8d, 0x0c, 0x3a, 0xd0, 0x2a, 0x75, 0x27,
+	0xfe, 0x0d, 0xb6, 0xd7, 0x1b, 0xb3, 0xa7, 0xd0, 0x92, 0xd9, 0xac, 0x98, 0x3a, 0x85, 0x8b, 0x52,
+	0x3a, 0xe8, 0xc4, 0x59, 0x86, 0xf5, 0xa1, 0x33, 0xc9, 0x67, 0x45, 0x5e, 0x2d, 0x24, 0xee, 0x5a,
+	0xfe, 0x95, 0x0d, 0xbb, 0x13, 0x0b, 0x6e, 0xd0, 0xb6, 0x23, 0xe0, 0x3f, 0x3d, 0x08, 0x6b, 0x65,
+	0xd9, 0x1e, 0x74, 0x32, 0x61, 0x64, 0xe8, 0xe6, 0x3e, 0x1d, 0x73, 0x11, 0xc6, 0x21, 0x94, 0xaa,
+	0xcc, 0xc4, 0xb9, 0x01, 0xf4, 0x9b, 0x0d, 0x1b, 0x71, 0x3d, 0xc8, 0x1e, 0x41, 0x40, 0x46, 0x8a,
+	0xfc, 0xb5, 0x69, 0x25, 0x0a, 0x67, 0x28, 0xd4, 0xb0, 0x11, 0xeb, 0x3c, 0xc9, 0xa6, 0xef, 0x20,
+	0x4f, 0xe7, 0x51, 0xb0, 0x22, 0x7b, 0xc1, 0x52, 0x8e, 0xfa, 0x3b, 0x6e, 0x29, 0xfb, 0x25, 0xfc,
+	0xb7, 0x72, 0x39, 0x76, 0x08, 0xc1, 0x98, 0x2a, 0x79, 0xfb, 0xfe, 0x51, 0xd8, 0xbf, 0x63, 0x2b,
+	0xbd, 0xc3, 0xb9, 0x4e, 0x8f, 0x92, 0xac, 0x8c, 0x35, 0xc0, 0xdf, 0x42, 0xaf, 0x1e, 0x65, 0xdb,
+	0xe0, 0x5f, 0xa2, 0x33, 0x0c, 0x2d, 0xd9, 0x91, 0xb3, 0xc7, 0xd6, 0x4d, 0xc3, 0xb7, 0xbe, 0xe0,
+	0x0f, 0x20, 0x7c, 0x9d, 0xa8, 0xc4, 0xb9, 0x8f, 0x41, 0x70, 0x89, 0x73, 0xa9, 0x35, 0x74, 0x63,
+	0xbd, 0xe6, 0xdf, 0x3d, 0x00, 0xc3, 0x68, 0xeb, 0x1d, 0x42, 0x90, 0x26, 0x2a, 0xb1, 0xef, 0xba,
+	0x63, 0x4b, 0x13, 0xf0, 0x61, 0xfc, 0x15, 0x27, 0x7a, 0x3a, 0x04, 0xfc, 0x53, 0xcb, 0xf5, 0x01,
+	0x96, 0x1d, 0xff, 0x32, 0x00, 0x66, 0x45, 0x52, 0xf3, 0x9e, 0xd1, 0xc3, 0x9f, 0x41, 0x38, 0xaa,
+	0xe4, 0x85, 0xbb, 0xea, 0x7d, 0x00, 0x91, 0xcc, 0x50, 0x16, 0xc9, 0x04, 0xdd, 0x85, 0x6b, 0x11,
+	0x5e, 0x40, 0xcf, 0xe0, 0xb2, 0xc8, 0x85, 0x44, 0xf6, 0x04, 0x5a, 0xc9, 0x64, 0x82, 0x85, 0x5a,
+	0xbb, 0x39, 0x41, 0x27, 0x3a, 0x41, 0x86, 0x36, 0x08, 0xc1, 0x25, 0x92, 0xb6, 0x68, 0xeb, 0x0f,
+	0x38, 0x46, 0x3b, 0x26, 0x8b, 0x0c, 0x5a, 0xe6, 0xe1, 0x79, 0x0f, 0x60, 0x59, 0x8c, 0x73, 0x80,
+	0x25, 0x7d, 0xc3, 0x3f, 0xd6, 0x18, 0xba, 0xc4, 0xac, 0xba, 0xd6, 0xbb, 0xc5, 0xb5, 0x1b, 0xbd,
+	0xcb, 0xd2, 0xa7, 0x5f, 0xa0, 0x4d, 0x3d, 0x4e, 0x45, 0x4a, 0x23, 0x93, 0xae, 0x9c, 0x34, 0xbf,
+	0xad, 0xb8, 0x16, 0x61, 0x11, 0xb4, 0x73, 0xfd, 0x22, 0x52, 0x57, 0xf7, 0x63, 0xb7, 0x65, 0xbb,
+	0xf5, 0x57, 0x5e, 0xc8, 0xff, 0xe5, 0x41, 0xef, 0xa4, 0x9c, 0x5c, 0x64, 0xd7, 0x78, 0x2a, 0x54,
+	0x39, 0xdf, 0xf8, 0x0a, 0x87, 0xb5, 0xe7, 0xdd, 0xc4, 0x83, 0xfe, 0x86, 0x1e, 0x0c, 0x6e, 0xf7,
+	0x60, 0x1b, 0x9a, 0x48, 0x5a, 0xc7, 0x2d, 0x0d, 0xbd, 0xf8, 0x3d, 0x00, 0x67, 0x95, 0x7f, 0x80,
+	0x87, 0x06, 0x00, 0x00,
 }
@@ -14,12 +14,13 @@ var _ = fmt.Errorf
 var _ = math.Inf
 
 type Statement struct {
-	Id        string         `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Publisher string         `protobuf:"bytes,2,opt,name=publisher,proto3" json:"publisher,omitempty"`
-	Namespace string         `protobuf:"bytes,3,opt,name=namespace,proto3" json:"namespace,omitempty"`
-	Body      *StatementBody `protobuf:"bytes,4,opt,name=body" json:"body,omitempty"`
-	Timestamp int64          `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
-	Signature []byte         `protobuf:"bytes,6,opt,name=signature,proto3" json:"signature,omitempty"`
+	Id         string         `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Publisher  string         `protobuf:"bytes,2,opt,name=publisher,proto3" json:"publisher,omitempty"`
+	Namespace  string         `protobuf:"bytes,3,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Body       *StatementBody `protobuf:"bytes,4,opt,name=body" json:"body,omitempty"`
+	Timestamp  int64          `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Signature  []byte         `protobuf:"bytes,6,opt,name=signature,proto3" json:"signature,omitempty"`
+	SigVersion uint32         `protobuf:"varint,7,opt,name=sigVersion,proto3" json:"sigVersion,omitempty"`
 }
 
 func (m *Statement) Reset()                    { *m = Statement{} }
@@ -40,6 +41,7 @@ type StatementBody struct {
 	//	*StatementBody_Compound
 	//	*StatementBody_Envelope
 	//	*StatementBody_Archive
+	//	*StatementBody_Succession
 	Body isStatementBody_Body `protobuf_oneof:"body"`
 }
 
@@ -64,11 +66,15 @@ type StatementBody_Envelope struct {
 type StatementBody_Archive struct {
 	Archive *ArchiveStatement `protobuf:"bytes,4,opt,name=archive,oneof"`
 }
+type StatementBody_Succession struct {
+	Succession *SuccessionStatement `protobuf:"bytes,5,opt,name=succession,oneof"`
+}
 
-func (*StatementBody_Simple) isStatementBody_Body()   {}
-func (*StatementBody_Compound) isStatementBody_Body() {}
-func (*StatementBody_Envelope) isStatementBody_Body() {}
-func (*StatementBody_Archive) isStatementBody_Body()  {}
+func (*StatementBody_Simple) isStatementBody_Body()     {}
+func (*StatementBody_Compound) isStatementBody_Body()   {}
+func (*StatementBody_Envelope) isStatementBody_Body()   {}
+func (*StatementBody_Archive) isStatementBody_Body()    {}
+func (*StatementBody_Succession) isStatementBody_Body() {}
 
 func (m *StatementBody) GetBody() isStatementBody_Body {
 	if m != nil {
@@ -105,6 +111,13 @@ func (m *StatementBody) GetArchive() *ArchiveStatement {
 	return nil
 }
 
+func (m *StatementBody) GetSuccession() *SuccessionStatement {
+	if x, ok := m.GetBody().(*StatementBody_Succession); ok {
+		return x.Succession
+	}
+	return nil
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*StatementBody) XXX_OneofFuncs() (func(msg proto1.Message, b *proto1.Buffer) error, func(msg proto1.Message, tag, wire int, b *proto1.Buffer) (bool, error), func(msg proto1.Message) (n int), []interface{}) {
 	return _StatementBody_OneofMarshaler, _StatementBody_OneofUnmarshaler, _StatementBody_OneofSizer, []interface{}{
@@ -112,6 +125,7 @@ func (*StatementBody) XXX_OneofFuncs() (func(msg proto1.Message, b *proto1.Buffe
 		(*StatementBody_Compound)(nil),
 		(*StatementBody_Envelope)(nil),
 		(*StatementBody_Archive)(nil),
+		(*StatementBody_Succession)(nil),
 	}
 }
 
@@ -139,6 +153,11 @@ func _StatementBody_OneofMarshaler(msg proto1.Message, b *proto1.Buffer) error {
 		if err := b.EncodeMessage(x.Archive); err != nil {
 			return err
 		}
+	case *StatementBody_Succession:
+		_ = b.EncodeVarint(5<<3 | proto1.WireBytes)
+		if err := b.EncodeMessage(x.Succession); err != nil {
+			return err
+		}
 	case nil:
 	default:
 		return fmt.Errorf("StatementBody.Body has unexpected type %T", x)
@@ -181,6 +200,14 @@ func _StatementBody_OneofUnmarshaler(msg proto1.Message, tag, wire int, b *proto
 		err := b.DecodeMessage(msg)
 		m.Body = &StatementBody_Archive{msg}
 		return true, err
+	case 5: // body.succession
+		if wire != proto1.WireBytes {
+			return true, proto1.ErrInternalBadWireType
+		}
+		msg := new(SuccessionStatement)
+		err := b.DecodeMessage(msg)
+		m.Body = &StatementBody_Succession{msg}
+		return true, err
 	default:
 		return false, nil
 	}
@@ -210,6 +237,11 @@ func _StatementBody_OneofSizer(msg proto1.Message) (n int) {
 		n += proto1.SizeVarint(4<<3 | proto1.WireBytes)
 		n += proto1.SizeVarint(uint64(s))
 		n += s
+	case *StatementBody_Succession:
+		s := proto1.Size(x.Succession)
+		n += proto1.SizeVarint(5<<3 | proto1.WireBytes)
+		n += proto1.SizeVarint(uint64(s))
+		n += s
 	case nil:
 	default:
 		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
@@ -269,6 +301,16 @@ func (m *ArchiveStatement) String() string            { return proto1.CompactTex
 func (*ArchiveStatement) ProtoMessage()               {}
 func (*ArchiveStatement) Descriptor() ([]byte, []int) { return fileDescriptorStmt, []int{5} }
 
+type SuccessionStatement struct {
+	Successor string `protobuf:"bytes,1,opt,name=successor,proto3" json:"successor,omitempty"`
+	Signature []byte `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *SuccessionStatement) Reset()                    { *m = SuccessionStatement{} }
+func (m *SuccessionStatement) String() string            { return proto1.CompactTextString(m) }
+func (*SuccessionStatement) ProtoMessage()               {}
+func (*SuccessionStatement) Descriptor() ([]byte, []int) { return fileDescriptorStmt, []int{6} }
+
 func init() {
 	proto1.RegisterType((*Statement)(nil), "proto.Statement")
 	proto1.RegisterType((*StatementBody)(nil), "proto.StatementBody")
@@ -276,33 +318,38 @@ func init() {
 	proto1.RegisterType((*CompoundStatement)(nil), "proto.CompoundStatement")
 	proto1.RegisterType((*EnvelopeStatement)(nil), "proto.EnvelopeStatement")
 	proto1.RegisterType((*ArchiveStatement)(nil), "proto.ArchiveStatement")
+	proto1.RegisterType((*SuccessionStatement)(nil), "proto.SuccessionStatement")
 }
 
 func init() { proto1.RegisterFile("stmt.proto", fileDescriptorStmt) }
 
 var fileDescriptorStmt = []byte{
-	// 363 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x74, 0x92, 0xcd, 0x6a, 0xdb, 0x40,
-	0x10, 0xc7, 0xbb, 0x92, 0xac, 0x56, 0xe3, 0x7e, 0xd8, 0x4b, 0x71, 0xf7, 0xd0, 0x83, 0x10, 0x3d,
-	0x88, 0x1e, 0x4c, 0xb1, 0xa1, 0x90, 0x53, 0x88, 0x43, 0x20, 0x67, 0xe5, 0x09, 0xf4, 0x31, 0xb1,
-	0x15, 0x2c, 0xed, 0xa2, 0x5d, 0x1b, 0xfc, 0x70, 0x79, 0xa5, 0x3c, 0x43, 0xd8, 0x0f, 0x4b, 0xfe,
-	0x20, 0x27, 0x8d, 0x7e, 0xf3, 0xff, 0xcf, 0x30, 0x33, 0x0b, 0x20, 0x55, 0xa3, 0xe6, 0xa2, 0xe3,
-	0x8a, 0xd3, 0x91, 0xf9, 0x24, 0xaf, 0x04, 0xa2, 0x27, 0x95, 0x2b, 0x6c, 0xb0, 0x55, 0xf4, 0x3b,
-	0x78, 0x75, 0xc5, 0x48, 0x4c, 0xd2, 0x28, 0xf3, 0xea, 0x8a, 0xfe, 0x86, 0x48, 0xec, 0x8a, 0x6d,
-	0x2d, 0x37, 0xd8, 0x31, 0xcf, 0xe0, 0x01, 0xe8, 0x6c, 0x9b, 0x37, 0x28, 0x45, 0x5e, 0x22, 0xf3,
-	0x6d, 0xb6, 0x07, 0x34, 0x85, 0xa0, 0xe0, 0xd5, 0x81, 0x05, 0x31, 0x49, 0xc7, 0x8b, 0x9f, 0xb6,
-	0xed, 0xbc, 0xef, 0xb5, 0xe2, 0xd5, 0x21, 0x33, 0x0a, 0x5d, 0x47, 0xd5, 0x0d, 0x4a, 0x95, 0x37,
-	0x82, 0x8d, 0x62, 0x92, 0xfa, 0xd9, 0x00, 0x74, 0x56, 0xd6, 0xeb, 0x36, 0x57, 0xbb, 0x0e, 0x59,
-	0x18, 0x93, 0xf4, 0x6b, 0x36, 0x80, 0xe4, 0x8d, 0xc0, 0xb7, 0xb3, 0x9a, 0xf4, 0x1f, 0x84, 0xb2,
-	0x6e, 0xc4, 0x16, 0xcd, 0x1c, 0xe3, 0xc5, 0xec, 0xd8, 0xd9, 0xc0, 0x5e, 0xfb, 0xf8, 0x29, 0x73,
-	0x3a, 0xfa, 0x1f, 0xbe, 0x94, 0xbc, 0x11, 0x7c, 0xd7, 0x56, 0x66, 0xc8, 0xf1, 0x82, 0x39, 0xcf,
-	0xbd, 0xc3, 0xa7, 0xae, 0x5e, 0xab, 0x7d, 0xd8, 0xee, 0x71, 0xcb, 0x85, 0x1d, 0x7f, 0xf0, 0x3d,
-	0x38, 0x7c, 0xe6, 0x3b, 0x6a, 0xe9, 0x12, 0x3e, 0xe7, 0x5d, 0xb9, 0xa9, 0xf7, 0xe8, 0x96, 0xf3,
-	0xcb, 0xd9, 0xee, 0x2c, 0x3d, 0x75, 0x1d, 0x95, 0xab, 0xd0, 0xae, 0x33, 0x41, 0xf8, 0x71, 0x31,
-	0x09, 0x9d, 0x41, 0xc8, 0x8b, 0x17, 0x2c, 0x95, 0xbb, 0x9c, 0xfb, 0xa3, 0x14, 0x82, 0x0e, 0x9f,
-	0x25, 0xf3, 0x62, 0x3f, 0x8d, 0x32, 0x13, 0x6b, 0xa6, 0xf2, 0xb5, 0x64, 0xbe, 0x65, 0x3a, 0xd6,
-	0xac, 0x42, 0x21, 0x59, 0x60, 0x99, 0x8e, 0x93, 0x5b, 0x98, 0x5e, 0x0d, 0x4f, 0xff, 0xba, 0x93,
-	0x92, 0xd8, 0xff, 0x78, 0xb1, 0xf6, 0xa8, 0xc9, 0x0d, 0x4c, 0xaf, 0xb6, 0x40, 0xff, 0x9c, 0x15,
-	0x98, 0x5c, 0xbe, 0x09, 0x67, 0xa5, 0x30, 0xb9, 0xdc, 0x44, 0x11, 0x1a, 0xe9, 0xf2, 0x3d, 0x00,
-	0x00, 0xff, 0xff, 0xc4, 0xae, 0x82, 0x5f, 0xc3, 0x02, 0x00, 0x00,
+	// 419 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x74, 0x92, 0x5f, 0x6b, 0xd4, 0x40,
+	0x14, 0xc5, 0x9d, 0x64, 0x37, 0x35, 0x77, 0xad, 0xb6, 0xa3, 0xd4, 0x41, 0x44, 0x42, 0xf0, 0x21,
+	0xf8, 0x50, 0x64, 0x0b, 0x82, 0x20, 0x88, 0x15, 0xc1, 0x57, 0x23, 0xf8, 0x9e, 0x3f, 0xd7, 0xed,
+	0xc8, 0x26, 0x33, 0x64, 0x26, 0x85, 0x7e, 0x27, 0xbf, 0x92, 0xdf, 0x45, 0xe6, 0x4f, 0xfe, 0x56,
+	0x9f, 0x76, 0xf6, 0x77, 0xcf, 0xc9, 0xcd, 0x9c, 0x1c, 0x00, 0xa5, 0x1b, 0x7d, 0x29, 0x3b, 0xa1,
+	0x05, 0xdd, 0xda, 0x9f, 0xf4, 0x0f, 0x81, 0xf8, 0xbb, 0x2e, 0x34, 0x36, 0xd8, 0x6a, 0xfa, 0x18,
+	0x02, 0x5e, 0x33, 0x92, 0x90, 0x2c, 0xce, 0x03, 0x5e, 0xd3, 0x97, 0x10, 0xcb, 0xbe, 0x3c, 0x72,
+	0x75, 0x83, 0x1d, 0x0b, 0x2c, 0x9e, 0x80, 0x99, 0xb6, 0x45, 0x83, 0x4a, 0x16, 0x15, 0xb2, 0xd0,
+	0x4d, 0x47, 0x40, 0x33, 0xd8, 0x94, 0xa2, 0xbe, 0x63, 0x9b, 0x84, 0x64, 0xbb, 0xfd, 0x33, 0xb7,
+	0xf6, 0x72, 0xdc, 0x75, 0x2d, 0xea, 0xbb, 0xdc, 0x2a, 0xcc, 0x73, 0x34, 0x6f, 0x50, 0xe9, 0xa2,
+	0x91, 0x6c, 0x9b, 0x90, 0x2c, 0xcc, 0x27, 0x60, 0xa6, 0x8a, 0x1f, 0xda, 0x42, 0xf7, 0x1d, 0xb2,
+	0x28, 0x21, 0xd9, 0xa3, 0x7c, 0x02, 0xf4, 0x15, 0x80, 0xe2, 0x87, 0x1f, 0xd8, 0x29, 0x2e, 0x5a,
+	0x76, 0x92, 0x90, 0xec, 0x34, 0x9f, 0x91, 0xf4, 0x77, 0x00, 0xa7, 0x8b, 0x9d, 0xf4, 0x2d, 0x44,
+	0x8a, 0x37, 0xf2, 0x88, 0xf6, 0x9e, 0xbb, 0xfd, 0xc5, 0xf0, 0x66, 0x16, 0x8e, 0xda, 0xaf, 0x0f,
+	0x72, 0xaf, 0xa3, 0xef, 0xe0, 0x61, 0x25, 0x1a, 0x29, 0xfa, 0xb6, 0xb6, 0x21, 0xec, 0xf6, 0xcc,
+	0x7b, 0x3e, 0x7b, 0x3c, 0x77, 0x8d, 0x5a, 0xe3, 0xc3, 0xf6, 0x16, 0x8f, 0x42, 0xba, 0x78, 0x26,
+	0xdf, 0x17, 0x8f, 0x17, 0xbe, 0x41, 0x4b, 0xaf, 0xe0, 0xa4, 0xe8, 0xaa, 0x1b, 0x7e, 0x8b, 0x3e,
+	0xbc, 0xe7, 0xde, 0xf6, 0xc9, 0xd1, 0xb9, 0x6b, 0x50, 0xd2, 0x0f, 0x00, 0xaa, 0xaf, 0x2a, 0x54,
+	0x36, 0x88, 0xad, 0xf5, 0xbd, 0x18, 0xae, 0x36, 0x0e, 0xe6, 0xd6, 0x99, 0xfe, 0x3a, 0x72, 0x1f,
+	0x2b, 0x45, 0x78, 0xb2, 0xca, 0x81, 0x5e, 0x40, 0x24, 0xca, 0x5f, 0x58, 0x69, 0xdf, 0x0b, 0xff,
+	0x8f, 0x52, 0xd8, 0x74, 0xf8, 0x53, 0xb1, 0x20, 0x09, 0xb3, 0x38, 0xb7, 0x67, 0xc3, 0x74, 0x71,
+	0x50, 0x2c, 0x74, 0xcc, 0x9c, 0x0d, 0xab, 0x51, 0x2a, 0xb6, 0x71, 0xcc, 0x9c, 0xd3, 0x8f, 0x70,
+	0x7e, 0x2f, 0x3a, 0xfa, 0xc6, 0x17, 0x86, 0x24, 0xe1, 0xff, 0x3f, 0x8b, 0xab, 0x4c, 0xfa, 0x1e,
+	0xce, 0xef, 0x65, 0x48, 0x5f, 0x2f, 0x1e, 0x70, 0xb6, 0x6e, 0x9c, 0xb7, 0x52, 0x38, 0x5b, 0xe7,
+	0x98, 0x7e, 0x83, 0xa7, 0xff, 0xc8, 0xc8, 0x56, 0xcf, 0x61, 0xd1, 0xf9, 0xdb, 0x4f, 0x60, 0x59,
+	0xcc, 0x60, 0x55, 0xcc, 0x32, 0xb2, 0xdb, 0xaf, 0xfe, 0x0e, 0x00, 0xa9, 0x22, 0x5c, 0xdc, 0x74,
+	0x03, 0x00, 0x00,
 }